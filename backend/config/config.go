@@ -3,6 +3,9 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -13,6 +16,33 @@ type Config struct {
 	DBName     string
 	JWTSecret  string
 	Port       string
+
+	UploadTempDir    string        // Partial resumable uploads
+	UploadExpiry     time.Duration // How long an idle resumable upload is kept
+	MaxResumableSize int64         // Largest Upload-Length accepted, in bytes
+
+	MaxUploadSize     int64         // Largest single uploaded file, in bytes
+	MaxMediaDuration  time.Duration // Longest accepted audio/video file
+	AllowedMIMETypes  []string      // Sniffed content types accepted on upload
+	AllowedContainers []string      // ffprobe format names accepted on upload
+	AllowedCodecs     []string      // ffprobe codec names accepted on upload
+
+	DefaultQuotaBytes int64 // Per-user storage quota, 0 for unlimited
+
+	ProjectTrashRetention   time.Duration // How long deleted projects can be restored
+	ProjectVersionRetention time.Duration // How long unlabeled timeline snapshots are kept
+
+	StorageBackend     string // "local" or "s3"
+	LocalStorageRoot   string // Directory the local backend stores files in
+	URLSigningSecret   string // HMAC key for signed media URLs
+	ServeStaticUploads bool   // Also expose the local root publicly under /uploads
+	RenderTempDir      string // Scratch space for ffmpeg inputs and output
+	S3Endpoint         string
+	S3Region           string
+	S3Bucket           string
+	S3AccessKey        string
+	S3SecretKey        string
+	S3PathStyle        bool // Required for MinIO
 }
 
 // LoadConfig reads configuration from environment variables or .env file
@@ -28,12 +58,52 @@ func LoadConfig() *Config {
 		DBName:     getEnv("MONGO_DB_NAME", "video_editor"),
 		JWTSecret:  getEnv("JWT_SECRET", "supersecretjwtkey"), // IMPORTANT: Change this in production!
 		Port:       getEnv("PORT", "8080"),
+
+		UploadTempDir:    getEnv("UPLOAD_TEMP_DIR", "tmp/uploads"),
+		UploadExpiry:     getEnvDuration("UPLOAD_EXPIRY", 24*time.Hour),
+		MaxResumableSize: getEnvInt64("MAX_RESUMABLE_SIZE", 50<<30), // 50 GiB
+
+		MaxUploadSize:    getEnvInt64("MAX_UPLOAD_SIZE", 50<<30), // 50 GiB
+		MaxMediaDuration: getEnvDuration("MAX_MEDIA_DURATION", 4*time.Hour),
+		AllowedMIMETypes: getEnvList("ALLOWED_MIME_TYPES",
+			"video/mp4,video/quicktime,video/webm,video/x-matroska,video/avi,"+
+				"audio/mpeg,audio/wave,audio/flac,audio/aac,audio/ogg,audio/mp4,"+
+				"image/jpeg,image/png,image/gif,image/webp"),
+		AllowedContainers: getEnvList("ALLOWED_CONTAINERS",
+			"mov,mp4,m4a,3gp,matroska,webm,avi,mp3,wav,flac,aac,ogg,"+
+				"image2,png_pipe,jpeg_pipe,webp_pipe,gif"),
+		AllowedCodecs: getEnvList("ALLOWED_CODECS",
+			"h264,hevc,vp8,vp9,av1,prores,mpeg4,mjpeg,png,webp,gif,"+
+				"aac,mp3,opus,vorbis,flac,alac,pcm_s16le,pcm_s24le,pcm_s32le,pcm_f32le"),
+
+		DefaultQuotaBytes: getEnvInt64("DEFAULT_QUOTA_BYTES", 100<<30), // 100 GiB
+
+		ProjectTrashRetention:   getEnvDuration("PROJECT_TRASH_RETENTION", 30*24*time.Hour),
+		ProjectVersionRetention: getEnvDuration("PROJECT_VERSION_RETENTION", 90*24*time.Hour),
+
+		StorageBackend:     getEnv("STORAGE_BACKEND", "local"),
+		LocalStorageRoot:   getEnv("STORAGE_LOCAL_ROOT", "uploads"),
+		URLSigningSecret:   getEnv("URL_SIGNING_SECRET", ""),
+		ServeStaticUploads: getEnv("SERVE_STATIC_UPLOADS", "false") == "true",
+		RenderTempDir:      getEnv("RENDER_TEMP_DIR", "tmp/render"),
+		S3Endpoint:         getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+		S3Region:           getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:           getEnv("S3_BUCKET", ""),
+		S3AccessKey:        getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:        getEnv("S3_SECRET_KEY", ""),
+		S3PathStyle:        getEnv("S3_PATH_STYLE", "false") == "true",
 	}
 
 	// Basic validation
 	if cfg.JWTSecret == "supersecretjwtkey" {
 		log.Println("WARNING: Using default JWT_SECRET. Please change this in production!")
 	}
+	if cfg.URLSigningSecret == "" {
+		cfg.URLSigningSecret = cfg.JWTSecret
+	}
+	if cfg.ServeStaticUploads {
+		log.Println("WARNING: SERVE_STATIC_UPLOADS exposes every stored file without authentication.")
+	}
 
 	return cfg
 }
@@ -44,3 +114,36 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		d, err := time.ParseDuration(value)
+		if err == nil {
+			return d
+		}
+		log.Printf("Invalid duration for %s: %q, using %s", key, value, defaultValue)
+	}
+	return defaultValue
+}
+
+func getEnvInt64(key string, defaultValue int64) int64 {
+	if value, exists := os.LookupEnv(key); exists {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err == nil {
+			return n
+		}
+		log.Printf("Invalid integer for %s: %q, using %d", key, value, defaultValue)
+	}
+	return defaultValue
+}
+
+// getEnvList reads a comma-separated list, ignoring empty entries
+func getEnvList(key, defaultValue string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"video-editor/services"
+	"video-editor/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultShareExpiry and maxShareExpiry bound the lifetime of share links
+	defaultShareExpiry = 24 * time.Hour
+	maxShareExpiry     = 7 * 24 * time.Hour
+
+	// redirectExpiry is the lifetime of the presigned URL an authenticated
+	// request is redirected to when the backend serves objects itself
+	redirectExpiry = 15 * time.Minute
+)
+
+// registerMediaRoutes mounts the routes that serve stored files. Anyone holding
+// a signed URL may fetch the object under /media until it expires; the asset
+// and export routes require authentication and check ownership.
+func registerMediaRoutes(router *gin.Engine, authorized *gin.RouterGroup, store storage.Backend, signer *storage.URLSigner, assetService *services.AssetService) {
+	router.GET(signer.BaseURL()+"/*key", func(c *gin.Context) {
+		key := strings.TrimPrefix(c.Param("key"), "/")
+		err := signer.Verify(key, c.Query("expires"), c.Query("sig"))
+		if errors.Is(err, storage.ErrURLExpired) {
+			c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or missing signature"})
+			return
+		}
+		serveObject(c, store, key, path.Base(key))
+	})
+
+	authorized.GET("/assets/:id/content", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		asset, err := assetService.GetAsset(c.Param("id"), userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found or unauthorized"})
+			return
+		}
+		serveObject(c, store, asset.StorageKey, asset.Filename)
+	})
+
+	authorized.POST("/assets/:id/share", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		asset, err := assetService.GetAsset(c.Param("id"), userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found or unauthorized"})
+			return
+		}
+		shareObject(c, store, asset.StorageKey)
+	})
+
+	authorized.GET("/exports/:name", func(c *gin.Context) {
+		key, ok := exportKey(c.GetString("user_id"), c.Param("name"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+			return
+		}
+		serveObject(c, store, key, c.Param("name"))
+	})
+
+	authorized.POST("/exports/:name/share", func(c *gin.Context) {
+		key, ok := exportKey(c.GetString("user_id"), c.Param("name"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+			return
+		}
+		if _, err := store.Stat(key); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+			return
+		}
+		shareObject(c, store, key)
+	})
+}
+
+// exportKey returns the storage key of one of the user's exports. Only plain
+// file names are accepted so the key cannot leave the user's exports.
+func exportKey(userID, name string) (string, bool) {
+	if name == "" || name != path.Base(name) || strings.HasPrefix(name, ".") {
+		return "", false
+	}
+	return userID + "/exports/" + name, true
+}
+
+// serveObject streams a stored object with Range support. Objects on local
+// disk are served directly; for other backends the client is redirected to a
+// short-lived presigned URL, which the provider serves with Range support.
+func serveObject(c *gin.Context, store storage.Backend, key, filename string) {
+	lp, ok := store.(storage.LocalPather)
+	if !ok {
+		url, err := store.SignedURL(key, redirectExpiry)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Redirect(http.StatusFound, url)
+		return
+	}
+
+	localPath, err := lp.LocalPath(key)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=3600")
+	if c.Query("download") == "1" {
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	}
+	// ServeContent handles Range, If-Range and conditional requests
+	http.ServeContent(c.Writer, c.Request, filename, info.ModTime(), f)
+}
+
+// shareObject responds with a signed URL for key. The optional JSON body
+// {"expires_in": seconds} sets its lifetime, capped at maxShareExpiry.
+func shareObject(c *gin.Context, store storage.Backend, key string) {
+	var req struct {
+		ExpiresIn int64 `json:"expires_in"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	expiry := defaultShareExpiry
+	if req.ExpiresIn > 0 {
+		expiry = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if expiry > maxShareExpiry {
+		expiry = maxShareExpiry
+	}
+
+	url, err := store.SignedURL(key, expiry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"url": url, "expires_at": time.Now().Add(expiry).UTC()})
+}
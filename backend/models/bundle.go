@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// BundleSchemaVersion is the version of the project bundle layout written by
+// this server. Bundles of a newer version are refused on import. Version 2
+// added the manifest's list of omitted references.
+const BundleSchemaVersion = 2
+
+// Paths of the fixed files inside a project bundle
+const (
+	BundleManifestPath = "manifest.json"
+	BundleTimelinePath = "timeline.json"
+)
+
+// BundleManifest lists the files of a project bundle with their checksums
+type BundleManifest struct {
+	SchemaVersion int          `json:"schema_version"`
+	ProjectName   string       `json:"project_name"`
+	ExportedAt    time.Time    `json:"exported_at"`
+	Files         []BundleFile `json:"files"`
+	// Omitted lists what the timeline refers to that the bundle does not
+	// carry, which the importing side must provide itself. Empty before
+	// version 2.
+	Omitted []BundleOmission `json:"omitted"`
+}
+
+// BundleOmission is something clips refer to by name that is not bundled
+type BundleOmission struct {
+	Kind    string   `json:"kind"` // "font"
+	Name    string   `json:"name"` // e.g. the font family
+	ClipIDs []string `json:"clip_ids"`
+	Reason  string   `json:"reason"`
+}
+
+// BundleFile is one file of a project bundle
+type BundleFile struct {
+	Path     string `json:"path"`               // Slash-separated, relative to the bundle root
+	Kind     string `json:"kind"`               // "timeline" or "media"
+	AssetID  string `json:"asset_id,omitempty"` // Asset the timeline's clips refer to it by
+	Filename string `json:"filename,omitempty"` // Original filename of the asset
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+// BundleTimeline is the content of a bundle's timeline file
+type BundleTimeline struct {
+	SchemaVersion int       `json:"schema_version"`
+	Name          string    `json:"name"`
+	Timeline      *Timeline `json:"timeline"`
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MediaAsset represents an uploaded media file and its probed metadata
+type MediaAsset struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID     string             `bson:"user_id" json:"user_id"`     // Owner of the asset
+	Filename   string             `bson:"filename" json:"filename"`   // Original client filename
+	StorageKey string             `bson:"storage_key" json:"-"`       // Key of the file in the storage backend
+	BlobID     primitive.ObjectID `bson:"blob_id,omitempty" json:"-"` // Shared stored file, see MediaBlob
+	SHA256     string             `bson:"sha256,omitempty" json:"sha256,omitempty"`
+	URL        string             `bson:"-" json:"url"`                 // Signed URL, filled in when the asset is read
+	ProxyURL   string             `bson:"-" json:"proxy_url,omitempty"` // Low-resolution preview, once generated
+	PosterURL  string             `bson:"-" json:"poster_url,omitempty"`
+	Size       int64              `bson:"size" json:"size"`                             // Size in bytes
+	Type       string             `bson:"type" json:"type"`                             // "video", "audio", "image" or "unknown"
+	Metadata   *MediaMetadata     `bson:"metadata,omitempty" json:"metadata,omitempty"` // As reported by ffprobe
+	Tags       []string           `bson:"tags" json:"tags"`
+	Derived    []DerivedFile      `bson:"derived,omitempty" json:"derived,omitempty"`   // Thumbnails, proxies, etc.
+	Scenes     *SceneAnalysis     `bson:"scenes,omitempty" json:"scenes,omitempty"`     // Shot changes, once detected
+	Silences   *SilenceAnalysis   `bson:"silences,omitempty" json:"silences,omitempty"` // Silent stretches, once detected
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// Kinds of derived files
+const (
+	DerivedProxy         = "proxy"          // Low-resolution H.264 for preview
+	DerivedPoster        = "poster"         // Single representative frame
+	DerivedSprite        = "sprite"         // Tiled thumbnails for scrubbing
+	DerivedThumbnailsVTT = "thumbnails_vtt" // WebVTT index into the sprite
+	DerivedWaveform      = "waveform"       // Audio peaks, suffixed with the zoom level
+)
+
+// DerivedFile is a file generated from an asset, removed together with it
+type DerivedFile struct {
+	Kind       string    `bson:"kind" json:"kind"` // One of the Derived* kinds
+	StorageKey string    `bson:"storage_key" json:"-"`
+	URL        string    `bson:"-" json:"url"`
+	Size       int64     `bson:"size" json:"size"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+}
+
+// SceneAnalysis holds the shot changes detected in a video. Boundaries are
+// kept down to Threshold, so any higher threshold can be served from them.
+type SceneAnalysis struct {
+	Threshold  float64         `bson:"threshold" json:"threshold"`
+	Boundaries []SceneBoundary `bson:"boundaries" json:"boundaries"`
+	AnalyzedAt time.Time       `bson:"analyzed_at" json:"analyzed_at"`
+}
+
+// SceneBoundary is the start of a new scene
+type SceneBoundary struct {
+	Time  float64 `bson:"time" json:"time"`   // In seconds from the start of the asset
+	Score float64 `bson:"score" json:"score"` // Confidence of the cut, 0 to 1
+}
+
+// SilenceAnalysis holds the silent stretches of an asset's audio at a noise
+// level. Intervals are kept down to MinDuration, so any longer minimum can be
+// served from them.
+type SilenceAnalysis struct {
+	NoiseDB     float64           `bson:"noise_db" json:"noise_db"`         // Level below which audio counts as silent
+	MinDuration float64           `bson:"min_duration" json:"min_duration"` // In seconds
+	Intervals   []SilenceInterval `bson:"intervals" json:"intervals"`
+	AnalyzedAt  time.Time         `bson:"analyzed_at" json:"analyzed_at"`
+}
+
+// SilenceInterval is a silent stretch, in seconds from the start of the asset
+type SilenceInterval struct {
+	Start float64 `bson:"start" json:"start"`
+	End   float64 `bson:"end" json:"end"`
+}
+
+// MediaMetadata holds the container-level information reported by ffprobe
+type MediaMetadata struct {
+	FormatName string        `bson:"format_name" json:"format_name"` // e.g. "mov,mp4,m4a,3gp,3g2,mj2"
+	Duration   float64       `bson:"duration" json:"duration"`       // In seconds
+	BitRate    int64         `bson:"bit_rate" json:"bit_rate"`       // Overall bitrate in bits/s
+	Streams    []MediaStream `bson:"streams" json:"streams"`
+
+	// Timecode of the first frame, as stamped by cameras and editors, e.g. "01:00:00:00"
+	StartTimecode string `bson:"start_timecode,omitempty" json:"start_timecode,omitempty"`
+
+	// Summary of the primary video stream
+	Width     int     `bson:"width,omitempty" json:"width,omitempty"`
+	Height    int     `bson:"height,omitempty" json:"height,omitempty"`
+	FrameRate float64 `bson:"frame_rate,omitempty" json:"frame_rate,omitempty"`
+	Rotation  int     `bson:"rotation,omitempty" json:"rotation,omitempty"` // Degrees, as stored in the display matrix
+
+	// Summary of the primary audio stream
+	SampleRate    int    `bson:"sample_rate,omitempty" json:"sample_rate,omitempty"`
+	Channels      int    `bson:"channels,omitempty" json:"channels,omitempty"`
+	ChannelLayout string `bson:"channel_layout,omitempty" json:"channel_layout,omitempty"`
+}
+
+// MediaStream describes a single stream inside a media container
+type MediaStream struct {
+	Index         int     `bson:"index" json:"index"`
+	CodecType     string  `bson:"codec_type" json:"codec_type"` // "video", "audio", "subtitle", "data"
+	CodecName     string  `bson:"codec_name" json:"codec_name"` // e.g. "h264", "aac"
+	Profile       string  `bson:"profile,omitempty" json:"profile,omitempty"`
+	Width         int     `bson:"width,omitempty" json:"width,omitempty"`
+	Height        int     `bson:"height,omitempty" json:"height,omitempty"`
+	PixelFormat   string  `bson:"pixel_format,omitempty" json:"pixel_format,omitempty"`
+	FrameRate     float64 `bson:"frame_rate,omitempty" json:"frame_rate,omitempty"`
+	Rotation      int     `bson:"rotation,omitempty" json:"rotation,omitempty"`
+	SampleRate    int     `bson:"sample_rate,omitempty" json:"sample_rate,omitempty"`
+	Channels      int     `bson:"channels,omitempty" json:"channels,omitempty"`
+	ChannelLayout string  `bson:"channel_layout,omitempty" json:"channel_layout,omitempty"`
+	BitRate       int64   `bson:"bit_rate,omitempty" json:"bit_rate,omitempty"`
+	Duration      float64 `bson:"duration,omitempty" json:"duration,omitempty"`
+	AttachedPic   bool    `bson:"attached_pic,omitempty" json:"attached_pic,omitempty"` // Cover art rather than real video
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MediaBlob is a stored file shared by every asset of a user with the same content
+type MediaBlob struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID     string             `bson:"user_id" json:"user_id"`
+	SHA256     string             `bson:"sha256" json:"sha256"` // Hex digest of the content
+	StorageKey string             `bson:"storage_key" json:"-"`
+	Size       int64              `bson:"size" json:"size"`
+	RefCount   int                `bson:"ref_count" json:"ref_count"` // Number of assets using the blob
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -8,15 +8,20 @@ import (
 
 // Project represents a video editing project
 type Project struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	UserID    string             `bson:"user_id" json:"user_id"` // Owner of the project
-	Name      string             `bson:"name" json:"name"`
-	VideoURL  string             `bson:"video_url" json:"video_url"`                       // URL to the original video in cloud storage
-	Edits     []EditOperation    `bson:"edits" json:"edits"`                               // Array of editing operations
-	Status    string             `bson:"status" json:"status"`                             // e.g., "draft", "processing", "completed"
-	OutputURL string             `bson:"output_url,omitempty" json:"output_url,omitempty"` // URL to the processed video
-	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID        string             `bson:"user_id" json:"user_id"`                                 // Owner of the project
+	Collaborators []string           `bson:"collaborators,omitempty" json:"collaborators,omitempty"` // Users who may edit it too
+	Name          string             `bson:"name" json:"name"`
+	VideoURL      string             `bson:"video_url" json:"video_url"`                       // URL to the original video in cloud storage
+	Edits         []EditOperation    `bson:"edits" json:"edits"`                               // Array of editing operations
+	Timeline      *Timeline          `bson:"timeline,omitempty" json:"timeline,omitempty"`     // Clips arranged in the editor
+	Revision      int64              `bson:"timeline_revision" json:"revision"`                // Incremented by every timeline write
+	Status        string             `bson:"status" json:"status"`                             // e.g., "draft", "processing", "completed"
+	OutputURL     string             `bson:"output_url,omitempty" json:"output_url,omitempty"` // URL to the processed video
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+	DeletedAt     *time.Time         `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"` // Set while the project is in the trash
+	PurgeAt       *time.Time         `bson:"-" json:"purge_at,omitempty"`                      // When a trashed project is removed for good
 }
 
 // EditOperation defines a single editing step
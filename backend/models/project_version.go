@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProjectVersion is an immutable snapshot of a project's timeline, taken every
+// time the timeline is written
+type ProjectVersion struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	ProjectID    primitive.ObjectID `bson:"project_id" json:"project_id"`
+	Revision     int64              `bson:"revision" json:"revision"`                               // Timeline revision the snapshot holds
+	AuthorID     string             `bson:"author_id" json:"author_id"`                             // User who made the change
+	Label        string             `bson:"label,omitempty" json:"label,omitempty"`                 // Labeled versions are never thinned
+	RestoredFrom int64              `bson:"restored_from,omitempty" json:"restored_from,omitempty"` // Revision this one restored, if any
+	Timeline     *Timeline          `bson:"timeline,omitempty" json:"timeline,omitempty"`           // Left out of listings
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+}
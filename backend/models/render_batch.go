@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RenderBatch is a set of exports rendered from one template, one per row
+// of slot values
+type RenderBatch struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID     string             `bson:"user_id" json:"user_id"`
+	TemplateID string             `bson:"template_id" json:"template_id"`
+	Name       string             `bson:"name" json:"name"`
+	Status     string             `bson:"status" json:"status"` // "processing", "completed", "completed_with_errors" or "failed"
+	Total      int                `bson:"total" json:"total"`
+	Completed  int                `bson:"completed" json:"completed"`
+	Failed     int                `bson:"failed" json:"failed"`
+	Progress   float64            `bson:"-" json:"progress"` // Percentage of rows finished either way
+	Rows       []BatchRow         `bson:"rows" json:"rows,omitempty"`
+	ZipKey     string             `bson:"zip_key,omitempty" json:"-"` // Archive of every completed row
+	ZipURL     string             `bson:"-" json:"zip_url,omitempty"` // Signed, filled in when the batch is read
+	FinishedAt *time.Time         `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// BatchRow is one export of a batch
+type BatchRow struct {
+	Index     int               `bson:"index" json:"index"`
+	Name      string            `bson:"name" json:"name"` // File name of the output inside the zip
+	Values    map[string]string `bson:"values" json:"values"`
+	JobID     string            `bson:"job_id,omitempty" json:"job_id,omitempty"`
+	Status    string            `bson:"status" json:"status"` // "pending", "completed" or "failed"
+	OutputKey string            `bson:"output_key,omitempty" json:"-"`
+	OutputURL string            `bson:"-" json:"output_url,omitempty"`
+	Error     string            `bson:"error,omitempty" json:"error,omitempty"`
+}
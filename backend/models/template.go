@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Template visibilities
+const (
+	TemplatePrivate   = "private"   // Only the owner can use it
+	TemplateWorkspace = "workspace" // Every member of WorkspaceID can use it
+)
+
+// Kinds of template slots
+const (
+	SlotAsset = "asset" // Filled with a media asset of the clips' type
+	SlotText  = "text"  // Filled with the text of text clips
+)
+
+// ProjectTemplate is a timeline that new projects can start from, with
+// slots for the media and text that change from one project to the next
+type ProjectTemplate struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	OwnerID         string             `bson:"owner_id" json:"owner_id"`
+	Name            string             `bson:"name" json:"name"`
+	Description     string             `bson:"description,omitempty" json:"description,omitempty"`
+	Visibility      string             `bson:"visibility" json:"visibility"` // TemplatePrivate or TemplateWorkspace
+	WorkspaceID     string             `bson:"workspace_id,omitempty" json:"workspace_id,omitempty"`
+	Slots           []TemplateSlot     `bson:"slots" json:"slots"`
+	Timeline        *Timeline          `bson:"timeline" json:"timeline"`
+	SourceProjectID primitive.ObjectID `bson:"source_project_id,omitempty" json:"source_project_id,omitempty"` // Project it was published from
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// TemplateSlot is a placeholder filled in when a project is created from a
+// template, e.g. "logo" or "headline"
+type TemplateSlot struct {
+	Name     string   `bson:"name" json:"name"` // Key of the value that fills it
+	Label    string   `bson:"label,omitempty" json:"label,omitempty"`
+	Kind     string   `bson:"kind" json:"kind"`         // SlotAsset or SlotText
+	ClipIDs  []string `bson:"clip_ids" json:"clip_ids"` // Timeline clips the value goes into
+	Required bool     `bson:"required" json:"required"`
+}
@@ -0,0 +1,59 @@
+package models
+
+// Timeline is the arrangement of clips in a project, in the shape the editor
+// sends for export
+type Timeline struct {
+	MediaItems  []TimelineItem `bson:"media_items" json:"mediaItems"`
+	Duration    float64        `bson:"duration" json:"duration"`        // In seconds
+	AspectRatio string         `bson:"aspect_ratio" json:"aspectRatio"` // e.g. "16:9"
+	Transitions []Transition   `bson:"transitions,omitempty" json:"transitions,omitempty"`
+}
+
+// TimelineItem is a single clip on the timeline
+type TimelineItem struct {
+	ID          string  `bson:"id" json:"id"`
+	Type        string  `bson:"type" json:"type"` // "video", "audio", "image" or "text"
+	Name        string  `bson:"name,omitempty" json:"name,omitempty"`
+	AssetID     string  `bson:"asset_id,omitempty" json:"assetId,omitempty"` // Media asset the clip plays
+	URL         string  `bson:"url,omitempty" json:"url,omitempty"`          // Preview URL; only used for clips saved before asset IDs
+	Track       int     `bson:"track" json:"track"`
+	StartTime   float64 `bson:"start_time" json:"startTime"`                         // Position on the timeline, in seconds
+	EndTime     float64 `bson:"end_time" json:"endTime"`                             // Position on the timeline, in seconds
+	Duration    float64 `bson:"duration" json:"duration"`                            // Length of the clip, in seconds
+	SourceStart float64 `bson:"source_start,omitempty" json:"sourceStart,omitempty"` // Offset into the asset the clip starts at
+
+	// Placement of visual clips on the canvas, in pixels
+	X        float64   `bson:"x,omitempty" json:"x,omitempty"`
+	Y        float64   `bson:"y,omitempty" json:"y,omitempty"`
+	Width    float64   `bson:"width,omitempty" json:"width,omitempty"`
+	Height   float64   `bson:"height,omitempty" json:"height,omitempty"`
+	Position *Position `bson:"position,omitempty" json:"position,omitempty"` // Editor overlay position, in percent
+
+	// Text clips
+	Text       string  `bson:"text,omitempty" json:"text,omitempty"`
+	Content    string  `bson:"content,omitempty" json:"content,omitempty"` // Editor name for Text
+	Color      string  `bson:"color,omitempty" json:"color,omitempty"`
+	FontSize   float64 `bson:"font_size,omitempty" json:"fontSize,omitempty"`
+	FontFamily string  `bson:"font_family,omitempty" json:"fontFamily,omitempty"`
+	FontColor  string  `bson:"font_color,omitempty" json:"fontColor,omitempty"`
+	FontWeight string  `bson:"font_weight,omitempty" json:"fontWeight,omitempty"`
+	FontStyle  string  `bson:"font_style,omitempty" json:"fontStyle,omitempty"`
+	TextAlign  string  `bson:"text_align,omitempty" json:"textAlign,omitempty"`
+
+	IsMuted bool `bson:"is_muted,omitempty" json:"isMuted,omitempty"`
+}
+
+// Position is an overlay position as used by the editor
+type Position struct {
+	X float64 `bson:"x" json:"x"`
+	Y float64 `bson:"y" json:"y"`
+}
+
+// Transition blends one clip into the next clip on the same track
+type Transition struct {
+	ID         string  `bson:"id" json:"id"`
+	Type       string  `bson:"type" json:"type"` // An ffmpeg xfade transition, e.g. "fade", "wipeleft"
+	FromClipID string  `bson:"from_clip_id" json:"fromClipId"`
+	ToClipID   string  `bson:"to_clip_id" json:"toClipId"`
+	Duration   float64 `bson:"duration" json:"duration"` // In seconds
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Types of timeline operations
+const (
+	OpAddClip          = "add_clip"
+	OpDeleteClip       = "delete_clip"
+	OpMoveClip         = "move_clip"
+	OpTrimClip         = "trim_clip"
+	OpSplitClip        = "split_clip"
+	OpJoinClips        = "join_clips" // Undoes a split
+	OpSetProperty      = "set_property"
+	OpAddTransition    = "add_transition"
+	OpRemoveTransition = "remove_transition"
+)
+
+// TimelineOperation is a single change to a project timeline. Which fields
+// are used depends on Type; pointers tell a zero value from a missing one.
+type TimelineOperation struct {
+	Type   string `bson:"type" json:"type"` // One of the Op* constants
+	ClipID string `bson:"clip_id,omitempty" json:"clip_id,omitempty"`
+
+	// add_clip. Index places the clip in the item list, which sets its
+	// stacking order; Transitions are restored along with a deleted clip.
+	Clip        *TimelineItem `bson:"clip,omitempty" json:"clip,omitempty"`
+	Index       *int          `bson:"index,omitempty" json:"index,omitempty"`
+	Transitions []Transition  `bson:"transitions,omitempty" json:"transitions,omitempty"`
+
+	// move_clip and trim_clip, in seconds
+	Track       *int     `bson:"track,omitempty" json:"track,omitempty"`
+	StartTime   *float64 `bson:"start_time,omitempty" json:"start_time,omitempty"`
+	SourceStart *float64 `bson:"source_start,omitempty" json:"source_start,omitempty"`
+	Duration    *float64 `bson:"duration,omitempty" json:"duration,omitempty"`
+
+	// split_clip splits ClipID at timeline time At into itself and NewClipID;
+	// join_clips merges NewClipID back into ClipID
+	At        *float64 `bson:"at,omitempty" json:"at,omitempty"`
+	NewClipID string   `bson:"new_clip_id,omitempty" json:"new_clip_id,omitempty"`
+
+	// set_property, named as in the editor's JSON, e.g. "fontSize". The value
+	// is kept as JSON so it is stored and returned exactly as it was sent.
+	Property string          `bson:"property,omitempty" json:"property,omitempty"`
+	Value    json.RawMessage `bson:"value,omitempty" json:"value,omitempty"`
+
+	// add_transition and remove_transition
+	Transition   *Transition `bson:"transition,omitempty" json:"transition,omitempty"`
+	TransitionID string      `bson:"transition_id,omitempty" json:"transition_id,omitempty"`
+}
+
+// OperationLogEntry records an operation applied to a project, with the
+// operation that reverts it, so an editing session can undo and redo
+type OperationLogEntry struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	ProjectID primitive.ObjectID `bson:"project_id" json:"project_id"`
+	UserID    string             `bson:"user_id" json:"user_id"`
+	SessionID string             `bson:"session_id" json:"session_id"` // Editor session, e.g. one browser tab
+	Revision  int64              `bson:"revision" json:"revision"`     // Timeline revision the operation produced
+	Operation TimelineOperation  `bson:"operation" json:"operation"`
+	Inverse   TimelineOperation  `bson:"inverse" json:"inverse"`
+
+	// Undone entries can be redone until the session applies a new operation
+	Undone    bool      `bson:"undone" json:"undone"`
+	UndoneAt  int64     `bson:"undone_at,omitempty" json:"undone_at,omitempty"` // Revision the undo produced, orders redos
+	Discarded bool      `bson:"discarded,omitempty" json:"discarded,omitempty"` // Undone and no longer redoable
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UploadSession tracks a resumable (tus) upload until it is finalized into a MediaAsset
+type UploadSession struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    string             `bson:"user_id" json:"user_id"`
+	Filename  string             `bson:"filename" json:"filename"`                     // From the "filename" Upload-Metadata key
+	Length    int64              `bson:"length" json:"length"`                         // Total size declared by Upload-Length
+	Offset    int64              `bson:"offset" json:"offset"`                         // Bytes received so far
+	Metadata  map[string]string  `bson:"metadata" json:"metadata"`                     // Decoded Upload-Metadata
+	TempPath  string             `bson:"temp_path" json:"-"`                           // Partial file on disk
+	HashState []byte             `bson:"hash_state,omitempty" json:"-"`                // Marshaled SHA-256 of the bytes received so far
+	AssetID   string             `bson:"asset_id,omitempty" json:"asset_id,omitempty"` // Set once the upload completes
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
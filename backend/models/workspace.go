@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Workspace is a group of users who share templates
+type Workspace struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name      string             `bson:"name" json:"name"`
+	OwnerID   string             `bson:"owner_id" json:"owner_id"` // Only the owner manages members
+	Members   []string           `bson:"members" json:"members"`   // User IDs, including the owner
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
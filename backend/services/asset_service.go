@@ -0,0 +1,623 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"video-editor/db"
+	"video-editor/models"
+	"video-editor/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultAssetPageSize = 50
+	maxAssetPageSize     = 200
+
+	// signedURLExpiry is how long URLs handed out for stored files stay valid
+	signedURLExpiry = 24 * time.Hour
+)
+
+// ErrAssetInUse is returned when deleting an asset that projects or templates still reference
+var ErrAssetInUse = errors.New("asset is referenced by one or more projects or templates")
+
+// ErrDerivedPending is returned when a derived file is still being generated
+var ErrDerivedPending = errors.New("derived file is being generated")
+
+// ErrDerivedUnavailable is returned when a kind of derived file cannot be
+// generated for an asset, e.g. thumbnails of an audio file
+var ErrDerivedUnavailable = errors.New("derived file is not available for this asset")
+
+// AssetService manages uploaded media assets
+type AssetService struct {
+	assetsCollection    *mongo.Collection
+	blobsCollection     *mongo.Collection
+	projectsCollection  *mongo.Collection
+	versionsCollection  *mongo.Collection
+	templatesCollection *mongo.Collection
+	store               storage.Backend
+	policy              *UploadPolicy
+	quotas              *QuotaService
+}
+
+// AssetQuery filters and paginates an asset listing
+type AssetQuery struct {
+	Search string // Case-insensitive substring of the filename
+	Type   string // "video", "audio", "image"
+	Tag    string
+	Page   int // 1-based
+	Limit  int
+}
+
+// NewAssetService creates a new AssetService
+func NewAssetService(client *mongo.Client, dbName string, store storage.Backend, policy *UploadPolicy, quotas *QuotaService) *AssetService {
+	return &AssetService{
+		assetsCollection:    client.Database(dbName).Collection("media_assets"),
+		blobsCollection:     client.Database(dbName).Collection("media_blobs"),
+		projectsCollection:  client.Database(dbName).Collection("projects"),
+		versionsCollection:  client.Database(dbName).Collection("project_versions"),
+		templatesCollection: client.Database(dbName).Collection("project_templates"),
+		store:               store,
+		policy:              policy,
+		quotas:              quotas,
+	}
+}
+
+// Policy returns the limits uploads are validated against
+func (s *AssetService) Policy() *UploadPolicy {
+	return s.policy
+}
+
+// IngestFile validates an upload written to tempPath against the upload
+// policy, stores it in the user's content-addressed store and records it as a
+// media asset. digest is the file's hex SHA-256, computed here if empty.
+// Rejected files are removed and reported as an *UploadError.
+func (s *AssetService) IngestFile(userID, filename, tempPath string, size int64, digest string) (*models.MediaAsset, error) {
+	meta, err := s.validateFile(tempPath, size)
+	if err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+	if digest == "" {
+		if digest, err = HashFile(tempPath); err != nil {
+			os.Remove(tempPath)
+			return nil, err
+		}
+	}
+
+	// Duplicates of content the user already stores take no extra space
+	if err := s.checkQuota(userID, digest, size); err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+
+	filename = SanitizeFilename(filename)
+	blob, err := s.storeBlob(userID, tempPath, digest, filename, size)
+	if err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+
+	asset := &models.MediaAsset{
+		UserID:     userID,
+		Filename:   filename,
+		StorageKey: blob.StorageKey,
+		BlobID:     blob.ID,
+		SHA256:     digest,
+		Size:       size,
+		Type:       MediaType(filename, meta),
+		Metadata:   meta,
+	}
+	if err := s.CreateAsset(asset); err != nil {
+		s.releaseBlob(blob.ID)
+		return nil, err
+	}
+	for _, action := range []string{ActionGenerateProxy, ActionGenerateThumbnails, ActionGenerateWaveform} {
+		if canGenerate(action, asset) {
+			QueueMediaJob(action, asset)
+		}
+	}
+	return asset, s.resolveURLs(asset)
+}
+
+// CheckQuota rejects an upload of the given size that would exceed the user's quota
+func (s *AssetService) CheckQuota(userID string, size int64) error {
+	err := s.quotas.CheckAvailable(userID, size)
+	var quotaErr *QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		return &UploadError{
+			Code:    "quota_exceeded",
+			Message: quotaErr.Error(),
+			Status:  http.StatusRequestEntityTooLarge,
+		}
+	}
+	return err
+}
+
+// checkQuota is CheckQuota for content that may already be stored
+func (s *AssetService) checkQuota(userID, digest string, size int64) error {
+	count, err := s.blobsCollection.CountDocuments(db.Ctx, bson.M{"user_id": userID, "sha256": digest})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return s.CheckQuota(userID, size)
+}
+
+// validateFile runs the size, content sniffing and ffprobe checks on a stored file
+func (s *AssetService) validateFile(storagePath string, size int64) (*models.MediaMetadata, error) {
+	if err := s.policy.CheckSize(size); err != nil {
+		return nil, err
+	}
+
+	contentType, err := SniffFile(storagePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.policy.CheckContentType(contentType); err != nil {
+		return nil, err
+	}
+
+	meta, err := ProbeMedia(storagePath)
+	if err != nil {
+		log.Printf("Rejecting %s: %v", storagePath, err)
+		return nil, &UploadError{
+			Code:    "unreadable_media",
+			Message: "file could not be read as audio, video or image",
+			Status:  http.StatusUnprocessableEntity,
+		}
+	}
+	if err := s.policy.CheckMetadata(meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// CreateAsset inserts a new media asset
+func (s *AssetService) CreateAsset(asset *models.MediaAsset) error {
+	asset.ID = primitive.NewObjectID()
+	if asset.Tags == nil {
+		asset.Tags = []string{}
+	}
+	asset.CreatedAt = time.Now()
+	asset.UpdatedAt = time.Now()
+
+	_, err := s.assetsCollection.InsertOne(db.Ctx, asset)
+	return err
+}
+
+// GetAsset retrieves an asset by ID and UserID (for ownership check)
+func (s *AssetService) GetAsset(assetID string, userID string) (*models.MediaAsset, error) {
+	objID, err := primitive.ObjectIDFromHex(assetID)
+	if err != nil {
+		return nil, errors.New("invalid asset ID format")
+	}
+
+	asset := &models.MediaAsset{}
+	filter := bson.M{"_id": objID, "user_id": userID}
+	err = s.assetsCollection.FindOne(db.Ctx, filter).Decode(asset)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("asset not found or unauthorized")
+		}
+		return nil, err
+	}
+	return asset, s.resolveURLs(asset)
+}
+
+// GetAssetByKey retrieves the user's asset stored under a storage key
+func (s *AssetService) GetAssetByKey(storageKey, userID string) (*models.MediaAsset, error) {
+	asset := &models.MediaAsset{}
+	filter := bson.M{"storage_key": storageKey, "user_id": userID}
+	err := s.assetsCollection.FindOne(db.Ctx, filter).Decode(asset)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("asset not found or unauthorized")
+		}
+		return nil, err
+	}
+	return asset, s.resolveURLs(asset)
+}
+
+// ClipAsset returns the user's asset a timeline clip plays, by ID or, for
+// clips saved before asset IDs, by its storage URL
+func (s *AssetService) ClipAsset(clip *models.TimelineItem, userID string) (*models.MediaAsset, error) {
+	if clip.AssetID != "" {
+		return s.GetAsset(clip.AssetID, userID)
+	}
+	key, ok := s.store.KeyFromURL(clip.URL)
+	if !ok {
+		return nil, fmt.Errorf("media URL %q is not in storage", clip.URL)
+	}
+	return s.GetAssetByKey(key, userID)
+}
+
+// resolveURLs fills in signed URLs for an asset and its derived files
+func (s *AssetService) resolveURLs(asset *models.MediaAsset) error {
+	var err error
+	if asset.URL, err = s.store.SignedURL(asset.StorageKey, signedURLExpiry); err != nil {
+		return err
+	}
+	for i := range asset.Derived {
+		d := &asset.Derived[i]
+		if d.URL, err = s.store.SignedURL(d.StorageKey, signedURLExpiry); err != nil {
+			return err
+		}
+		switch d.Kind {
+		case models.DerivedProxy:
+			asset.ProxyURL = d.URL
+		case models.DerivedPoster:
+			asset.PosterURL = d.URL
+		}
+	}
+	return nil
+}
+
+// EnsureDerived returns the asset's derived file of a kind. If it is missing
+// from the asset or from storage, the job that generates it is queued and
+// ErrDerivedPending is returned.
+func (s *AssetService) EnsureDerived(asset *models.MediaAsset, kind string) (*models.DerivedFile, error) {
+	action, ok := derivedActions[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown derived file kind %q", kind)
+	}
+	if !canDerive(kind, asset) {
+		return nil, ErrDerivedUnavailable
+	}
+	for i := range asset.Derived {
+		d := &asset.Derived[i]
+		if d.Kind != kind {
+			continue
+		}
+		if _, err := s.store.Stat(d.StorageKey); err == nil {
+			return d, nil
+		} else if err != storage.ErrNotFound {
+			return nil, err
+		}
+		break
+	}
+	QueueMediaJob(action, asset)
+	return nil, ErrDerivedPending
+}
+
+// ThumbnailsVTT returns the asset's WebVTT thumbnails index with its sprite
+// references replaced by a signed URL
+func (s *AssetService) ThumbnailsVTT(asset *models.MediaAsset) (string, error) {
+	index, err := s.EnsureDerived(asset, models.DerivedThumbnailsVTT)
+	if err != nil {
+		return "", err
+	}
+	sprite, err := s.EnsureDerived(asset, models.DerivedSprite)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := s.store.Get(index.StorageKey)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(string(content), spriteName+"#", sprite.URL+"#"), nil
+}
+
+// Waveform returns the asset's audio peaks at a zoom level, which must be one
+// of WaveformZoomLevels
+func (s *AssetService) Waveform(asset *models.MediaAsset, samplesPerPixel int) (*Waveform, error) {
+	d, err := s.EnsureDerived(asset, WaveformKind(samplesPerPixel))
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.store.Get(d.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ReadDat(bufio.NewReader(r))
+}
+
+// SetDerived records a file generated from an asset, replacing any earlier
+// file of the same kind
+func (s *AssetService) SetDerived(assetID primitive.ObjectID, derived models.DerivedFile) error {
+	filter := bson.M{"_id": assetID}
+	pull := bson.M{"$pull": bson.M{"derived": bson.M{"kind": derived.Kind}}}
+	if _, err := s.assetsCollection.UpdateOne(db.Ctx, filter, pull); err != nil {
+		return err
+	}
+	push := bson.M{
+		"$push": bson.M{"derived": derived},
+		"$set":  bson.M{"updated_at": time.Now()},
+	}
+	result, err := s.assetsCollection.UpdateOne(db.Ctx, filter, push)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("asset not found")
+	}
+	return nil
+}
+
+// Scenes returns the asset's scene boundaries scoring above threshold. If the
+// asset has not been analyzed down to that threshold, detection is queued and
+// ErrDerivedPending is returned.
+func (s *AssetService) Scenes(asset *models.MediaAsset, threshold float64) ([]models.SceneBoundary, error) {
+	if !canGenerate(ActionDetectScenes, asset) {
+		return nil, ErrDerivedUnavailable
+	}
+	if asset.Scenes == nil || asset.Scenes.Threshold > threshold {
+		QueueSceneDetection(asset, threshold)
+		return nil, ErrDerivedPending
+	}
+	return FilterSceneBoundaries(asset.Scenes, threshold), nil
+}
+
+// SetScenes records the result of scene detection on an asset
+func (s *AssetService) SetScenes(assetID primitive.ObjectID, analysis *models.SceneAnalysis) error {
+	update := bson.M{"$set": bson.M{"scenes": analysis, "updated_at": time.Now()}}
+	result, err := s.assetsCollection.UpdateOne(db.Ctx, bson.M{"_id": assetID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("asset not found")
+	}
+	return nil
+}
+
+// Silences returns the asset's silent intervals at a noise level lasting at
+// least minDuration. If the asset has not been analyzed at that level,
+// detection is queued and ErrDerivedPending is returned.
+func (s *AssetService) Silences(asset *models.MediaAsset, noiseDB, minDuration float64) ([]models.SilenceInterval, error) {
+	if !canGenerate(ActionDetectSilences, asset) {
+		return nil, ErrDerivedUnavailable
+	}
+	analysis := asset.Silences
+	if analysis == nil || analysis.NoiseDB != noiseDB || analysis.MinDuration > minDuration {
+		QueueSilenceDetection(asset, noiseDB)
+		return nil, ErrDerivedPending
+	}
+	return FilterSilences(analysis, minDuration), nil
+}
+
+// SetSilences records the result of silence detection on an asset
+func (s *AssetService) SetSilences(assetID primitive.ObjectID, analysis *models.SilenceAnalysis) error {
+	update := bson.M{"$set": bson.M{"silences": analysis, "updated_at": time.Now()}}
+	result, err := s.assetsCollection.UpdateOne(db.Ctx, bson.M{"_id": assetID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("asset not found")
+	}
+	return nil
+}
+
+// ListAssets returns a page of the user's assets matching the query, newest first,
+// along with the total number of matches. Page and Limit are normalized in place.
+func (s *AssetService) ListAssets(userID string, query *AssetQuery) ([]models.MediaAsset, int64, error) {
+	filter := bson.M{"user_id": userID}
+	if query.Search != "" {
+		filter["filename"] = bson.M{"$regex": regexp.QuoteMeta(query.Search), "$options": "i"}
+	}
+	if query.Type != "" {
+		filter["type"] = query.Type
+	}
+	if query.Tag != "" {
+		filter["tags"] = query.Tag
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = defaultAssetPageSize
+	}
+	if query.Limit > maxAssetPageSize {
+		query.Limit = maxAssetPageSize
+	}
+	if query.Page < 1 {
+		query.Page = 1
+	}
+
+	total, err := s.assetsCollection.CountDocuments(db.Ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((query.Page - 1) * query.Limit)).
+		SetLimit(int64(query.Limit))
+	cursor, err := s.assetsCollection.Find(db.Ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	assets := []models.MediaAsset{}
+	if err := cursor.All(db.Ctx, &assets); err != nil {
+		return nil, 0, err
+	}
+	for i := range assets {
+		if err := s.resolveURLs(&assets[i]); err != nil {
+			return nil, 0, err
+		}
+	}
+	return assets, total, nil
+}
+
+// ListAssetNames returns all of the user's assets, newest first, with only
+// their ID, filename, type and metadata read, for matching files by name
+func (s *AssetService) ListAssetNames(userID string) ([]models.MediaAsset, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetProjection(bson.M{"filename": 1, "type": 1, "metadata": 1})
+	cursor, err := s.assetsCollection.Find(db.Ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	assets := []models.MediaAsset{}
+	if err := cursor.All(db.Ctx, &assets); err != nil {
+		return nil, err
+	}
+	return assets, nil
+}
+
+// UpdateAsset renames an asset and/or replaces its tags. Nil arguments are left unchanged.
+func (s *AssetService) UpdateAsset(assetID, userID string, filename *string, tags []string) (*models.MediaAsset, error) {
+	asset, err := s.GetAsset(assetID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	set := bson.M{"updated_at": time.Now()}
+	if filename != nil {
+		name := strings.TrimSpace(*filename)
+		if name == "" {
+			return nil, errors.New("filename must not be empty")
+		}
+		set["filename"] = name
+		asset.Filename = name
+	}
+	if tags != nil {
+		tags = normalizeTags(tags)
+		set["tags"] = tags
+		asset.Tags = tags
+	}
+
+	_, err = s.assetsCollection.UpdateByID(db.Ctx, asset.ID, bson.M{"$set": set})
+	if err != nil {
+		return nil, err
+	}
+	return asset, nil
+}
+
+// AssetReferences are the projects and templates whose timelines use an asset
+type AssetReferences struct {
+	Projects  []string `json:"projects"`
+	Templates []string `json:"templates"`
+}
+
+// InUse reports whether anything references the asset
+func (r *AssetReferences) InUse() bool {
+	return len(r.Projects) > 0 || len(r.Templates) > 0
+}
+
+// References returns the IDs of the projects and templates that use the
+// asset. Projects are those its owner owns or collaborates on, the only ones
+// its clips can be added to, along with any whose saved versions still use
+// it, since restoring one would bring it back. Timeline clips name the asset
+// by ID. Older clips and edits store URLs, which may carry a signature, so
+// they are matched on the storage key at the end of the URL path.
+func (s *AssetService) References(asset *models.MediaAsset) (*AssetReferences, error) {
+	keyPattern := "/" + regexp.QuoteMeta(asset.StorageKey) + `(\?|$)`
+	usesAsset := bson.M{"$or": bson.A{
+		bson.M{"timeline.media_items.asset_id": asset.ID.Hex()},
+		bson.M{"timeline.media_items.url": bson.M{"$regex": keyPattern}},
+	}}
+	filter := bson.M{
+		"$and": bson.A{
+			bson.M{"$or": bson.A{bson.M{"user_id": asset.UserID}, bson.M{"collaborators": asset.UserID}}},
+			bson.M{"$or": bson.A{
+				usesAsset,
+				bson.M{"video_url": bson.M{"$regex": keyPattern}},
+				bson.M{"edits.params.url": bson.M{"$regex": keyPattern}},
+			}},
+		},
+	}
+	projectIDs, err := s.projectsCollection.Distinct(db.Ctx, "_id", filter)
+	if err != nil {
+		return nil, err
+	}
+	versionProjectIDs, err := s.versionsCollection.Distinct(db.Ctx, "project_id", usesAsset)
+	if err != nil {
+		return nil, err
+	}
+	templateIDs, err := s.templatesCollection.Distinct(db.Ctx, "_id", usesAsset)
+	if err != nil {
+		return nil, err
+	}
+	return &AssetReferences{
+		Projects:  objectIDHexes(append(projectIDs, versionProjectIDs...)),
+		Templates: objectIDHexes(templateIDs),
+	}, nil
+}
+
+// objectIDHexes returns the hex form of the ObjectIDs among values, without repeats
+func objectIDHexes(values []interface{}) []string {
+	ids := []string{}
+	seen := make(map[primitive.ObjectID]bool, len(values))
+	for _, v := range values {
+		if id, ok := v.(primitive.ObjectID); ok && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id.Hex())
+		}
+	}
+	return ids
+}
+
+// DeleteAsset removes an asset and any derived files, and releases its blob so
+// the file is deleted once no other asset shares it. Unless force is set, it
+// refuses with ErrAssetInUse when projects or templates still reference the
+// asset. The references are returned in either case.
+func (s *AssetService) DeleteAsset(assetID, userID string, force bool) (*AssetReferences, error) {
+	asset, err := s.GetAsset(assetID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := s.References(asset)
+	if err != nil {
+		return nil, err
+	}
+	if refs.InUse() && !force {
+		return refs, ErrAssetInUse
+	}
+
+	if _, err := s.assetsCollection.DeleteOne(db.Ctx, bson.M{"_id": asset.ID}); err != nil {
+		return nil, err
+	}
+
+	// The original file is shared with other assets of the same content
+	if err := s.releaseBlob(asset.BlobID); err != nil {
+		log.Printf("Warning: failed to release blob for asset %s: %v", assetID, err)
+	}
+
+	for _, d := range asset.Derived {
+		if d.StorageKey == "" {
+			continue
+		}
+		if err := s.store.Delete(d.StorageKey); err != nil {
+			log.Printf("Warning: failed to remove %s for asset %s: %v", d.StorageKey, assetID, err)
+		}
+	}
+	return refs, nil
+}
+
+// normalizeTags trims, lowercases and de-duplicates tags
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		result = append(result, t)
+	}
+	return result
+}
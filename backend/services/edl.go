@@ -0,0 +1,214 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxEDLEvents is the most events CMX3600 numbers
+const maxEDLEvents = 999
+
+// edlAudioChannels are the CMX3600 channels audio tracks are written to
+var edlAudioChannels = []string{"A", "A2"}
+
+// reelChars are the characters kept in a reel name
+var reelChars = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+// edlEvent is one numbered edit, written as one line or, for a transition,
+// two
+type edlEvent struct {
+	recordIn int64
+	order    int // Video before audio at the same record time
+	lines    []string
+	comments []string
+}
+
+// WriteEDL writes the project as a CMX3600 edit decision list. CMX3600 has a
+// single video track, so the lowest video track with media is written and
+// clips on the others are listed in comments at the end; text clips can't
+// be written at all. The first two audio tracks become channels A and A2.
+// Records start at 01:00:00:00. Reel names are cut down from filenames, so
+// each event is followed by a FROM CLIP NAME comment with the full name.
+func WriteEDL(w io.Writer, p *InterchangeProject) error {
+	recordStart, _ := p.Rate.ParseTimecode("01:00:00:00")
+	var events []edlEvent
+	var omitted []string
+	videoWritten := false
+	for _, track := range p.tracks("video") {
+		if videoWritten || !hasMedia(track) {
+			for _, clip := range track.clips {
+				omitted = append(omitted, fmt.Sprintf("* OMITTED %s CLIP %s ON TRACK %d", strings.ToUpper(clip.item.Type), clipName(clip), clip.item.Track+1))
+			}
+			continue
+		}
+		videoWritten = true
+		events = append(events, edlVideoEvents(p, track, recordStart, &omitted)...)
+	}
+	for i, track := range p.tracks("audio") {
+		if i >= len(edlAudioChannels) {
+			for _, clip := range track.clips {
+				omitted = append(omitted, fmt.Sprintf("* OMITTED AUDIO CLIP %s ON TRACK %d", clipName(clip), clip.item.Track+1))
+			}
+			continue
+		}
+		for _, clip := range track.clips {
+			events = append(events, edlEvent{
+				recordIn: clip.start,
+				order:    i + 1,
+				lines:    []string{edlLine(p, clip, edlAudioChannels[i], "C", 0, clip.in, clip.out(), clip.start+recordStart, clip.end()+recordStart)},
+				comments: []string{"* FROM CLIP NAME: " + clipName(clip)},
+			})
+		}
+	}
+	if len(events) > maxEDLEvents {
+		return fmt.Errorf("CMX3600 holds at most %d events, this timeline needs %d", maxEDLEvents, len(events))
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].recordIn != events[j].recordIn {
+			return events[i].recordIn < events[j].recordIn
+		}
+		return events[i].order < events[j].order
+	})
+
+	fcm := "NON-DROP FRAME"
+	if p.Rate.DropFrame {
+		fcm = "DROP FRAME"
+	}
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "TITLE: %s\nFCM: %s\n\n", edlTitle(p.Name), fcm)
+	for i, event := range events {
+		for _, line := range event.lines {
+			fmt.Fprintf(out, "%03d  %s\n", i+1, line)
+		}
+		for _, comment := range event.comments {
+			fmt.Fprintln(out, comment)
+		}
+		fmt.Fprintln(out)
+	}
+	for _, line := range omitted {
+		fmt.Fprintln(out, line)
+	}
+	return out.Flush()
+}
+
+// edlVideoEvents writes the video track's clips. A transition becomes a
+// dissolve or wipe event starting where it starts, before the cut: the
+// outgoing clip's event ends there, and the incoming clip's starts there
+// from a point before its in point.
+func edlVideoEvents(p *InterchangeProject, track interchangeTrack, recordStart int64, omitted *[]string) []edlEvent {
+	incoming := p.transitions(track)
+	outgoing := make(map[string]interchangeTransition, len(incoming))
+	previous := make(map[string]interchangeClip, len(incoming))
+	for i := 1; i < len(track.clips); i++ {
+		t, ok := incoming[track.clips[i].item.ID]
+		if !ok {
+			continue
+		}
+		if track.clips[i-1].item.Type == "text" || track.clips[i].item.Type == "text" {
+			delete(incoming, track.clips[i].item.ID)
+			continue
+		}
+		outgoing[track.clips[i-1].item.ID] = t
+		previous[track.clips[i].item.ID] = track.clips[i-1]
+	}
+
+	var events []edlEvent
+	for _, clip := range track.clips {
+		if clip.item.Type == "text" {
+			*omitted = append(*omitted, fmt.Sprintf("* OMITTED TEXT CLIP %s ON TRACK %d", clipName(clip), clip.item.Track+1))
+			continue
+		}
+		cut := outgoing[clip.item.ID].before
+		t, ok := incoming[clip.item.ID]
+		if !ok {
+			events = append(events, edlEvent{
+				recordIn: clip.start,
+				lines:    []string{edlLine(p, clip, "V", "C", 0, clip.in, clip.out()-cut, clip.start+recordStart, clip.end()-cut+recordStart)},
+				comments: []string{"* FROM CLIP NAME: " + clipName(clip)},
+			})
+			continue
+		}
+		from := previous[clip.item.ID]
+		startsAt := clip.start - t.before + recordStart
+		events = append(events, edlEvent{
+			recordIn: clip.start - t.before,
+			lines: []string{
+				edlLine(p, from, "V", "C", 0, from.out()-t.before, from.out()-t.before, startsAt, startsAt),
+				edlLine(p, clip, "V", edlTransitionCode(t.transition.Type), t.duration(), clip.in-t.before, clip.out()-cut, startsAt, clip.end()-cut+recordStart),
+			},
+			comments: []string{"* FROM CLIP NAME: " + clipName(from), "* TO CLIP NAME: " + clipName(clip)},
+		})
+	}
+	return events
+}
+
+// edlLine formats the part of an event line after its number
+func edlLine(p *InterchangeProject, clip interchangeClip, channel, transition string, duration, sourceIn, sourceOut, recordIn, recordOut int64) string {
+	length := "   "
+	if transition != "C" {
+		length = fmt.Sprintf("%03d", duration)
+	}
+	return fmt.Sprintf("%-8s %-5s %-4s %s %s %s %s %s", reelName(clip.media.Name), channel, transition, length,
+		p.Rate.Timecode(sourceIn), p.Rate.Timecode(sourceOut), p.Rate.Timecode(recordIn), p.Rate.Timecode(recordOut))
+}
+
+// edlTransitionCode maps a transition type to a CMX3600 dissolve or wipe
+func edlTransitionCode(transitionType string) string {
+	switch transitionType {
+	case "fade", "dissolve", "fadeblack", "fadewhite":
+		return "D"
+	}
+	return "W001"
+}
+
+// reelName cuts a filename down to a CMX3600 reel name of at most eight
+// letters, digits and underscores. BL is reserved for black.
+func reelName(filename string) string {
+	name := strings.TrimSuffix(filename, path.Ext(filename))
+	name = reelChars.ReplaceAllString(strings.ToUpper(name), "")
+	if len(name) > 8 {
+		name = name[:8]
+	}
+	if name == "" || name == "BL" {
+		return "AX"
+	}
+	return name
+}
+
+// edlTitle makes a project name fit the TITLE line
+func edlTitle(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	if len(name) > 70 {
+		name = strings.ToValidUTF8(name[:70], "")
+	}
+	if name == "" {
+		return "UNTITLED"
+	}
+	return name
+}
+
+// clipName is the name an exported clip goes by: its file, else its own name
+func clipName(clip interchangeClip) string {
+	switch {
+	case clip.media.Name != "":
+		return clip.media.Name
+	case clip.item.Name != "":
+		return clip.item.Name
+	}
+	return clip.item.ID
+}
+
+// hasMedia reports whether a track holds any clip that plays a file
+func hasMedia(track interchangeTrack) bool {
+	for _, clip := range track.clips {
+		if clip.item.Type != "text" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,346 @@
+package services
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// edlEventNumber starts an event line; editors pad it to 3 to 6 digits
+	edlEventNumber = regexp.MustCompile(`^\d{1,6}$`)
+	// edlEditCode is a cut, dissolve, wipe or key
+	edlEditCode = regexp.MustCompile(`^(C|D|W\d{3}|K[BO]?)$`)
+	// edlAudioChannel is an audio channel such as A, A2 or, from Avid, A12
+	edlAudioChannel = regexp.MustCompile(`^A([1-9]\d*)?$`)
+)
+
+// edlBlackReels are the reel names used for black and other generated video
+var edlBlackReels = map[string]bool{"BL": true, "BLK": true, "BLACK": true}
+
+// edlRow is one line of an event, times in frames at its rate
+type edlRow struct {
+	number   string
+	reel     string
+	video    bool
+	audio    int // Lowest audio channel, 0 for none
+	code     string
+	duration int64 // Of a transition
+	rate     FrameRate
+	srcIn    int64
+	srcOut   int64
+	recIn    int64
+	recOut   int64
+}
+
+// edlGroup is the lines sharing an event number, a transition's two lines
+// or the video and audio of one edit, with the comments that follow them
+type edlGroup struct {
+	rows     []edlRow
+	fromName string
+	toName   string
+	fromFile string
+	toFile   string
+}
+
+// parseEDL reads a CMX3600 edit decision list with timecodes at rate.
+// Common dialects are accepted: any line ending, padded event numbers,
+// reel names with spaces, comments with or without a leading "*", and
+// drop-frame timecode marked by the FCM line or a ";" before the frames.
+// Records are placed from the hour the earliest one starts in; source
+// timecodes are taken from the media's start timecode once matched. Keys,
+// speed changes and audio transitions are imported as plain cuts.
+func parseEDL(data []byte, rate FrameRate) (*importedTimeline, error) {
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	text = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(text)
+	t := &importedTimeline{}
+	current := rate
+	var groups []*edlGroup
+	for i, raw := range strings.Split(text, "\n") {
+		line := strings.TrimSpace(raw)
+		fields := strings.Fields(line)
+		upper := strings.ToUpper(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(upper, "TITLE:"):
+			t.name = strings.TrimSpace(line[len("TITLE:"):])
+		case strings.HasPrefix(upper, "FCM:"):
+			current = edlRate(rate, !strings.Contains(upper, "NON") && strings.Contains(upper, "DROP"))
+		case fields[0] == "M2":
+			t.warn("line %d: speed changes are not imported", i+1)
+		case edlEventNumber.MatchString(fields[0]):
+			row, err := parseEDLRow(fields, current, i+1)
+			if err != nil {
+				return nil, err
+			}
+			if n := len(groups); n > 0 && groups[n-1].rows[0].number == row.number {
+				groups[n-1].rows = append(groups[n-1].rows, row)
+			} else {
+				groups = append(groups, &edlGroup{rows: []edlRow{row}})
+			}
+		case len(groups) > 0:
+			edlComment(groups[len(groups)-1], line)
+		}
+	}
+	if len(groups) == 0 {
+		return nil, invalidInterchange("no events")
+	}
+
+	hour := int64(math.MaxInt64)
+	for _, g := range groups {
+		for _, row := range g.rows {
+			hour = min(hour, row.recIn/edlHour(row.rate))
+		}
+	}
+	ends := make(map[int]int) // Index of the clip last placed on each track
+	for _, g := range groups {
+		for _, row := range g.rows {
+			if err := t.addEDLRow(g, row, hour, ends); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t, nil
+}
+
+// addEDLRow adds the clip a line plays. A dissolve or wipe starts where the
+// clip before it on the track ends; that clip is lengthened and this one
+// shortened by half the transition, so they meet at its middle.
+func (t *importedTimeline) addEDLRow(g *edlGroup, row edlRow, hour int64, ends map[int]int) error {
+	if row.audio > 0 {
+		t.hasAudio = true
+	}
+	transition := row.code != "C"
+	if edlBlackReels[strings.ToUpper(row.reel)] {
+		if transition {
+			t.warn("event %s: transition to black imported as a cut", row.number)
+		}
+		return nil
+	}
+	if row.recOut == row.recIn || (!row.video && row.audio == 0) {
+		return nil
+	}
+
+	track, clipType := row.audio, "audio"
+	if row.video {
+		track, clipType = 0, "video"
+	}
+	var names []importedName
+	var name string
+	add := func(values ...string) {
+		for _, value := range values {
+			if value != "" {
+				names = append(names, importedName{value: value})
+				if name == "" {
+					name = mediaBaseName(value)
+				}
+			}
+		}
+	}
+	switch {
+	case len(g.rows) == 1:
+		add(g.fromFile, g.fromName, g.toFile, g.toName)
+	case transition:
+		add(g.toFile, g.toName)
+	default:
+		add(g.fromFile, g.fromName)
+	}
+	if reel := strings.ToUpper(row.reel); reel != "AX" {
+		names = append(names, importedName{value: row.reel, reel: true})
+	}
+	if name == "" {
+		name = row.reel
+	}
+
+	origin := hour * edlHour(row.rate)
+	start := row.rate.Seconds(row.recIn - origin)
+	clip := importedClip{
+		item:       importedItem(clipType, name, track, start, row.rate.Seconds(row.recOut-origin), row.rate.Seconds(row.srcIn)),
+		ref:        "event " + row.number,
+		names:      names,
+		withAudio:  row.video && row.audio > 0,
+		sourceRate: row.rate,
+	}
+	idx, err := t.addClip(clip)
+	if err != nil {
+		return err
+	}
+	from, joined := ends[track]
+	ends[track] = idx
+	if !transition {
+		return nil
+	}
+
+	before := row.duration / 2
+	switch {
+	case strings.HasPrefix(row.code, "K"):
+		t.warn("event %s: key imported as a cut", row.number)
+		return nil
+	case !row.video:
+		t.warn("event %s: audio transition imported as a cut", row.number)
+		return nil
+	case len(g.rows) > 1 && edlBlackReels[strings.ToUpper(g.rows[0].reel)]:
+		t.warn("event %s: transition from black imported as a cut", row.number)
+		return nil
+	case row.duration == 0:
+		t.warn("event %s: transition without a length imported as a cut", row.number)
+		return nil
+	case !joined || math.Abs(t.clips[from].item.EndTime-clip.item.StartTime) > 0.002:
+		t.warn("event %s: transition with no clip before it imported as a cut", row.number)
+		return nil
+	case before >= row.recOut-row.recIn:
+		t.warn("event %s: transition longer than its clip imported as a cut", row.number)
+		return nil
+	}
+	cut := row.rate.Seconds(row.recIn + before - origin)
+	out := &t.clips[from].item
+	out.EndTime = cut
+	out.Duration = roundMillis(out.EndTime - out.StartTime)
+	in := &t.clips[idx].item
+	in.StartTime = cut
+	in.SourceStart = row.rate.Seconds(row.srcIn + before)
+	in.Duration = roundMillis(in.EndTime - in.StartTime)
+	transitionType := "fade"
+	if strings.HasPrefix(row.code, "W") {
+		transitionType = "wipeleft"
+	}
+	t.addTransition(transitionType, from, idx, row.rate.Seconds(row.duration))
+	return nil
+}
+
+// parseEDLRow reads an event line: number, reel, channels, edit type, the
+// transition's length if any, and source and record in and out points. The
+// reel is whatever is left between the number and the channels, so it may
+// hold spaces.
+func parseEDLRow(fields []string, rate FrameRate, line int) (edlRow, error) {
+	row := edlRow{number: fields[0]}
+	if len(fields) < 7 {
+		return row, invalidInterchange("line %d: event has too few fields", line)
+	}
+	mid := fields[1 : len(fields)-4]
+	if n := len(mid); n >= 4 && isDigits(mid[n-1]) && edlEditCode.MatchString(strings.ToUpper(mid[n-2])) {
+		row.duration, _ = strconv.ParseInt(mid[n-1], 10, 64)
+		mid = mid[:n-1]
+	}
+	n := len(mid)
+	if n < 3 {
+		return row, invalidInterchange("line %d: event has too few fields", line)
+	}
+	row.code = strings.ToUpper(mid[n-1])
+	if !edlEditCode.MatchString(row.code) {
+		return row, invalidInterchange("line %d: unknown edit type %q", line, mid[n-1])
+	}
+	var ok bool
+	row.video, row.audio, ok = edlChannels(strings.ToUpper(mid[n-2]))
+	if !ok {
+		return row, invalidInterchange("line %d: unknown channel %q", line, mid[n-2])
+	}
+	row.reel = strings.Join(mid[:n-2], " ")
+
+	timecodes := fields[len(fields)-4:]
+	dropFrame := rate.DropFrame
+	for _, tc := range timecodes {
+		dropFrame = dropFrame || strings.Contains(tc, ";")
+	}
+	row.rate = edlRate(rate, dropFrame)
+	var frames [4]int64
+	for i, tc := range timecodes {
+		f, err := row.rate.ParseTimecode(tc)
+		if err != nil {
+			return row, invalidInterchange("line %d: %v", line, err)
+		}
+		frames[i] = f
+	}
+	row.srcIn, row.srcOut, row.recIn, row.recOut = frames[0], frames[1], frames[2], frames[3]
+	if row.srcOut < row.srcIn || row.recOut < row.recIn {
+		return row, invalidInterchange("line %d: out point before in point", line)
+	}
+	return row, nil
+}
+
+// edlChannels reads the channels of an event: V, A, A2 and so on, AA for a
+// stereo pair, B for picture and sound, or combinations such as A/V
+func edlChannels(channel string) (video bool, audio int, ok bool) {
+	lowest := func(n int) {
+		if audio == 0 || n < audio {
+			audio = n
+		}
+	}
+	for _, part := range strings.Split(channel, "/") {
+		switch m := edlAudioChannel.FindStringSubmatch(part); {
+		case part == "V":
+			video = true
+		case part == "B":
+			video = true
+			lowest(1)
+		case part == "AA":
+			lowest(1)
+		case part == "NONE":
+		case m != nil:
+			n := 1
+			if m[1] != "" {
+				n = int(m[1][0] - '0') // A12 is channels 1 and 2
+			}
+			lowest(n)
+		default:
+			return false, 0, false
+		}
+	}
+	return video, audio, true
+}
+
+// edlComment keeps the clip and file names from a comment after an event.
+// Some editors leave out the "*".
+func edlComment(g *edlGroup, line string) {
+	comment := strings.TrimSpace(strings.TrimLeft(line, "*"))
+	key, value, ok := strings.Cut(comment, ":")
+	if !ok {
+		return
+	}
+	value = strings.TrimSpace(value)
+	switch strings.ToUpper(strings.Join(strings.Fields(key), " ")) {
+	case "FROM CLIP NAME", "CLIP NAME":
+		g.fromName = value
+	case "TO CLIP NAME":
+		g.toName = value
+	case "SOURCE FILE", "FROM FILE":
+		if g.fromFile != "" && len(g.rows) > 1 {
+			g.toFile = value
+		} else {
+			g.fromFile = value
+		}
+	case "TO FILE":
+		g.toFile = value
+	}
+}
+
+// edlRate is the rate an EDL's timecodes count at, dropping frames or not.
+// Only NTSC rates drop frames, so a drop-frame EDL read at 30 or 60 is read
+// at 29.97 or 59.94, and one at 24 or 25 is read as it is.
+func edlRate(rate FrameRate, dropFrame bool) FrameRate {
+	if !dropFrame {
+		rate.DropFrame = false
+		return rate
+	}
+	nominal := rate.Nominal()
+	if nominal%30 != 0 {
+		return rate
+	}
+	return FrameRate{Num: nominal * 1000, Den: 1001, DropFrame: true}
+}
+
+// edlHour is the number of frames in an hour of timecode
+func edlHour(rate FrameRate) int64 {
+	frames, _ := rate.ParseTimecode("01:00:00:00")
+	return frames
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
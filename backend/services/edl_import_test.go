@@ -0,0 +1,217 @@
+package services
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"video-editor/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func mustParseEDL(t *testing.T, edl string, rate FrameRate) *importedTimeline {
+	t.Helper()
+	imported, err := parseEDL([]byte(edl), rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return imported
+}
+
+// checkClip compares a clip's times, in seconds, to the millisecond
+func checkClip(t *testing.T, clip importedClip, start, end, sourceStart float64) {
+	t.Helper()
+	item := clip.item
+	near := func(a, b float64) bool { return math.Abs(a-b) < 0.0015 }
+	if !near(item.StartTime, start) || !near(item.EndTime, end) || !near(item.SourceStart, sourceStart) {
+		t.Errorf("%s: got %.3f-%.3f from %.3f, want %.3f-%.3f from %.3f",
+			clip.ref, item.StartTime, item.EndTime, item.SourceStart, start, end, sourceStart)
+	}
+}
+
+func TestParseEDLDropFrame(t *testing.T) {
+	// 00:01:00;02 is the first frame of the second minute, 1800 frames in.
+	// Read without dropping it would be 1802.
+	tests := []struct {
+		name string
+		edl  string
+	}{
+		{"fcm", `TITLE: Drop
+FCM: DROP FRAME
+
+001  A001     V     C        00:01:00:02 00:01:05:02 01:00:00:00 01:00:05:00
+`},
+		{"semicolon", `TITLE: Drop
+FCM: NON-DROP FRAME
+
+001  A001     V     C        00:01:00;02 00:01:05;02 01:00:00;00 01:00:05;00
+`},
+	}
+	for _, tt := range tests {
+		imported := mustParseEDL(t, tt.edl, DefaultFrameRate)
+		if len(imported.clips) != 1 {
+			t.Fatalf("%s: got %d clips, want 1", tt.name, len(imported.clips))
+		}
+		clip := imported.clips[0]
+		checkClip(t, clip, 0, 150/29.97, 1800/29.97)
+		if want := (FrameRate{30000, 1001, true}); clip.sourceRate != want {
+			t.Errorf("%s: source rate %+v, want %+v", tt.name, clip.sourceRate, want)
+		}
+	}
+
+	// Only NTSC rates drop frames
+	imported := mustParseEDL(t, "FCM: DROP FRAME\n001  A001 V C 00:00:10:00 00:00:15:00 01:00:00:00 01:00:05:00\n", frameRates["25"])
+	checkClip(t, imported.clips[0], 0, 5, 10)
+}
+
+func TestParseEDLDialects(t *testing.T) {
+	edl := "\ufeffTITLE: Dialects\r\n" +
+		"FCM: NON-DROP FRAME\r\n" +
+		"\r\n" +
+		"000001  TAPE 12 B  V     C        00:00:10:00 00:00:12:00 01:00:00:00 01:00:02:00\r\n" +
+		"FROM CLIP NAME:  Interview Take 3.mov\r\n" +
+		"0002  AX       AA/V  C        00:00:00:00 00:00:03:00 01:00:02:00 01:00:05:00\r\n" +
+		"* SOURCE FILE: C:\\Media\\broll.MOV\r\n" +
+		"3    TAPE 7   V     C        00:00:01:00 00:00:02:00 01:00:05:00 01:00:06:00\r\n"
+	imported := mustParseEDL(t, edl, DefaultFrameRate)
+	if imported.name != "Dialects" {
+		t.Errorf("title %q", imported.name)
+	}
+	if len(imported.clips) != 3 {
+		t.Fatalf("got %d clips, want 3", len(imported.clips))
+	}
+
+	tests := []struct {
+		ref   string
+		name  string
+		names []importedName
+	}{
+		{"event 000001", "Interview Take 3.mov", []importedName{{value: "Interview Take 3.mov"}, {value: "TAPE 12 B", reel: true}}},
+		{"event 0002", "broll.MOV", []importedName{{value: `C:\Media\broll.MOV`}}},
+		{"event 3", "TAPE 7", []importedName{{value: "TAPE 7", reel: true}}},
+	}
+	for i, tt := range tests {
+		clip := imported.clips[i]
+		if clip.ref != tt.ref || clip.item.Name != tt.name || !slices.Equal(clip.names, tt.names) {
+			t.Errorf("clip %d: got %s %q %+v, want %s %q %+v", i, clip.ref, clip.item.Name, clip.names, tt.ref, tt.name, tt.names)
+		}
+	}
+	checkClip(t, imported.clips[0], 0, 2, 10)
+	if !imported.clips[1].withAudio || !imported.hasAudio {
+		t.Error("AA/V event should play its own sound")
+	}
+}
+
+func TestParseEDLDissolve(t *testing.T) {
+	edl := `TITLE: Dissolve
+FCM: NON-DROP FRAME
+
+001  A001     V     C        00:00:00:00 00:00:05:00 01:00:00:00 01:00:05:00
+002  A001     V     C        00:00:05:00 00:00:05:00 01:00:05:00 01:00:05:00
+002  B001     V     D    030 00:00:10:00 00:00:15:00 01:00:05:00 01:00:10:00
+* FROM CLIP NAME: a.mov
+* TO CLIP NAME: b.mov
+`
+	imported := mustParseEDL(t, edl, DefaultFrameRate)
+	if len(imported.clips) != 2 {
+		t.Fatalf("got %d clips, want 2", len(imported.clips))
+	}
+	// The clips meet at the middle of the second-long dissolve
+	checkClip(t, imported.clips[0], 0, 5.5, 0)
+	checkClip(t, imported.clips[1], 5.5, 10, 10.5)
+	if name := imported.clips[1].item.Name; name != "b.mov" {
+		t.Errorf("incoming clip named %q, want b.mov", name)
+	}
+	want := []models.Transition{{ID: "transition-1", Type: "fade", FromClipID: "clip-1", ToClipID: "clip-2", Duration: 1}}
+	if !slices.Equal(imported.transitions, want) {
+		t.Errorf("transitions %+v, want %+v", imported.transitions, want)
+	}
+	if len(imported.warnings) != 0 {
+		t.Errorf("warnings %q", imported.warnings)
+	}
+}
+
+func TestParseEDLBlackReels(t *testing.T) {
+	edl := `TITLE: Black
+FCM: NON-DROP FRAME
+
+001  BL       V     C        00:00:00:00 00:00:00:00 01:00:00:00 01:00:00:00
+001  A001     V     D    030 00:00:00:00 00:00:05:00 01:00:00:00 01:00:05:00
+002  BLACK    V     C        00:00:00:00 00:00:02:00 01:00:05:00 01:00:07:00
+003  A002     V     C        00:00:00:00 00:00:03:00 01:00:07:00 01:00:10:00
+004  BLK      V     D    015 00:00:00:00 00:00:01:00 01:00:10:00 01:00:11:00
+`
+	imported := mustParseEDL(t, edl, DefaultFrameRate)
+	if len(imported.clips) != 2 {
+		t.Fatalf("got %d clips, want 2", len(imported.clips))
+	}
+	// Black leaves a gap, and fades from and to it become cuts
+	checkClip(t, imported.clips[0], 0, 5, 0)
+	checkClip(t, imported.clips[1], 7, 10, 0)
+	if len(imported.transitions) != 0 {
+		t.Errorf("transitions %+v", imported.transitions)
+	}
+	want := []string{
+		"event 001: transition from black imported as a cut",
+		"event 004: transition to black imported as a cut",
+	}
+	if !slices.Equal(imported.warnings, want) {
+		t.Errorf("warnings %q, want %q", imported.warnings, want)
+	}
+}
+
+func TestParseEDLErrors(t *testing.T) {
+	for _, edl := range []string{
+		"TITLE: Empty\n",
+		"001  A001  V  C  00:00:00:00 00:00:05:00 01:00:00:00\n",
+		"001  A001  V  X  00:00:00:00 00:00:05:00 01:00:00:00 01:00:05:00\n",
+		"001  A001  V  C  00:00:05:00 00:00:00:00 01:00:00:00 01:00:05:00\n",
+		"001  A001  V  C  00:00:00:40 00:00:05:00 01:00:00:00 01:00:05:00\n",
+	} {
+		if _, err := parseEDL([]byte(edl), DefaultFrameRate); err == nil {
+			t.Errorf("%q: expected an error", strings.TrimSpace(edl))
+		}
+	}
+}
+
+func TestImportEDLSourceTimecode(t *testing.T) {
+	edl := `TITLE: Camera
+FCM: NON-DROP FRAME
+
+001  A001     V     C        01:00:10:00 01:00:15:00 01:00:00:00 01:00:05:00
+* FROM CLIP NAME: camera.mov
+002  A002     V     C        00:00:10:00 00:00:15:00 01:00:05:00 01:00:10:00
+* FROM CLIP NAME: export.mov
+003  A003     V     C        01:00:10:00 01:00:15:00 01:00:10:00 01:00:15:00
+* FROM CLIP NAME: untimed.mov
+`
+	asset := func(name, startTimecode string) models.MediaAsset {
+		return models.MediaAsset{ID: primitive.NewObjectID(), Filename: name, Type: "video",
+			Metadata: &models.MediaMetadata{Duration: 60, StartTimecode: startTimecode}}
+	}
+	assets := []models.MediaAsset{
+		asset("camera.mov", "01:00:00:00"),
+		asset("export.mov", "01:00:00:00"),
+		asset("untimed.mov", ""),
+	}
+	imported := mustParseEDL(t, edl, DefaultFrameRate)
+	result := &InterchangeImport{}
+	timeline := resolveImported(imported, newAssetMatcher(assets), result)
+
+	var sourceStarts []float64
+	for _, item := range timeline.MediaItems {
+		sourceStarts = append(sourceStarts, item.SourceStart)
+	}
+	// The camera file's in point counts from its start timecode. An in
+	// point before it counts from the start of the file, and one past the
+	// end of media without a start timecode is reported.
+	if want := []float64{10, 10, 3610}; !slices.Equal(sourceStarts, want) {
+		t.Errorf("source starts %v, want %v", sourceStarts, want)
+	}
+	want := []string{"event 003: source in point 01:00:10:00 is past the end of untimed.mov"}
+	if !slices.Equal(imported.warnings, want) {
+		t.Errorf("warnings %q, want %q", imported.warnings, want)
+	}
+}
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rate control modes supported by the exporter
+const (
+	RateControlCRF        = "crf"         // Constant quality, driven by Quality
+	RateControlCBR        = "cbr"         // Constant bitrate
+	RateControlVBR        = "vbr"         // Variable bitrate capped by MaxRate/BufSize
+	RateControlTargetSize = "target_size" // Bitrate computed from TargetSizeMB and project duration
+)
+
+const (
+	defaultAudioBitrateKbps = 128
+	defaultFrameRate        = 30
+	maxFrameRate            = 120
+
+	// containerOverhead is the share of a target size reserved for muxing overhead.
+	containerOverhead = 0.02
+)
+
+// x264Presets lists the encoder presets accepted by libx264
+var x264Presets = map[string]bool{
+	"ultrafast": true, "superfast": true, "veryfast": true, "faster": true, "fast": true,
+	"medium": true, "slow": true, "slower": true, "veryslow": true, "placebo": true,
+}
+
+// crfByQuality maps the quality presets to libx264 CRF values
+var crfByQuality = map[string]int{
+	"high":   18,
+	"medium": 23,
+	"low":    28,
+}
+
+// exportCodec names the encoders used for a container
+type exportCodec struct {
+	video string
+	audio string
+}
+
+// exportCodecs maps each export format to encoders it can hold. WebM only
+// takes VP8/VP9/AV1 with Vorbis/Opus, and AVI has no standard AAC mapping.
+var exportCodecs = map[string]exportCodec{
+	"mp4":  {"libx264", "aac"},
+	"mov":  {"libx264", "aac"},
+	"avi":  {"libx264", "libmp3lame"},
+	"webm": {"libvpx-vp9", "libopus"},
+}
+
+// vp9CRFByQuality maps the quality presets to libvpx-vp9 CRF values, which
+// run from 0 to 63
+var vp9CRFByQuality = map[string]int{
+	"high":   24,
+	"medium": 31,
+	"low":    37,
+}
+
+// vp9CPUUsedByPreset maps the libx264 presets to libvpx-vp9 speeds
+var vp9CPUUsedByPreset = map[string]int{
+	"ultrafast": 5, "superfast": 5, "veryfast": 5, "faster": 4, "fast": 3,
+	"medium": 2, "slow": 1, "slower": 1, "veryslow": 0, "placebo": 0,
+}
+
+// ExportSettings describes how a project is encoded on export
+type ExportSettings struct {
+	Quality    string `json:"quality"`    // "high", "medium", "low"
+	Format     string `json:"format"`     // "mp4", "mov", "webm", "avi"
+	Resolution string `json:"resolution"` // "1920x1080", "1280x720", "854x480"
+
+	RateControl      string  `json:"rateControl"`  // "crf", "cbr", "vbr", "target_size"
+	VideoBitrateKbps int     `json:"videoBitrate"` // Target video bitrate for cbr/vbr
+	MaxRateKbps      int     `json:"maxRate"`      // Peak bitrate for vbr
+	BufSizeKbps      int     `json:"bufSize"`      // Rate control buffer for cbr/vbr
+	AudioBitrateKbps int     `json:"audioBitrate"` // Defaults to 128
+	TwoPass          bool    `json:"twoPass"`      // Always on for target_size
+	TargetSizeMB     float64 `json:"targetSizeMB"` // Output size in MiB for target_size
+	Preset           string  `json:"preset"`       // libx264 preset, e.g. "veryfast"; mapped to a speed for webm
+	FrameRate        float64 `json:"frameRate"`    // Defaults to 30
+}
+
+// Normalize fills unset fields and validates the combination of options
+func (s *ExportSettings) Normalize() error {
+	if s.Quality == "" {
+		s.Quality = "medium"
+	}
+	if s.Format == "" {
+		s.Format = "mp4"
+	}
+	if s.Resolution == "" {
+		s.Resolution = "1920x1080"
+	}
+	if s.RateControl == "" {
+		s.RateControl = RateControlCRF
+	}
+	if s.AudioBitrateKbps == 0 {
+		s.AudioBitrateKbps = defaultAudioBitrateKbps
+	}
+	if s.FrameRate == 0 {
+		s.FrameRate = defaultFrameRate
+	}
+
+	if _, ok := exportCodecs[s.Format]; !ok {
+		return fmt.Errorf("unsupported format: %s", s.Format)
+	}
+	if _, ok := crfByQuality[s.Quality]; !ok {
+		return fmt.Errorf("unsupported quality: %s", s.Quality)
+	}
+	if s.Preset != "" && !x264Presets[s.Preset] {
+		return fmt.Errorf("unsupported encoder preset: %s", s.Preset)
+	}
+	if s.FrameRate < 1 || s.FrameRate > maxFrameRate {
+		return fmt.Errorf("frame rate must be between 1 and %d", maxFrameRate)
+	}
+	if s.VideoBitrateKbps < 0 || s.MaxRateKbps < 0 || s.BufSizeKbps < 0 || s.AudioBitrateKbps < 0 {
+		return errors.New("bitrates must not be negative")
+	}
+
+	switch s.RateControl {
+	case RateControlCRF:
+		if s.TwoPass {
+			return errors.New("two-pass encoding requires a bitrate-based rate control")
+		}
+	case RateControlCBR:
+		if s.VideoBitrateKbps == 0 {
+			return errors.New("cbr rate control requires videoBitrate")
+		}
+		if s.BufSizeKbps == 0 {
+			s.BufSizeKbps = s.VideoBitrateKbps
+		}
+	case RateControlVBR:
+		if s.MaxRateKbps == 0 {
+			return errors.New("vbr rate control requires maxRate")
+		}
+		if s.VideoBitrateKbps > s.MaxRateKbps {
+			return errors.New("videoBitrate must not exceed maxRate")
+		}
+		if s.BufSizeKbps == 0 {
+			s.BufSizeKbps = 2 * s.MaxRateKbps
+		}
+		if s.TwoPass && s.VideoBitrateKbps == 0 {
+			return errors.New("two-pass vbr requires videoBitrate")
+		}
+	case RateControlTargetSize:
+		if s.TargetSizeMB <= 0 {
+			return errors.New("target_size rate control requires targetSizeMB")
+		}
+		s.TwoPass = true
+	default:
+		return fmt.Errorf("unsupported rate control: %s", s.RateControl)
+	}
+	return nil
+}
+
+// dimensions parses Resolution into width and height
+func (s *ExportSettings) dimensions() (int, int, error) {
+	resParts := strings.Split(s.Resolution, "x")
+	if len(resParts) != 2 {
+		return 0, 0, errors.New("invalid resolution format")
+	}
+	width, err1 := strconv.Atoi(resParts[0])
+	height, err2 := strconv.Atoi(resParts[1])
+	if err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+		return 0, 0, errors.New("invalid resolution format")
+	}
+	return width, height, nil
+}
+
+// targetVideoBitrateKbps computes the video bitrate needed to hit TargetSizeMB
+// for a project of the given duration, after reserving room for audio.
+func (s *ExportSettings) targetVideoBitrateKbps(duration float64, hasAudio bool) (int, error) {
+	if duration <= 0 {
+		return 0, errors.New("target size encoding requires a positive project duration")
+	}
+	totalKbits := s.TargetSizeMB * 1024 * 1024 * 8 / 1000 * (1 - containerOverhead)
+	totalKbps := totalKbits / duration
+	if hasAudio {
+		totalKbps -= float64(s.AudioBitrateKbps)
+	}
+	if totalKbps < 1 {
+		return 0, fmt.Errorf("target size of %.1f MB is too small for a %.1fs project", s.TargetSizeMB, duration)
+	}
+	return int(totalKbps), nil
+}
+
+// bitsPerPixelByQuality approximates libx264 output density at each CRF preset
+var bitsPerPixelByQuality = map[string]float64{
+	"high":   0.15,
+	"medium": 0.1,
+	"low":    0.05,
+}
+
+// EstimatedSizeBytes estimates the output file size for a project of the
+// given duration. Constant-quality encodes are estimated from the resolution
+// and frame rate, so the result is an upper-end guess rather than exact.
+// Settings must have been normalized.
+func (s *ExportSettings) EstimatedSizeBytes(duration float64) int64 {
+	if s.RateControl == RateControlTargetSize {
+		return int64(s.TargetSizeMB * 1024 * 1024)
+	}
+
+	var videoKbps float64
+	switch {
+	case s.RateControl == RateControlCBR || s.VideoBitrateKbps > 0:
+		videoKbps = float64(s.VideoBitrateKbps)
+	case s.RateControl == RateControlVBR:
+		videoKbps = float64(s.MaxRateKbps)
+	default:
+		width, height, err := s.dimensions()
+		if err != nil {
+			return 0
+		}
+		videoKbps = float64(width*height) * s.FrameRate * bitsPerPixelByQuality[s.Quality] / 1000
+	}
+
+	totalKbps := videoKbps + float64(s.AudioBitrateKbps)
+	return int64(totalKbps * 1000 / 8 * duration)
+}
+
+// videoEncoderArgs returns the video codec and rate control arguments
+func (s *ExportSettings) videoEncoderArgs(duration float64, hasAudio bool) ([]string, error) {
+	codec := exportCodecs[s.Format].video
+	args := []string{"-c:v", codec}
+	vp9 := codec == "libvpx-vp9"
+	switch {
+	case vp9:
+		preset := s.Preset
+		if preset == "" {
+			preset = "medium"
+		}
+		args = append(args, "-deadline", "good", "-cpu-used", strconv.Itoa(vp9CPUUsedByPreset[preset]))
+	case s.Preset != "":
+		args = append(args, "-preset", s.Preset)
+	}
+
+	switch s.RateControl {
+	case RateControlCRF:
+		args = append(args, s.crfArgs(vp9)...)
+		if vp9 {
+			// libvpx only encodes at constant quality without a bitrate
+			args = append(args, "-b:v", "0")
+		}
+	case RateControlCBR:
+		rate := kbps(s.VideoBitrateKbps)
+		args = append(args, "-b:v", rate, "-minrate", rate, "-maxrate", rate, "-bufsize", kbps(s.BufSizeKbps))
+	case RateControlVBR:
+		if s.VideoBitrateKbps > 0 {
+			args = append(args, "-b:v", kbps(s.VideoBitrateKbps))
+		} else {
+			// Capped CRF: constant quality that never exceeds the peak rate
+			args = append(args, s.crfArgs(vp9)...)
+			if vp9 {
+				args = append(args, "-b:v", kbps(s.MaxRateKbps))
+			}
+		}
+		args = append(args, "-maxrate", kbps(s.MaxRateKbps), "-bufsize", kbps(s.BufSizeKbps))
+	case RateControlTargetSize:
+		bitrate, err := s.targetVideoBitrateKbps(duration, hasAudio)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-b:v", kbps(bitrate), "-maxrate", kbps(bitrate*3/2), "-bufsize", kbps(bitrate*2))
+	}
+
+	args = append(args, "-r", strconv.FormatFloat(s.FrameRate, 'f', -1, 64))
+	return args, nil
+}
+
+// crfArgs returns the constant quality for Quality on the format's encoder
+func (s *ExportSettings) crfArgs(vp9 bool) []string {
+	if vp9 {
+		return []string{"-crf", strconv.Itoa(vp9CRFByQuality[s.Quality])}
+	}
+	return []string{"-crf", strconv.Itoa(crfByQuality[s.Quality])}
+}
+
+// audioEncoderArgs returns the audio codec arguments
+func (s *ExportSettings) audioEncoderArgs() []string {
+	return []string{"-c:a", exportCodecs[s.Format].audio, "-b:a", kbps(s.AudioBitrateKbps)}
+}
+
+func kbps(v int) string {
+	return fmt.Sprintf("%dk", v)
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExportCodecsByFormat(t *testing.T) {
+	tests := []struct {
+		settings     ExportSettings
+		video, audio string
+	}{
+		{ExportSettings{}, "-c:v libx264 -crf 23 -r 30", "-c:a aac -b:a 128k"},
+		{ExportSettings{Format: "mov", Preset: "fast"}, "-c:v libx264 -preset fast -crf 23 -r 30", "-c:a aac -b:a 128k"},
+		{ExportSettings{Format: "avi", Quality: "high"}, "-c:v libx264 -crf 18 -r 30", "-c:a libmp3lame -b:a 128k"},
+		{ExportSettings{Format: "webm"}, "-c:v libvpx-vp9 -deadline good -cpu-used 2 -crf 31 -b:v 0 -r 30", "-c:a libopus -b:a 128k"},
+		{ExportSettings{Format: "webm", Preset: "veryfast", RateControl: RateControlVBR, MaxRateKbps: 4000},
+			"-c:v libvpx-vp9 -deadline good -cpu-used 5 -crf 31 -b:v 4000k -maxrate 4000k -bufsize 8000k -r 30", "-c:a libopus -b:a 128k"},
+		{ExportSettings{Format: "webm", RateControl: RateControlCBR, VideoBitrateKbps: 2000, AudioBitrateKbps: 96},
+			"-c:v libvpx-vp9 -deadline good -cpu-used 2 -b:v 2000k -minrate 2000k -maxrate 2000k -bufsize 2000k -r 30", "-c:a libopus -b:a 96k"},
+	}
+	for _, tt := range tests {
+		settings := tt.settings
+		if err := settings.Normalize(); err != nil {
+			t.Fatalf("%+v: %v", tt.settings, err)
+		}
+		video, err := settings.videoEncoderArgs(60, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(video, " "); got != tt.video {
+			t.Errorf("%s video: got %q, want %q", settings.Format, got, tt.video)
+		}
+		if got := settings.audioEncoderArgs(); !slices.Equal(got, strings.Fields(tt.audio)) {
+			t.Errorf("%s audio: got %q, want %q", settings.Format, strings.Join(got, " "), tt.audio)
+		}
+	}
+
+	unknown := ExportSettings{Format: "mkv"}
+	if err := unknown.Normalize(); err == nil {
+		t.Error("expected mkv to be rejected")
+	}
+}
@@ -0,0 +1,455 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"video-editor/models"
+)
+
+// fcpxmlVersion is the FCPXML version written, the first with media-rep
+const fcpxmlVersion = "1.10"
+
+// Effects referenced by FCPXML transitions and titles
+const (
+	fcpxmlDissolveUID = "FxPlug:4731E73A-8DAC-4113-9A30-AE85B1761265"
+	fcpxmlTitleUID    = ".../Titles.localized/Bumper:Opener.localized/Basic Title.localized/Basic Title.moti"
+)
+
+type fcpxmlDocument struct {
+	XMLName   xml.Name        `xml:"fcpxml"`
+	Version   string          `xml:"version,attr"`
+	Resources fcpxmlResources `xml:"resources"`
+	Library   fcpxmlLibrary   `xml:"library"`
+}
+
+type fcpxmlResources struct {
+	Format  fcpxmlFormat   `xml:"format"`
+	Assets  []fcpxmlAsset  `xml:"asset"`
+	Effects []fcpxmlEffect `xml:"effect"`
+}
+
+type fcpxmlFormat struct {
+	ID            string `xml:"id,attr"`
+	FrameDuration string `xml:"frameDuration,attr"`
+	Width         int    `xml:"width,attr"`
+	Height        int    `xml:"height,attr"`
+}
+
+type fcpxmlAsset struct {
+	ID       string         `xml:"id,attr"`
+	Name     string         `xml:"name,attr"`
+	Start    string         `xml:"start,attr"`
+	Duration string         `xml:"duration,attr"`
+	HasVideo string         `xml:"hasVideo,attr,omitempty"`
+	HasAudio string         `xml:"hasAudio,attr,omitempty"`
+	Format   string         `xml:"format,attr,omitempty"`
+	MediaRep fcpxmlMediaRep `xml:"media-rep"`
+}
+
+type fcpxmlMediaRep struct {
+	Kind string `xml:"kind,attr"`
+	Src  string `xml:"src,attr"`
+}
+
+type fcpxmlEffect struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:"name,attr"`
+	UID  string `xml:"uid,attr"`
+}
+
+type fcpxmlLibrary struct {
+	Event fcpxmlEvent `xml:"event"`
+}
+
+type fcpxmlEvent struct {
+	Name    string        `xml:"name,attr"`
+	Project fcpxmlProject `xml:"project"`
+}
+
+type fcpxmlProject struct {
+	Name     string         `xml:"name,attr"`
+	Sequence fcpxmlSequence `xml:"sequence"`
+}
+
+type fcpxmlSequence struct {
+	Format   string      `xml:"format,attr"`
+	Duration string      `xml:"duration,attr"`
+	TCStart  string      `xml:"tcStart,attr"`
+	TCFormat string      `xml:"tcFormat,attr"`
+	Spine    fcpxmlSpine `xml:"spine"`
+}
+
+type fcpxmlSpine struct {
+	Items []any
+}
+
+// fcpxmlItem is a clip, still, title or gap. Clips on other lanes are
+// anchored in the item of the primary storyline playing when they start.
+type fcpxmlItem struct {
+	XMLName   xml.Name
+	Ref       string           `xml:"ref,attr,omitempty"`
+	Name      string           `xml:"name,attr,omitempty"`
+	Lane      int              `xml:"lane,attr,omitempty"`
+	Offset    string           `xml:"offset,attr"`
+	Start     string           `xml:"start,attr"`
+	Duration  string           `xml:"duration,attr"`
+	SrcEnable string           `xml:"srcEnable,attr,omitempty"`
+	Text      *fcpxmlText      `xml:"text"`
+	TextStyle *fcpxmlTextStyle `xml:"text-style-def"`
+	Connected []*fcpxmlItem
+
+	// Where the item sits, in frames, for anchoring
+	offset, start, duration int64
+}
+
+type fcpxmlText struct {
+	Style fcpxmlTextSpan `xml:"text-style"`
+}
+
+type fcpxmlTextSpan struct {
+	Ref  string `xml:"ref,attr"`
+	Text string `xml:",chardata"`
+}
+
+type fcpxmlTextStyleDef struct {
+	Font      string  `xml:"font,attr,omitempty"`
+	FontSize  float64 `xml:"fontSize,attr,omitempty"`
+	FontColor string  `xml:"fontColor,attr,omitempty"`
+	Bold      string  `xml:"bold,attr,omitempty"`
+	Italic    string  `xml:"italic,attr,omitempty"`
+	Alignment string  `xml:"alignment,attr,omitempty"`
+}
+
+type fcpxmlTextStyle struct {
+	ID    string             `xml:"id,attr"`
+	Style fcpxmlTextStyleDef `xml:"text-style"`
+}
+
+type fcpxmlTransition struct {
+	XMLName  xml.Name          `xml:"transition"`
+	Name     string            `xml:"name,attr"`
+	Offset   string            `xml:"offset,attr"`
+	Duration string            `xml:"duration,attr"`
+	Filter   fcpxmlFilterVideo `xml:"filter-video"`
+}
+
+type fcpxmlFilterVideo struct {
+	Ref  string `xml:"ref,attr"`
+	Name string `xml:"name,attr"`
+}
+
+// fcpxmlWriter numbers resources as clips refer to them
+type fcpxmlWriter struct {
+	p         *InterchangeProject
+	resources fcpxmlResources
+	assets    map[string]int   // Index in resources.Assets by asset ID
+	lengths   map[string]int64 // Furthest frame played of assets of unknown length
+	effects   map[string]string
+	styles    int
+}
+
+// WriteFCPXML writes the project as FCPXML. The lowest video track is the
+// primary storyline, with gaps where it is empty; other video tracks become
+// connected clips on lanes above it and audio clips on lanes below. Video
+// clips carry their own sound, muted ones only their picture. Transitions
+// can only be written on the primary storyline, where wipes become cross
+// dissolves too. Text clips are Basic Titles. Media are referred to by
+// filename, to be relinked in the editor.
+func WriteFCPXML(w io.Writer, p *InterchangeProject) error {
+	fw := &fcpxmlWriter{
+		p: p,
+		resources: fcpxmlResources{Format: fcpxmlFormat{
+			ID:            "r1",
+			FrameDuration: fcpxmlTime(p.Rate, 1),
+			Width:         p.Width,
+			Height:        p.Height,
+		}},
+		assets:  make(map[string]int),
+		lengths: make(map[string]int64),
+		effects: make(map[string]string),
+	}
+
+	video := p.tracks("video")
+	var lanes [][]interchangeClip
+	for _, track := range p.tracks("audio") {
+		var clips []interchangeClip
+		for _, clip := range track.clips {
+			if !clip.audio {
+				clips = append(clips, clip)
+			}
+		}
+		if len(clips) > 0 {
+			lanes = append(lanes, clips)
+		}
+	}
+	end := int64(0)
+	for _, track := range video {
+		end = max(end, track.clips[len(track.clips)-1].end())
+	}
+	for _, clips := range lanes {
+		end = max(end, clips[len(clips)-1].end())
+	}
+
+	var primary interchangeTrack
+	if len(video) > 0 {
+		primary, video = video[0], video[1:]
+	}
+	spine := fw.spine(primary, end)
+	for i, track := range video {
+		for _, clip := range track.clips {
+			fw.anchor(spine, fw.item(clip, i+1))
+		}
+	}
+	for i, clips := range lanes {
+		for _, clip := range clips {
+			fw.anchor(spine, fw.item(clip, -(i+1)))
+		}
+	}
+	for id, length := range fw.lengths {
+		fw.resources.Assets[fw.assets[id]].Duration = fcpxmlTime(p.Rate, length)
+	}
+
+	name := strings.Join(strings.Fields(p.Name), " ")
+	if name == "" {
+		name = "Untitled"
+	}
+	tcFormat := "NDF"
+	if p.Rate.DropFrame {
+		tcFormat = "DF"
+	}
+	doc := fcpxmlDocument{
+		Version:   fcpxmlVersion,
+		Resources: fw.resources,
+		Library: fcpxmlLibrary{Event: fcpxmlEvent{
+			Name: name,
+			Project: fcpxmlProject{
+				Name: name,
+				Sequence: fcpxmlSequence{
+					Format:   "r1",
+					Duration: fcpxmlTime(p.Rate, end),
+					TCStart:  "0s",
+					TCFormat: tcFormat,
+					Spine:    fcpxmlSpine{Items: spine},
+				},
+			},
+		}},
+	}
+
+	if _, err := io.WriteString(w, xml.Header+"<!DOCTYPE fcpxml>\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "    ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// spine lays out the primary storyline up to the end frame, filling the
+// space around its clips with gaps
+func (fw *fcpxmlWriter) spine(track interchangeTrack, end int64) []any {
+	transitions := fw.p.transitions(track)
+	var spine []any
+	at := int64(0)
+	for _, clip := range track.clips {
+		if clip.start > at {
+			spine = append(spine, fcpxmlGap(fw.p.Rate, at, clip.start-at))
+		}
+		if t, ok := transitions[clip.item.ID]; ok {
+			spine = append(spine, &fcpxmlTransition{
+				Name:     "Cross Dissolve",
+				Offset:   fcpxmlTime(fw.p.Rate, clip.start-t.before),
+				Duration: fcpxmlTime(fw.p.Rate, t.duration()),
+				Filter:   fcpxmlFilterVideo{Ref: fw.effect("Cross Dissolve", fcpxmlDissolveUID), Name: "Cross Dissolve"},
+			})
+		}
+		spine = append(spine, fw.item(clip, 0))
+		at = clip.end()
+	}
+	if end > at {
+		spine = append(spine, fcpxmlGap(fw.p.Rate, at, end-at))
+	}
+	return spine
+}
+
+// anchor connects an item to the storyline item playing when it starts,
+// with its offset counted in that item's own time
+func (fw *fcpxmlWriter) anchor(spine []any, item *fcpxmlItem) {
+	for _, s := range spine {
+		parent, ok := s.(*fcpxmlItem)
+		if !ok || item.offset < parent.offset || item.offset >= parent.offset+parent.duration {
+			continue
+		}
+		item.Offset = fcpxmlTime(fw.p.Rate, parent.start+item.offset-parent.offset)
+		parent.Connected = append(parent.Connected, item)
+		return
+	}
+}
+
+// item writes a clip as an asset clip, a still or a title on a lane, 0
+// being the primary storyline
+func (fw *fcpxmlWriter) item(clip interchangeClip, lane int) *fcpxmlItem {
+	item := &fcpxmlItem{
+		Name:     clipName(clip),
+		Lane:     lane,
+		Offset:   fcpxmlTime(fw.p.Rate, clip.start),
+		Start:    fcpxmlTime(fw.p.Rate, clip.in),
+		Duration: fcpxmlTime(fw.p.Rate, clip.duration),
+		offset:   clip.start,
+		start:    clip.in,
+		duration: clip.duration,
+	}
+	switch clip.item.Type {
+	case "text":
+		item.XMLName.Local = "title"
+		item.Ref = fw.effect("Basic Title", fcpxmlTitleUID)
+		if clip.item.Name == "" {
+			item.Name = "Basic Title"
+		}
+		fw.styles++
+		id := fmt.Sprintf("ts%d", fw.styles)
+		text := clip.item.Text
+		if text == "" {
+			text = clip.item.Content
+		}
+		item.Text = &fcpxmlText{Style: fcpxmlTextSpan{Ref: id, Text: text}}
+		item.TextStyle = &fcpxmlTextStyle{ID: id, Style: fcpxmlStyle(clip.item)}
+		return item
+	case "image":
+		item.XMLName.Local = "video"
+	default:
+		item.XMLName.Local = "asset-clip"
+		if clip.item.Type == "video" && clip.item.IsMuted && clip.media.HasAudio {
+			item.SrcEnable = "video"
+		}
+	}
+	item.Ref = fw.asset(clip)
+	return item
+}
+
+// asset returns the resource ID of a clip's media, adding it on first use
+func (fw *fcpxmlWriter) asset(clip interchangeClip) string {
+	id := clip.item.AssetID
+	if clip.media.Type != "image" && clip.media.Duration <= 0 {
+		fw.lengths[id] = max(fw.lengths[id], clip.out())
+	}
+	if i, ok := fw.assets[id]; ok {
+		return fw.resources.Assets[i].ID
+	}
+	asset := fcpxmlAsset{
+		ID:       fw.nextID(),
+		Name:     clip.media.Name,
+		Start:    "0s",
+		Duration: fcpxmlTime(fw.p.Rate, fw.p.Rate.Frames(clip.media.Duration)),
+		MediaRep: fcpxmlMediaRep{Kind: "original-media", Src: url.PathEscape(clip.media.Name)},
+	}
+	if clip.media.Type != "audio" {
+		asset.HasVideo, asset.Format = "1", "r1"
+	}
+	if clip.media.HasAudio {
+		asset.HasAudio = "1"
+	}
+	if clip.media.Type == "image" {
+		asset.Duration = "0s"
+	}
+	fw.assets[id] = len(fw.resources.Assets)
+	fw.resources.Assets = append(fw.resources.Assets, asset)
+	return asset.ID
+}
+
+// effect returns the resource ID of an effect, adding it on first use
+func (fw *fcpxmlWriter) effect(name, uid string) string {
+	if id, ok := fw.effects[uid]; ok {
+		return id
+	}
+	id := fw.nextID()
+	fw.effects[uid] = id
+	fw.resources.Effects = append(fw.resources.Effects, fcpxmlEffect{ID: id, Name: name, UID: uid})
+	return id
+}
+
+// nextID numbers resources after the format, r1
+func (fw *fcpxmlWriter) nextID() string {
+	return fmt.Sprintf("r%d", 2+len(fw.resources.Assets)+len(fw.resources.Effects))
+}
+
+// fcpxmlGap is empty space on the primary storyline
+func fcpxmlGap(rate FrameRate, offset, duration int64) *fcpxmlItem {
+	return &fcpxmlItem{
+		XMLName:  xml.Name{Local: "gap"},
+		Name:     "Gap",
+		Offset:   fcpxmlTime(rate, offset),
+		Start:    "0s",
+		Duration: fcpxmlTime(rate, duration),
+		offset:   offset,
+		duration: duration,
+	}
+}
+
+// fcpxmlStyle maps a text clip's font settings to a text style
+func fcpxmlStyle(item models.TimelineItem) fcpxmlTextStyleDef {
+	style := fcpxmlTextStyleDef{Font: item.FontFamily, FontSize: item.FontSize, Alignment: item.TextAlign}
+	if style.Alignment == "justify" {
+		style.Alignment = "justified"
+	}
+	color := item.Color
+	if color == "" {
+		color = item.FontColor
+	}
+	style.FontColor = fcpxmlColor(color)
+	if weight, err := strconv.Atoi(item.FontWeight); item.FontWeight == "bold" || (err == nil && weight >= 600) {
+		style.Bold = "1"
+	}
+	if item.FontStyle == "italic" {
+		style.Italic = "1"
+	}
+	return style
+}
+
+// fcpxmlColor converts a #rgb or #rrggbb color to FCPXML's "r g b a".
+// Other colors are left out.
+func fcpxmlColor(color string) string {
+	hex := strings.TrimPrefix(color, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if !strings.HasPrefix(color, "#") || len(hex) != 6 || err != nil {
+		return ""
+	}
+	channel := func(shift uint) string {
+		return strconv.FormatFloat(float64(v>>shift&0xff)/255, 'f', -1, 32)
+	}
+	return fmt.Sprintf("%s %s %s 1", channel(16), channel(8), channel(0))
+}
+
+// fcpxmlTime writes a frame count as a rational number of seconds
+func fcpxmlTime(rate FrameRate, frames int64) string {
+	num, den := frames*rate.Den, rate.Num
+	if num == 0 {
+		return "0s"
+	}
+	d := gcd(num, den)
+	num, den = num/d, den/d
+	if den == 1 {
+		return fmt.Sprintf("%ds", num)
+	}
+	return fmt.Sprintf("%d/%ds", num, den)
+}
+
+func gcd(a, b int64) int64 {
+	if a < 0 {
+		a = -a
+	}
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,350 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"video-editor/models"
+)
+
+// Interchange formats a timeline can be written in
+const (
+	FormatEDL    = "edl"
+	FormatFCPXML = "fcpxml"
+	FormatOTIO   = "otio"
+)
+
+// ErrUnknownInterchangeFormat is returned for a format other than the Format* ones
+var ErrUnknownInterchangeFormat = errors.New("format must be edl, fcpxml or otio")
+
+// FrameRate is the rate timecodes are counted in. NTSC rates count nominal
+// frames of 30 or 60 per second, dropping frame numbers if DropFrame is set
+// to keep timecode in step with the clock.
+type FrameRate struct {
+	Num       int64
+	Den       int64
+	DropFrame bool
+}
+
+// frameRates are the rates accepted by ParseFrameRate
+var frameRates = map[string]FrameRate{
+	"23.976": {24000, 1001, false},
+	"24":     {24, 1, false},
+	"25":     {25, 1, false},
+	"29.97":  {30000, 1001, true},
+	"30":     {30, 1, false},
+	"50":     {50, 1, false},
+	"59.94":  {60000, 1001, true},
+	"60":     {60, 1, false},
+}
+
+// DefaultFrameRate is used when no rate is asked for
+var DefaultFrameRate = frameRates["30"]
+
+// ParseFrameRate returns a rate by its usual name, e.g. "29.97". A "ndf"
+// suffix counts an NTSC rate without dropping frames, e.g. "29.97ndf".
+func ParseFrameRate(name string) (FrameRate, error) {
+	if name == "" {
+		return DefaultFrameRate, nil
+	}
+	nonDrop := strings.HasSuffix(name, "ndf")
+	rate, ok := frameRates[strings.TrimSuffix(name, "ndf")]
+	if !ok {
+		return FrameRate{}, fmt.Errorf("unsupported frame rate %q", name)
+	}
+	if nonDrop {
+		rate.DropFrame = false
+	}
+	return rate, nil
+}
+
+// FPS returns the rate in frames per second
+func (r FrameRate) FPS() float64 {
+	return float64(r.Num) / float64(r.Den)
+}
+
+// Nominal returns the whole number of frames timecode counts per second
+func (r FrameRate) Nominal() int64 {
+	return int64(math.Round(r.FPS()))
+}
+
+// Frames converts seconds to the nearest frame
+func (r FrameRate) Frames(seconds float64) int64 {
+	return int64(math.Round(seconds * r.FPS()))
+}
+
+// Seconds converts a frame count to seconds, rounded to the millisecond
+func (r FrameRate) Seconds(frames int64) float64 {
+	return roundMillis(float64(frames) / r.FPS())
+}
+
+// dropPerMinute is how many frame numbers drop-frame timecode skips at the
+// start of every minute but each tenth
+func (r FrameRate) dropPerMinute() int64 {
+	if !r.DropFrame {
+		return 0
+	}
+	return r.Nominal() / 15
+}
+
+// Timecode formats a frame count as HH:MM:SS:FF, with a ";" before the
+// frames for drop-frame timecode
+func (r FrameRate) Timecode(frames int64) string {
+	nominal, drop := r.Nominal(), r.dropPerMinute()
+	sign := ""
+	if frames < 0 {
+		sign, frames = "-", -frames
+	}
+	if drop > 0 {
+		perMinute := nominal*60 - drop
+		perTenMinutes := perMinute*10 + drop
+		tens, rest := frames/perTenMinutes, frames%perTenMinutes
+		frames += drop * 9 * tens
+		if rest > drop {
+			frames += drop * ((rest - drop) / perMinute)
+		}
+	}
+	separator := ":"
+	if drop > 0 {
+		separator = ";"
+	}
+	ff := frames % nominal
+	seconds := frames / nominal
+	return fmt.Sprintf("%s%02d:%02d:%02d%s%02d", sign, seconds/3600%24, seconds/60%60, seconds%60, separator, ff)
+}
+
+// ParseTimecode converts HH:MM:SS:FF to a frame count. The separator before
+// the frames may be ":", ";", "." or ",", as written by different editors;
+// the rate decides whether frames are dropped.
+func (r FrameRate) ParseTimecode(tc string) (int64, error) {
+	fields := strings.FieldsFunc(strings.TrimSpace(tc), func(c rune) bool {
+		return c == ':' || c == ';' || c == '.' || c == ','
+	})
+	if len(fields) != 4 {
+		return 0, fmt.Errorf("invalid timecode %q", tc)
+	}
+	var parts [4]int64
+	for i, field := range fields {
+		n, err := strconv.ParseInt(field, 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid timecode %q", tc)
+		}
+		parts[i] = n
+	}
+	hh, mm, ss, ff := parts[0], parts[1], parts[2], parts[3]
+	nominal := r.Nominal()
+	if mm > 59 || ss > 59 || ff >= nominal {
+		return 0, fmt.Errorf("invalid timecode %q", tc)
+	}
+	minutes := hh*60 + mm
+	frames := (minutes*60+ss)*nominal + ff
+	return frames - r.dropPerMinute()*(minutes-minutes/10), nil
+}
+
+// InterchangeMedia describes a file clips play, for formats that name it
+type InterchangeMedia struct {
+	Name     string  // Filename
+	Type     string  // "video", "audio" or "image"
+	Duration float64 // In seconds, 0 if unknown
+	HasAudio bool
+}
+
+// InterchangeProject is a timeline with what its writers need to know about
+// its media, keyed by the clips' asset IDs
+type InterchangeProject struct {
+	Name     string
+	Timeline *models.Timeline
+	Rate     FrameRate
+	Width    int
+	Height   int
+	Media    map[string]InterchangeMedia
+}
+
+// interchangeClip is a clip placed on whole frames
+type interchangeClip struct {
+	item     models.TimelineItem
+	media    InterchangeMedia
+	start    int64 // On the timeline
+	duration int64
+	in       int64 // Into the source
+	audio    bool  // The audio of a video clip, on an audio track
+}
+
+func (c interchangeClip) end() int64 { return c.start + c.duration }
+func (c interchangeClip) out() int64 { return c.in + c.duration }
+
+// interchangeTrack is a lane of clips that don't overlap, in timeline order
+type interchangeTrack struct {
+	kind  string // "video" or "audio"
+	track int    // Track of the clips in the editor
+	clips []interchangeClip
+}
+
+// interchangeTransition is a transition between two neighbouring clips of a
+// track, centered on the cut
+type interchangeTransition struct {
+	transition models.Transition
+	before     int64 // Frames before the cut, played by the incoming clip's handle
+	after      int64 // Frames after it, played by the outgoing clip's handle
+}
+
+func (t interchangeTransition) duration() int64 { return t.before + t.after }
+
+// tracks lays the project's clips out on tracks of a kind, lowest editor
+// track first. Video tracks hold video, image and text clips; audio tracks
+// hold audio clips and the sound of unmuted video clips. Clips that overlap
+// on one editor track are spread over more tracks, since the formats can't
+// stack clips within a track.
+func (p *InterchangeProject) tracks(kind string) []interchangeTrack {
+	byTrack := make(map[int][]interchangeClip)
+	for _, item := range p.Timeline.MediaItems {
+		audio := item.Type == "audio" || (item.Type == "video" && !item.IsMuted && p.Media[item.AssetID].HasAudio)
+		if (kind == "video" && item.Type == "audio") || (kind == "audio" && !audio) {
+			continue
+		}
+		_, seconds := clipSpan(item)
+		clip := interchangeClip{
+			item:     item,
+			media:    p.Media[item.AssetID],
+			start:    p.Rate.Frames(item.StartTime),
+			duration: p.Rate.Frames(seconds),
+			in:       p.Rate.Frames(item.SourceStart),
+			audio:    kind == "audio" && item.Type == "video",
+		}
+		if clip.duration <= 0 {
+			continue
+		}
+		byTrack[item.Track] = append(byTrack[item.Track], clip)
+	}
+
+	numbers := make([]int, 0, len(byTrack))
+	for n := range byTrack {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	var tracks []interchangeTrack
+	for _, n := range numbers {
+		clips := byTrack[n]
+		sort.SliceStable(clips, func(i, j int) bool { return clips[i].start < clips[j].start })
+		first := len(tracks)
+		for _, clip := range clips {
+			placed := false
+			for i := first; i < len(tracks) && !placed; i++ {
+				last := tracks[i].clips[len(tracks[i].clips)-1]
+				if clip.start >= last.end() {
+					tracks[i].clips = append(tracks[i].clips, clip)
+					placed = true
+				}
+			}
+			if !placed {
+				tracks = append(tracks, interchangeTrack{kind: kind, track: n, clips: []interchangeClip{clip}})
+			}
+		}
+	}
+	return tracks
+}
+
+// transitions returns the transitions that can be written on a track, keyed
+// by the ID of the clip they lead into. A transition needs its clips to
+// meet on the track, and enough media on either side of the cut: before the
+// incoming clip's in point and, if the source's length is known, after the
+// outgoing clip's out point.
+func (p *InterchangeProject) transitions(track interchangeTrack) map[string]interchangeTransition {
+	byClips := make(map[[2]string]models.Transition, len(p.Timeline.Transitions))
+	for _, t := range p.Timeline.Transitions {
+		byClips[[2]string{t.FromClipID, t.ToClipID}] = t
+	}
+	found := make(map[string]interchangeTransition)
+	for i := 1; i < len(track.clips); i++ {
+		from, to := track.clips[i-1], track.clips[i]
+		t, ok := byClips[[2]string{from.item.ID, to.item.ID}]
+		if !ok || from.end() != to.start {
+			continue
+		}
+		frames := p.Rate.Frames(t.Duration)
+		if frames < 1 {
+			frames = 1
+		}
+		mapped := interchangeTransition{transition: t, before: frames / 2, after: frames - frames/2}
+		if mapped.before > to.in || mapped.before > from.duration || mapped.after > to.duration {
+			continue
+		}
+		if from.item.Type != "image" && from.media.Duration > 0 && from.out()+mapped.after > p.Rate.Frames(from.media.Duration) {
+			continue
+		}
+		// The outgoing clip's own incoming transition must end before this one starts
+		if prev, ok := found[from.item.ID]; ok && from.start+prev.after > from.end()-mapped.before {
+			continue
+		}
+		found[to.item.ID] = mapped
+	}
+	return found
+}
+
+// WriteInterchange writes the project in one of the Format* formats
+func WriteInterchange(w io.Writer, format string, p *InterchangeProject) error {
+	switch format {
+	case FormatEDL:
+		return WriteEDL(w, p)
+	case FormatFCPXML:
+		return WriteFCPXML(w, p)
+	case FormatOTIO:
+		return WriteOTIO(w, p)
+	}
+	return ErrUnknownInterchangeFormat
+}
+
+// InterchangeProject gathers a project's timeline and the media its clips
+// play for writing at a frame rate. The frame size follows the timeline's
+// aspect ratio with the short side at 1080 pixels.
+func (s *AssetService) InterchangeProject(project *models.Project, rate FrameRate) (*InterchangeProject, error) {
+	timeline := cloneTimeline(project.Timeline)
+	media := make(map[string]InterchangeMedia)
+	for i := range timeline.MediaItems {
+		clip := &timeline.MediaItems[i]
+		if clip.Type == "text" || (clip.AssetID == "" && clip.URL == "") {
+			continue
+		}
+		asset, err := s.ClipAsset(clip, project.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("clip %s: %v", clip.ID, err)
+		}
+		clip.AssetID, clip.URL = asset.ID.Hex(), ""
+		m := InterchangeMedia{Name: asset.Filename, Type: asset.Type, HasAudio: asset.Type == "audio"}
+		if asset.Metadata != nil {
+			m.Duration = asset.Metadata.Duration
+			m.HasAudio = m.HasAudio || asset.Metadata.SampleRate > 0 || asset.Metadata.Channels > 0
+		}
+		media[clip.AssetID] = m
+	}
+	width, height := frameSize(timeline.AspectRatio)
+	return &InterchangeProject{
+		Name:     project.Name,
+		Timeline: timeline,
+		Rate:     rate,
+		Width:    width,
+		Height:   height,
+		Media:    media,
+	}, nil
+}
+
+// frameSize returns the frame size for an aspect ratio such as "16:9", with
+// the short side at 1080 pixels. Unknown ratios are 1920x1080.
+func frameSize(aspectRatio string) (int, int) {
+	w, h, ok := strings.Cut(aspectRatio, ":")
+	aw, errW := strconv.ParseFloat(w, 64)
+	ah, errH := strconv.ParseFloat(h, 64)
+	if !ok || errW != nil || errH != nil || aw <= 0 || ah <= 0 {
+		return 1920, 1080
+	}
+	even := func(v float64) int { return int(math.Round(v/2)) * 2 }
+	if aw >= ah {
+		return even(1080 * aw / ah), 1080
+	}
+	return 1080, even(1080 * ah / aw)
+}
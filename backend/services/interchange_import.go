@@ -0,0 +1,355 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strings"
+
+	"video-editor/models"
+)
+
+// maxInterchangeClips caps how many clips an imported timeline can hold
+const maxInterchangeClips = 5000
+
+// ErrInvalidInterchange is returned for a timeline file that can't be read
+var ErrInvalidInterchange = errors.New("invalid timeline file")
+
+// ErrUnknownImportFormat is returned for an import format other than EDL and OTIO
+var ErrUnknownImportFormat = errors.New("format must be edl or otio")
+
+func invalidInterchange(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidInterchange, fmt.Sprintf(format, args...))
+}
+
+// InterchangeImport is a project created from a timeline file, with what
+// couldn't be brought over
+type InterchangeImport struct {
+	Project    *models.Project   `json:"project"`
+	Unresolved []UnresolvedMedia `json:"unresolved"` // Media not found among the user's assets
+	Warnings   []string          `json:"warnings"`   // Edits that were simplified or dropped
+}
+
+// UnresolvedMedia is a file named by the timeline that none of the user's
+// assets match. Its clips are left out of the project.
+type UnresolvedMedia struct {
+	Name  string   `json:"name"`
+	Clips []string `json:"clips"` // Where the clips are in the file, e.g. "event 012"
+}
+
+// importedClip is a clip read from a timeline file, before its media are
+// matched to assets
+type importedClip struct {
+	item      models.TimelineItem
+	ref       string         // Where the clip is in the file, for reports
+	names     []importedName // Names the file gives the media, best first
+	assetID   string         // Asset ID written by this editor's own export
+	withAudio bool           // A video clip whose sound the file plays too
+	// Rate of the source timecode SourceStart was read from, which counts
+	// from the media's start timecode; zero if it counts from the file's start
+	sourceRate FrameRate
+}
+
+// importedName is a filename or, from an EDL, a reel name
+type importedName struct {
+	value string
+	reel  bool
+}
+
+// importedTimeline is what a parser read from a timeline file. Transitions
+// join clips by their item IDs.
+type importedTimeline struct {
+	name        string
+	aspectRatio string
+	clips       []importedClip
+	transitions []models.Transition
+	hasAudio    bool // Whether the file has audio tracks, so a video clip without sound there was muted
+	warnings    []string
+}
+
+func (t *importedTimeline) warn(format string, args ...interface{}) {
+	t.warnings = append(t.warnings, fmt.Sprintf(format, args...))
+}
+
+// addClip gives a clip the next clip ID and adds it, returning its index
+func (t *importedTimeline) addClip(clip importedClip) (int, error) {
+	if len(t.clips) >= maxInterchangeClips {
+		return 0, invalidInterchange("a timeline can have at most %d clips", maxInterchangeClips)
+	}
+	clip.item.ID = fmt.Sprintf("clip-%d", len(t.clips)+1)
+	t.clips = append(t.clips, clip)
+	return len(t.clips) - 1, nil
+}
+
+// addTransition joins two clips that meet, given by index, from the clip
+// playing before
+func (t *importedTimeline) addTransition(transitionType string, from, to int, duration float64) {
+	t.transitions = append(t.transitions, models.Transition{
+		ID:         fmt.Sprintf("transition-%d", len(t.transitions)+1),
+		Type:       transitionType,
+		FromClipID: t.clips[from].item.ID,
+		ToClipID:   t.clips[to].item.ID,
+		Duration:   roundMillis(duration),
+	})
+}
+
+// InterchangeService creates projects from timelines made in other editors
+type InterchangeService struct {
+	projects *ProjectService
+	assets   *AssetService
+}
+
+// NewInterchangeService creates a new InterchangeService
+func NewInterchangeService(projects *ProjectService, assets *AssetService) *InterchangeService {
+	return &InterchangeService{projects: projects, assets: assets}
+}
+
+// ImportTimeline creates a project for the user from an EDL or OTIO file.
+// rate is the frame rate an EDL's timecodes count; OTIO files carry their
+// own. Media are matched to the user's assets by filename, or by reel name
+// for EDLs without clip names. Clips whose media aren't found are left out
+// and reported. name overrides the file's title.
+func (s *InterchangeService) ImportTimeline(userID, format string, data []byte, rate FrameRate, name string) (*InterchangeImport, error) {
+	var imported *importedTimeline
+	var err error
+	switch format {
+	case FormatEDL:
+		imported, err = parseEDL(data, rate)
+	case FormatOTIO:
+		imported, err = parseOTIO(data)
+	default:
+		return nil, ErrUnknownImportFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	assets, err := s.assets.ListAssetNames(userID)
+	if err != nil {
+		return nil, err
+	}
+	result := &InterchangeImport{Unresolved: []UnresolvedMedia{}}
+	timeline := resolveImported(imported, newAssetMatcher(assets), result)
+	if err := ValidateTimeline(timeline); err != nil {
+		return nil, invalidInterchange("%v", err)
+	}
+	result.Warnings = append([]string{}, imported.warnings...)
+
+	if name = strings.TrimSpace(name); name == "" {
+		name = imported.name
+	}
+	if name == "" {
+		name = "Imported timeline"
+	}
+	project := &models.Project{
+		UserID:   userID,
+		Name:     name,
+		Edits:    []models.EditOperation{},
+		Timeline: timeline,
+	}
+	if err := s.projects.CreateProject(project); err != nil {
+		return nil, err
+	}
+	result.Project = project
+	return result, nil
+}
+
+// resolveImported points the clips at the assets they play and builds the
+// timeline. Sound the file lays under a video clip from the same file,
+// in step with it, becomes that clip's own sound; other video clips are
+// muted if the file has audio tracks at all.
+func resolveImported(imported *importedTimeline, matcher *assetMatcher, result *InterchangeImport) *models.Timeline {
+	unresolved := make(map[string]int) // Index in result.Unresolved by name
+	kept := make(map[string]bool)
+	var clips []models.TimelineItem
+	withAudio := make(map[string]bool)
+	for _, clip := range imported.clips {
+		item := clip.item
+		if item.Type != "text" {
+			asset := matcher.match(clip)
+			if asset == nil {
+				name := clip.item.Name
+				if len(clip.names) > 0 {
+					name = clip.names[0].value
+				}
+				i, ok := unresolved[name]
+				if !ok {
+					i = len(result.Unresolved)
+					unresolved[name] = i
+					result.Unresolved = append(result.Unresolved, UnresolvedMedia{Name: name})
+				}
+				result.Unresolved[i].Clips = append(result.Unresolved[i].Clips, clip.ref)
+				continue
+			}
+			item.AssetID = asset.ID.Hex()
+			switch {
+			case item.Type == "audio" || asset.Type == "audio":
+				item.Type = "audio"
+			case asset.Type == "image":
+				item.Type, item.SourceStart = "image", 0
+			default:
+				item.Type = "video"
+			}
+			if item.Type != "image" && clip.sourceRate.Num != 0 {
+				item.SourceStart = imported.mediaSourceStart(clip, asset)
+			}
+		}
+		kept[item.ID] = true
+		withAudio[item.ID] = clip.withAudio
+		clips = append(clips, item)
+	}
+
+	// Fold sound in step with a video clip of the same file into that clip
+	var merged []models.TimelineItem
+	for _, item := range clips {
+		if item.Type == "audio" {
+			if video := syncedVideo(clips, item); video != "" && !withAudio[video] {
+				withAudio[video] = true
+				delete(kept, item.ID)
+				continue
+			}
+		}
+		merged = append(merged, item)
+	}
+	timeline := &models.Timeline{MediaItems: []models.TimelineItem{}, AspectRatio: imported.aspectRatio}
+	if timeline.AspectRatio == "" {
+		timeline.AspectRatio = "16:9"
+	}
+	for _, item := range merged {
+		if item.Type == "video" && imported.hasAudio && !withAudio[item.ID] {
+			item.IsMuted = true
+		}
+		timeline.MediaItems = append(timeline.MediaItems, item)
+		timeline.Duration = math.Max(timeline.Duration, item.EndTime)
+	}
+	for _, t := range imported.transitions {
+		if kept[t.FromClipID] && kept[t.ToClipID] {
+			timeline.Transitions = append(timeline.Transitions, t)
+		}
+	}
+	return timeline
+}
+
+// mediaSourceStart is where a clip read from source timecode starts in its
+// media. Cameras and editors stamp media with the timecode of its first
+// frame, often 01:00:00:00, which is taken off. An in point before that
+// timecode already counts from the start of the file, as this editor's own
+// EDLs do. One still past the end of the media is reported, as the file
+// likely counts from a start timecode the media doesn't carry.
+func (t *importedTimeline) mediaSourceStart(clip importedClip, asset *models.MediaAsset) float64 {
+	rate, sourceStart := clip.sourceRate, clip.item.SourceStart
+	if asset.Metadata == nil {
+		return sourceStart
+	}
+	if frames, err := rate.ParseTimecode(asset.Metadata.StartTimecode); err == nil {
+		if start := rate.Seconds(frames); sourceStart >= start {
+			sourceStart = roundMillis(sourceStart - start)
+		}
+	}
+	if duration := asset.Metadata.Duration; duration > 0 && sourceStart >= duration {
+		t.warn("%s: source in point %s is past the end of %s", clip.ref, rate.Timecode(rate.Frames(clip.item.SourceStart)), asset.Filename)
+	}
+	return sourceStart
+}
+
+// syncedVideo returns the ID of the video clip playing the same part of the
+// same file at the same time as an audio clip, if any
+func syncedVideo(clips []models.TimelineItem, audio models.TimelineItem) string {
+	same := func(a, b float64) bool { return math.Abs(a-b) < 0.002 }
+	for _, item := range clips {
+		if item.Type == "video" && item.AssetID == audio.AssetID && same(item.StartTime, audio.StartTime) &&
+			same(item.EndTime, audio.EndTime) && same(item.SourceStart, audio.SourceStart) {
+			return item.ID
+		}
+	}
+	return ""
+}
+
+// importedItem places a clip on the timeline, times in seconds
+func importedItem(clipType, name string, track int, start, end, sourceStart float64) models.TimelineItem {
+	return models.TimelineItem{
+		Type:        clipType,
+		Name:        name,
+		Track:       track,
+		StartTime:   roundMillis(start),
+		EndTime:     roundMillis(end),
+		Duration:    roundMillis(end - start),
+		SourceStart: roundMillis(sourceStart),
+	}
+}
+
+// assetMatcher finds the user's assets by the names a timeline file gives
+// media. Where several assets share a name, the newest wins.
+type assetMatcher struct {
+	byID   map[string]*models.MediaAsset
+	byName map[string]*models.MediaAsset // Lowercased filename
+	byStem map[string]*models.MediaAsset // Lowercased filename without extension
+	byReel map[string]*models.MediaAsset // Reel name this editor's EDLs give the file; nil if several share it
+}
+
+// newAssetMatcher indexes assets given newest first
+func newAssetMatcher(assets []models.MediaAsset) *assetMatcher {
+	m := &assetMatcher{
+		byID:   make(map[string]*models.MediaAsset, len(assets)),
+		byName: make(map[string]*models.MediaAsset, len(assets)),
+		byStem: make(map[string]*models.MediaAsset, len(assets)),
+		byReel: make(map[string]*models.MediaAsset, len(assets)),
+	}
+	for i := range assets {
+		asset := &assets[i]
+		m.byID[asset.ID.Hex()] = asset
+		name := strings.ToLower(asset.Filename)
+		stem := strings.TrimSuffix(name, path.Ext(name))
+		if _, ok := m.byName[name]; !ok {
+			m.byName[name] = asset
+		}
+		if _, ok := m.byStem[stem]; !ok {
+			m.byStem[stem] = asset
+		}
+		reel := reelName(asset.Filename)
+		if other, ok := m.byReel[reel]; ok && other != nil && other.Filename != asset.Filename {
+			m.byReel[reel] = nil
+		} else if !ok {
+			m.byReel[reel] = asset
+		}
+	}
+	return m
+}
+
+// match returns the asset a clip plays, or nil. An asset ID from this
+// editor's own export wins, then the clip's names in order: by filename,
+// by filename without extension, then as a reel name.
+func (m *assetMatcher) match(clip importedClip) *models.MediaAsset {
+	if asset, ok := m.byID[clip.assetID]; ok {
+		return asset
+	}
+	for _, name := range clip.names {
+		value := strings.ToLower(mediaBaseName(name.value))
+		if value == "" {
+			continue
+		}
+		if asset, ok := m.byName[value]; ok {
+			return asset
+		}
+		if asset, ok := m.byStem[strings.TrimSuffix(value, path.Ext(value))]; ok {
+			return asset
+		}
+		if name.reel {
+			if asset := m.byReel[strings.ToUpper(name.value)]; asset != nil {
+				return asset
+			}
+		}
+	}
+	return nil
+}
+
+// mediaBaseName is the last element of a path or URL to a file, which may
+// be written with Windows separators
+func mediaBaseName(name string) string {
+	name = strings.TrimSpace(strings.ReplaceAll(name, "\\", "/"))
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"video-editor/models"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// interchangeProject is a two-minute cut over three video tracks and an
+// audio track. Two transitions fit on the main track; the one between the
+// stills has no handle and the one into b1 has no cut, so both are left
+// out. The cut at 60s and the title at 70s land on timecodes that
+// drop-frame counting shifts.
+func interchangeProject(rate FrameRate) *InterchangeProject {
+	return &InterchangeProject{
+		Name:   "Golden",
+		Rate:   rate,
+		Width:  1920,
+		Height: 1080,
+		Media: map[string]InterchangeMedia{
+			"interview": {Name: "interview.mp4", Type: "video", Duration: 600, HasAudio: true},
+			"broll":     {Name: "b-roll.mov", Type: "video", Duration: 90},
+			"logo":      {Name: "logo.png", Type: "image"},
+			"music":     {Name: "music.wav", Type: "audio", Duration: 180, HasAudio: true},
+		},
+		Timeline: &models.Timeline{
+			AspectRatio: "16:9",
+			Duration:    120,
+			MediaItems: []models.TimelineItem{
+				{ID: "v1", Type: "video", Name: "Intro", AssetID: "interview", Track: 0, StartTime: 0, EndTime: 30, Duration: 30, SourceStart: 12},
+				{ID: "v2", Type: "video", AssetID: "broll", Track: 0, StartTime: 30, EndTime: 60, Duration: 30, SourceStart: 5},
+				{ID: "v3", Type: "video", AssetID: "interview", Track: 0, StartTime: 60, EndTime: 120, Duration: 60, SourceStart: 95.5},
+				{ID: "i1", Type: "image", AssetID: "logo", Track: 1, StartTime: 2, EndTime: 8, Duration: 6},
+				{ID: "i2", Type: "image", AssetID: "logo", Track: 1, StartTime: 8, EndTime: 14, Duration: 6},
+				// Overlaps i2 on its track, so it is spread onto another
+				{ID: "b1", Type: "video", AssetID: "broll", Track: 1, StartTime: 10, EndTime: 20, Duration: 10, IsMuted: true},
+				{ID: "t1", Type: "text", Text: "Chapter two", Track: 2, StartTime: 70, EndTime: 75, Duration: 5},
+				{ID: "a1", Type: "audio", Name: "Score", AssetID: "music", Track: 3, StartTime: 0, EndTime: 120, Duration: 120, SourceStart: 30},
+			},
+			Transitions: []models.Transition{
+				{ID: "v1-to-v2", Type: "fade", FromClipID: "v1", ToClipID: "v2", Duration: 1},
+				{ID: "v2-to-v3", Type: "wipeleft", FromClipID: "v2", ToClipID: "v3", Duration: 0.5},
+				{ID: "i1-to-i2", Type: "dissolve", FromClipID: "i1", ToClipID: "i2", Duration: 2},
+				{ID: "i2-to-b1", Type: "fade", FromClipID: "i2", ToClipID: "b1", Duration: 1},
+			},
+		},
+	}
+}
+
+func TestWriteInterchangeGolden(t *testing.T) {
+	rates := []string{"25", "29.97", "29.97ndf", "23.976"}
+	for _, format := range []string{FormatEDL, FormatFCPXML, FormatOTIO} {
+		for _, name := range rates {
+			rate, err := ParseFrameRate(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", "golden_"+name+"."+format)
+			t.Run(golden, func(t *testing.T) {
+				var buf bytes.Buffer
+				if err := WriteInterchange(&buf, format, interchangeProject(rate)); err != nil {
+					t.Fatal(err)
+				}
+				if *update {
+					if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("%v (run with -update to create it)", err)
+				}
+				if !bytes.Equal(buf.Bytes(), want) {
+					t.Errorf("output differs from %s (run with -update to accept it)\n%s", golden, buf.Bytes())
+				}
+			})
+		}
+	}
+}
+
+func TestDropFrameTimecode(t *testing.T) {
+	df, _ := ParseFrameRate("29.97")
+	ndf, _ := ParseFrameRate("29.97ndf")
+	tests := []struct {
+		frames  int64
+		df, ndf string
+	}{
+		{0, "00:00:00;00", "00:00:00:00"},
+		{1799, "00:00:59;29", "00:00:59:29"},
+		{1800, "00:01:00;02", "00:01:00:00"},  // Frames 00 and 01 are dropped
+		{17982, "00:10:00;00", "00:09:59:12"}, // But not every tenth minute
+		{107892, "01:00:00;00", "00:59:56:12"},
+	}
+	for _, tt := range tests {
+		if got := df.Timecode(tt.frames); got != tt.df {
+			t.Errorf("drop-frame %d: got %s, want %s", tt.frames, got, tt.df)
+		}
+		if got := ndf.Timecode(tt.frames); got != tt.ndf {
+			t.Errorf("non-drop %d: got %s, want %s", tt.frames, got, tt.ndf)
+		}
+		if back, err := df.ParseTimecode(tt.df); err != nil || back != tt.frames {
+			t.Errorf("parse %s: got %d, %v, want %d", tt.df, back, err, tt.frames)
+		}
+	}
+}
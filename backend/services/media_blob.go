@@ -0,0 +1,178 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"video-editor/db"
+	"video-editor/models"
+	"video-editor/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxBlobAttempts bounds how often storeBlob retries when a concurrent
+// upload or release of the same content gets in first
+const maxBlobAttempts = 5
+
+// EnsureBlobIndexes creates the unique index that keeps one blob per user
+// and digest. It is called once at startup.
+func (s *AssetService) EnsureBlobIndexes() error {
+	_, err := s.blobsCollection.Indexes().CreateOne(db.Ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "sha256", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("user_id_sha256"),
+	})
+	return err
+}
+
+// storeBlob moves a validated upload into the user's content-addressed store.
+// If the user already has a blob with the same digest, the new file is
+// discarded and the existing blob's reference count is incremented instead.
+// Concurrent uploads of the same content race on the unique index; the loser
+// references the winner's blob and removes its own object.
+func (s *AssetService) storeBlob(userID, tempPath, digest, filename string, size int64) (*models.MediaBlob, error) {
+	var created *models.MediaBlob // Blob whose object is stored but not yet recorded
+	for attempt := 0; attempt < maxBlobAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 10 * time.Millisecond)
+		}
+
+		blob, err := s.referenceBlob(userID, digest)
+		if err == nil {
+			if created != nil {
+				s.removeObject(created.StorageKey)
+			} else {
+				os.Remove(tempPath)
+			}
+			log.Printf("Deduplicated upload %s for user %s (%d references)", digest, userID, blob.RefCount)
+			return blob, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+
+		// Store the file first so a blob record never points at a missing
+		// object. The key is unique to the blob, so releasing an earlier
+		// blob of the same content never removes this one's object.
+		if created == nil {
+			now := time.Now()
+			created = &models.MediaBlob{
+				ID:        primitive.NewObjectID(),
+				UserID:    userID,
+				SHA256:    digest,
+				Size:      size,
+				RefCount:  1,
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
+			created.StorageKey = blobKey(userID, digest, created.ID, filename)
+			if err := storage.PutFile(s.store, created.StorageKey, tempPath); err != nil {
+				return nil, fmt.Errorf("failed to store upload: %v", err)
+			}
+		}
+		_, err = s.blobsCollection.InsertOne(db.Ctx, created)
+		if err == nil {
+			return created, nil
+		}
+		// Another upload recorded the content first, or a release of it is
+		// still under way: reference it, or try again once it is gone
+		if !mongo.IsDuplicateKeyError(err) {
+			s.removeObject(created.StorageKey)
+			return nil, err
+		}
+	}
+	if created != nil {
+		s.removeObject(created.StorageKey)
+	}
+	return nil, fmt.Errorf("failed to store upload %s: too many concurrent changes to the same content", digest)
+}
+
+// referenceBlob adds a reference to the user's blob with a digest. Blobs no
+// longer referenced are being released and count as missing.
+func (s *AssetService) referenceBlob(userID, digest string) (*models.MediaBlob, error) {
+	filter := bson.M{"user_id": userID, "sha256": digest, "ref_count": bson.M{"$gt": 0}}
+	update := bson.M{
+		"$inc": bson.M{"ref_count": 1},
+		"$set": bson.M{"updated_at": time.Now()},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	blob := &models.MediaBlob{}
+	if err := s.blobsCollection.FindOneAndUpdate(db.Ctx, filter, update, opts).Decode(blob); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// blobKey returns the storage key of a new blob, keeping a short, safe
+// extension from the upload's filename
+func blobKey(userID, digest string, id primitive.ObjectID, filename string) string {
+	key := userID + "/" + digest + "-" + id.Hex()
+	ext := unsafeExtChars.ReplaceAllString(strings.ToLower(filepath.Ext(filename)), "")
+	if ext != "" && len(ext) <= 8 {
+		key += "." + ext
+	}
+	return key
+}
+
+// releaseBlob drops one reference to a blob, deleting it and its object when
+// no assets use it anymore.
+func (s *AssetService) releaseBlob(blobID primitive.ObjectID) error {
+	update := bson.M{
+		"$inc": bson.M{"ref_count": -1},
+		"$set": bson.M{"updated_at": time.Now()},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	blob := &models.MediaBlob{}
+	if err := s.blobsCollection.FindOneAndUpdate(db.Ctx, bson.M{"_id": blobID}, update, opts).Decode(blob); err != nil {
+		return err
+	}
+	if blob.RefCount > 0 {
+		return nil
+	}
+
+	// The object goes only if this call deletes the unreferenced record, so
+	// it is removed exactly once and never while the record is in use
+	result, err := s.blobsCollection.DeleteOne(db.Ctx, bson.M{"_id": blobID, "ref_count": 0})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 1 {
+		s.removeObject(blob.StorageKey)
+	}
+	return nil
+}
+
+// removeObject deletes a blob's object, logging rather than failing since
+// the record it belonged to is already gone
+func (s *AssetService) removeObject(key string) {
+	if err := s.store.Delete(key); err != nil {
+		log.Printf("Warning: failed to remove blob %s: %v", key, err)
+	}
+}
+
+// HashFile returns the hex SHA-256 digest of a file
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"video-editor/db"
+	"video-editor/models"
+	"video-editor/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// testDatabase connects to a fresh database that is dropped after the test,
+// skipping without MONGODB_TEST_URI
+func testDatabase(t *testing.T) (*mongo.Client, string) {
+	t.Helper()
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+	client, err := db.ConnectDB(uri)
+	if err != nil {
+		t.Fatalf("connect to MongoDB: %v", err)
+	}
+	name := fmt.Sprintf("video_editor_test_%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		client.Database(name).Drop(db.Ctx)
+		client.Disconnect(db.Ctx)
+	})
+	return client, name
+}
+
+// testStore returns local storage in a temporary directory, with its root
+func testStore(t *testing.T) (storage.Backend, string) {
+	t.Helper()
+	root := t.TempDir()
+	store, err := storage.NewLocal(root, storage.NewURLSigner("secret", "/media"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, root
+}
+
+// testAssetService returns an AssetService on a fresh database and local
+// store, skipping without MONGODB_TEST_URI
+func testAssetService(t *testing.T) (*AssetService, string) {
+	t.Helper()
+	client, name := testDatabase(t)
+	store, root := testStore(t)
+	s := NewAssetService(client, name, store, &UploadPolicy{}, nil)
+	if err := s.EnsureBlobIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	return s, root
+}
+
+func TestStoreBlobConcurrent(t *testing.T) {
+	s, root := testAssetService(t)
+	const uploads = 8
+	digest := "0f343b0931126a20f133d67c2b018a3b5d5d1e5a7e5f2b6b8d1f6f3b9c6a1e2d"
+
+	// Every upload of the same content ends up referencing one blob
+	blobs := make([]*models.MediaBlob, uploads)
+	var wg sync.WaitGroup
+	for i := range blobs {
+		temp := filepath.Join(t.TempDir(), "upload")
+		if err := os.WriteFile(temp, []byte("same content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int, temp string) {
+			defer wg.Done()
+			blob, err := s.storeBlob("alice", temp, digest, fmt.Sprintf("clip%d.mp4", i), 12)
+			if err != nil {
+				t.Errorf("upload %d: %v", i, err)
+				return
+			}
+			blobs[i] = blob
+		}(i, temp)
+	}
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+	for _, blob := range blobs {
+		if blob.ID != blobs[0].ID || blob.StorageKey != blobs[0].StorageKey {
+			t.Fatalf("got blobs %s and %s for the same content", blobs[0].ID.Hex(), blob.ID.Hex())
+		}
+	}
+	stored := &models.MediaBlob{}
+	if err := s.blobsCollection.FindOne(db.Ctx, bson.M{"_id": blobs[0].ID}).Decode(stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.RefCount != uploads {
+		t.Errorf("ref count %d, want %d", stored.RefCount, uploads)
+	}
+	objects, err := s.store.List("alice/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != stored.StorageKey {
+		t.Errorf("stored objects %+v, want only %s", objects, stored.StorageKey)
+	}
+
+	// The object goes with the last reference, and only then
+	for i := 0; i < uploads; i++ {
+		if _, err := os.Stat(filepath.Join(root, stored.StorageKey)); err != nil {
+			t.Fatalf("object removed with %d references left: %v", uploads-i, err)
+		}
+		if err := s.releaseBlob(stored.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, stored.StorageKey)); !os.IsNotExist(err) {
+		t.Errorf("object kept after the last release: %v", err)
+	}
+	if n, _ := s.blobsCollection.CountDocuments(db.Ctx, bson.M{"sha256": digest}); n != 0 {
+		t.Errorf("%d blob records left", n)
+	}
+
+	// Uploading the content again stores it anew under another key
+	temp := filepath.Join(t.TempDir(), "upload")
+	os.WriteFile(temp, []byte("same content"), 0644)
+	again, err := s.storeBlob("alice", temp, digest, "clip.mp4", 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.RefCount != 1 || again.StorageKey == stored.StorageKey {
+		t.Errorf("re-upload: %+v", again)
+	}
+}
+
+func TestAssetReferences(t *testing.T) {
+	s, _ := testAssetService(t)
+	asset := &models.MediaAsset{ID: primitive.NewObjectID(), UserID: "alice", StorageKey: "alice/digest-1.mp4"}
+	uses := &models.Timeline{MediaItems: []models.TimelineItem{{ID: "clip-1", Type: "video", AssetID: asset.ID.Hex()}}}
+	byURL := &models.Timeline{MediaItems: []models.TimelineItem{{ID: "clip-1", Type: "video", URL: "/media/" + asset.StorageKey + "?sig=x"}}}
+	other := &models.Timeline{MediaItems: []models.TimelineItem{{ID: "clip-1", Type: "video", AssetID: primitive.NewObjectID().Hex()}}}
+
+	current, edited, unrelated := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	for _, p := range []models.Project{
+		{ID: current, UserID: "alice", Timeline: uses},
+		{ID: edited, UserID: "alice", Timeline: other},
+		{ID: unrelated, UserID: "alice", Timeline: other},
+	} {
+		if _, err := s.projectsCollection.InsertOne(db.Ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The clip was taken out of edited, but restoring its earlier version
+	// would bring it back
+	for _, v := range []models.ProjectVersion{
+		{ProjectID: current, Revision: 1, Timeline: uses},
+		{ProjectID: edited, Revision: 1, Timeline: byURL},
+		{ProjectID: edited, Revision: 2, Timeline: other},
+		{ProjectID: unrelated, Revision: 1, Timeline: other},
+	} {
+		if _, err := s.versionsCollection.InsertOne(db.Ctx, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	template := primitive.NewObjectID()
+	for _, tmpl := range []models.ProjectTemplate{
+		{ID: template, OwnerID: "alice", Timeline: uses},
+		{ID: primitive.NewObjectID(), OwnerID: "alice", Timeline: other},
+	} {
+		if _, err := s.templatesCollection.InsertOne(db.Ctx, tmpl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	refs, err := s.References(asset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(refs.Projects)
+	wantProjects := []string{current.Hex(), edited.Hex()}
+	slices.Sort(wantProjects)
+	if !slices.Equal(refs.Projects, wantProjects) {
+		t.Errorf("projects %v, want %v", refs.Projects, wantProjects)
+	}
+	if !slices.Equal(refs.Templates, []string{template.Hex()}) {
+		t.Errorf("templates %v, want [%s]", refs.Templates, template.Hex())
+	}
+}
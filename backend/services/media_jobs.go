@@ -0,0 +1,249 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"video-editor/models"
+	"video-editor/storage"
+	"video-editor/websocket"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actions of jobs that derive files from an uploaded asset
+const (
+	ActionGenerateProxy      = "generate_proxy"
+	ActionGenerateThumbnails = "generate_thumbnails"
+	ActionGenerateWaveform   = "generate_waveform"
+	ActionDetectScenes       = "detect_scenes"
+	ActionDetectSilences     = "detect_silences"
+)
+
+// derivedActions maps each kind of derived file to the job that generates it
+var derivedActions = map[string]string{
+	models.DerivedProxy:         ActionGenerateProxy,
+	models.DerivedPoster:        ActionGenerateThumbnails,
+	models.DerivedSprite:        ActionGenerateThumbnails,
+	models.DerivedThumbnailsVTT: ActionGenerateThumbnails,
+}
+
+func init() {
+	for _, spp := range WaveformZoomLevels {
+		derivedActions[WaveformKind(spp)] = ActionGenerateWaveform
+	}
+}
+
+// pendingMediaJobs tracks queued jobs by action and asset so lazy
+// regeneration does not queue the same work repeatedly
+var pendingMediaJobs = struct {
+	sync.Mutex
+	jobs map[string]bool
+}{jobs: make(map[string]bool)}
+
+// errNoVideoStream is returned when a video-only job runs on an asset without video
+var errNoVideoStream = errors.New("asset has no video stream")
+
+// errNoAudioStream is returned when an audio job runs on an asset without audio
+var errNoAudioStream = errors.New("asset has no audio stream")
+
+// MediaJobQueue holds jobs that derive files from uploaded assets. They run on
+// their own worker so they never wait behind long exports.
+var MediaJobQueue = make(chan models.VideoProcessingJob, 100)
+
+// QueueMediaJob schedules a derived media job for an asset without blocking
+// the caller when the queue is full. It does nothing if the same job is
+// already waiting or running.
+func QueueMediaJob(action string, asset *models.MediaAsset) {
+	queueMediaJob(action, asset, map[string]interface{}{"asset_id": asset.ID.Hex()})
+}
+
+// QueueSceneDetection schedules scene detection for a video asset, keeping
+// boundaries down to threshold or the default analysis floor if lower
+func QueueSceneDetection(asset *models.MediaAsset, threshold float64) {
+	queueMediaJob(ActionDetectScenes, asset, map[string]interface{}{
+		"asset_id":  asset.ID.Hex(),
+		"threshold": threshold,
+	})
+}
+
+// QueueSilenceDetection schedules silence detection for an asset at a noise
+// level in dB
+func QueueSilenceDetection(asset *models.MediaAsset, noiseDB float64) {
+	queueMediaJob(ActionDetectSilences, asset, map[string]interface{}{
+		"asset_id": asset.ID.Hex(),
+		"noise_db": noiseDB,
+	})
+}
+
+func queueMediaJob(action string, asset *models.MediaAsset, params map[string]interface{}) {
+	pendingKey := action + ":" + asset.ID.Hex()
+	pendingMediaJobs.Lock()
+	if pendingMediaJobs.jobs[pendingKey] {
+		pendingMediaJobs.Unlock()
+		return
+	}
+	pendingMediaJobs.jobs[pendingKey] = true
+	pendingMediaJobs.Unlock()
+
+	job := models.VideoProcessingJob{
+		ID:        primitive.NewObjectID(),
+		UserID:    asset.UserID,
+		Action:    action,
+		Params:    params,
+		Status:    "pending",
+		CreatedAt: time.Now(),
+	}
+	go func() {
+		MediaJobQueue <- job
+		log.Printf("Job %s added to media queue. Action: %s, Asset: %s", job.ID.Hex(), action, asset.ID.Hex())
+	}()
+}
+
+// StartMediaWorker processes derived media jobs, notifying the owner over the
+// websocket when each one finishes
+func (vp *VideoProcessor) StartMediaWorker(hub *websocket.Hub) {
+	for job := range MediaJobQueue {
+		assetID, _ := job.Params["asset_id"].(string)
+		if err := vp.updateJobStatus(job.ID, "processing", ""); err != nil {
+			log.Printf("Failed to update job %s status to processing: %v", job.ID.Hex(), err)
+		}
+
+		outputURL, err := vp.executeMediaJob(job, assetID)
+		pendingMediaJobs.Lock()
+		delete(pendingMediaJobs.jobs, job.Action+":"+assetID)
+		pendingMediaJobs.Unlock()
+		if err != nil {
+			log.Printf("Media job %s (%s) failed for asset %s: %v", job.ID.Hex(), job.Action, assetID, err)
+			if err := vp.updateJobStatus(job.ID, "failed", err.Error()); err != nil {
+				log.Printf("Failed to update job %s status to failed: %v", job.ID.Hex(), err)
+			}
+			hub.BroadcastToUser(job.UserID, fmt.Sprintf("Asset %s: %s failed! %v", assetID, job.Action, err))
+			continue
+		}
+
+		if err := vp.updateJobStatus(job.ID, "completed", outputURL); err != nil {
+			log.Printf("Failed to update job %s status to completed: %v", job.ID.Hex(), err)
+		}
+		log.Printf("Media job %s (%s) completed for asset %s", job.ID.Hex(), job.Action, assetID)
+		hub.BroadcastToUser(job.UserID, fmt.Sprintf("Asset %s: %s ready: %s", assetID, job.Action, outputURL))
+	}
+}
+
+// executeMediaJob runs a derived media job against the asset it names
+func (vp *VideoProcessor) executeMediaJob(job models.VideoProcessingJob, assetID string) (string, error) {
+	asset, err := vp.assets.GetAsset(assetID, job.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	switch job.Action {
+	case ActionGenerateProxy:
+		return vp.generateProxy(asset)
+	case ActionGenerateThumbnails:
+		return vp.generateThumbnails(asset)
+	case ActionGenerateWaveform:
+		return vp.generateWaveform(asset)
+	case ActionDetectScenes:
+		threshold, _ := job.Params["threshold"].(float64)
+		return vp.detectScenes(asset, threshold)
+	case ActionDetectSilences:
+		noiseDB, _ := job.Params["noise_db"].(float64)
+		return vp.detectSilences(asset, noiseDB)
+	default:
+		return "", fmt.Errorf("unsupported media action: %s", job.Action)
+	}
+}
+
+// storeDerived moves a generated file into storage next to the asset and
+// records it as a derived file, replacing any earlier one of the same kind
+func (vp *VideoProcessor) storeDerived(asset *models.MediaAsset, kind, localPath, name string) (string, error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return "", err
+	}
+	key := derivedKey(asset, name)
+	if err := storage.PutFile(vp.store, key, localPath); err != nil {
+		return "", fmt.Errorf("failed to store %s: %v", kind, err)
+	}
+
+	derived := models.DerivedFile{
+		Kind:       kind,
+		StorageKey: key,
+		Size:       info.Size(),
+		CreatedAt:  time.Now(),
+	}
+	if err := vp.assets.SetDerived(asset.ID, derived); err != nil {
+		// The asset may have been deleted while the job ran
+		vp.store.Delete(key)
+		return "", err
+	}
+	return vp.store.SignedURL(key, signedURLExpiry)
+}
+
+// derivedKey is the storage key of a file generated from an asset
+func derivedKey(asset *models.MediaAsset, name string) string {
+	return asset.UserID + "/derived/" + asset.ID.Hex() + "/" + name
+}
+
+// canGenerate reports whether a derived media job applies to an asset
+func canGenerate(action string, asset *models.MediaAsset) bool {
+	switch action {
+	case ActionGenerateProxy:
+		return asset.Type == "video" && hasVideoStream(asset.Metadata)
+	case ActionGenerateThumbnails:
+		return hasVideoStream(asset.Metadata)
+	case ActionGenerateWaveform, ActionDetectSilences:
+		return hasAudioStream(asset.Metadata)
+	case ActionDetectScenes:
+		return asset.Type == "video" && hasVideoStream(asset.Metadata)
+	default:
+		return false
+	}
+}
+
+// canDerive reports whether a kind of derived file can be generated for an
+// asset. The thumbnails job runs for still images too, but stores only a poster.
+func canDerive(kind string, asset *models.MediaAsset) bool {
+	action, ok := derivedActions[kind]
+	if !ok || !canGenerate(action, asset) {
+		return false
+	}
+	switch kind {
+	case models.DerivedSprite, models.DerivedThumbnailsVTT:
+		return asset.Type == "video" && asset.Metadata.Duration > 0
+	default:
+		return true
+	}
+}
+
+// hasAudioStream reports whether probed media contains audio
+func hasAudioStream(meta *models.MediaMetadata) bool {
+	if meta == nil {
+		return false
+	}
+	for _, s := range meta.Streams {
+		if s.CodecType == "audio" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasVideoStream reports whether probed media contains real video rather than
+// only cover art
+func hasVideoStream(meta *models.MediaMetadata) bool {
+	if meta == nil {
+		return false
+	}
+	for _, s := range meta.Streams {
+		if s.CodecType == "video" && !s.AttachedPic {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"video-editor/models"
+)
+
+func TestCanDerive(t *testing.T) {
+	videoStream := models.MediaStream{CodecType: "video", CodecName: "h264"}
+	audioStream := models.MediaStream{CodecType: "audio", CodecName: "aac"}
+	image := &models.MediaAsset{Type: "image", Metadata: &models.MediaMetadata{
+		Streams: []models.MediaStream{{CodecType: "video", CodecName: "png"}},
+	}}
+	video := &models.MediaAsset{Type: "video", Metadata: &models.MediaMetadata{
+		Duration: 12, Streams: []models.MediaStream{videoStream, audioStream},
+	}}
+	audio := &models.MediaAsset{Type: "audio", Metadata: &models.MediaMetadata{
+		Duration: 30, Streams: []models.MediaStream{audioStream},
+	}}
+	unprobed := &models.MediaAsset{Type: "video"}
+
+	tests := []struct {
+		name  string
+		asset *models.MediaAsset
+		kind  string
+		want  bool
+	}{
+		{"image poster", image, models.DerivedPoster, true},
+		{"image sprite", image, models.DerivedSprite, false},
+		{"image vtt", image, models.DerivedThumbnailsVTT, false},
+		{"image proxy", image, models.DerivedProxy, false},
+		{"video poster", video, models.DerivedPoster, true},
+		{"video sprite", video, models.DerivedSprite, true},
+		{"video vtt", video, models.DerivedThumbnailsVTT, true},
+		{"video waveform", video, WaveformKind(WaveformZoomLevels[0]), true},
+		{"audio poster", audio, models.DerivedPoster, false},
+		{"audio waveform", audio, WaveformKind(WaveformZoomLevels[0]), true},
+		{"unprobed sprite", unprobed, models.DerivedSprite, false},
+		{"unknown kind", video, "filmstrip", false},
+	}
+	for _, tt := range tests {
+		if got := canDerive(tt.kind, tt.asset); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEnsureDerivedStillImageThumbnails(t *testing.T) {
+	image := &models.MediaAsset{Type: "image", Metadata: &models.MediaMetadata{
+		Streams: []models.MediaStream{{CodecType: "video", CodecName: "mjpeg"}},
+	}}
+	// The sprite and VTT are never generated for stills, so asking for them
+	// must not queue the thumbnails job again
+	s := &AssetService{}
+	for _, kind := range []string{models.DerivedSprite, models.DerivedThumbnailsVTT} {
+		if _, err := s.EnsureDerived(image, kind); !errors.Is(err, ErrDerivedUnavailable) {
+			t.Errorf("%s: got %v, want ErrDerivedUnavailable", kind, err)
+		}
+	}
+	if _, err := s.ThumbnailsVTT(image); !errors.Is(err, ErrDerivedUnavailable) {
+		t.Errorf("ThumbnailsVTT: got %v, want ErrDerivedUnavailable", err)
+	}
+}
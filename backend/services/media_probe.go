@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"video-editor/models"
+)
+
+// ffprobeOutput mirrors the parts of `ffprobe -show_format -show_streams` we use.
+// ffprobe reports most numbers as strings, so they are parsed afterwards.
+type ffprobeOutput struct {
+	Streams []struct {
+		Index         int               `json:"index"`
+		CodecName     string            `json:"codec_name"`
+		CodecType     string            `json:"codec_type"`
+		Profile       string            `json:"profile"`
+		Width         int               `json:"width"`
+		Height        int               `json:"height"`
+		PixFmt        string            `json:"pix_fmt"`
+		RFrameRate    string            `json:"r_frame_rate"`
+		AvgFrameRate  string            `json:"avg_frame_rate"`
+		SampleRate    string            `json:"sample_rate"`
+		Channels      int               `json:"channels"`
+		ChannelLayout string            `json:"channel_layout"`
+		BitRate       string            `json:"bit_rate"`
+		Duration      string            `json:"duration"`
+		Tags          map[string]string `json:"tags"`
+		Disposition   map[string]int    `json:"disposition"`
+		SideDataList  []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string            `json:"format_name"`
+		Duration   string            `json:"duration"`
+		BitRate    string            `json:"bit_rate"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+// ProbeMedia runs ffprobe on a local file and returns its metadata
+func ProbeMedia(path string) (*models.MediaMetadata, error) {
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		path,
+	)
+
+	var out bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %v\nStderr: %s", err, stderr.String())
+	}
+
+	var probe ffprobeOutput
+	if err := json.Unmarshal(out.Bytes(), &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %v", err)
+	}
+	return probe.toMetadata(), nil
+}
+
+// toMetadata converts raw ffprobe output into a MediaMetadata, filling the
+// summary fields from the first video and audio streams.
+func (p *ffprobeOutput) toMetadata() *models.MediaMetadata {
+	meta := &models.MediaMetadata{
+		FormatName:    p.Format.FormatName,
+		Duration:      parseFloat(p.Format.Duration),
+		BitRate:       parseInt(p.Format.BitRate),
+		StartTimecode: p.Format.Tags["timecode"],
+	}
+
+	haveVideo, haveAudio := false, false
+	for _, s := range p.Streams {
+		stream := models.MediaStream{
+			Index:         s.Index,
+			CodecType:     s.CodecType,
+			CodecName:     s.CodecName,
+			Profile:       s.Profile,
+			Width:         s.Width,
+			Height:        s.Height,
+			PixelFormat:   s.PixFmt,
+			SampleRate:    int(parseInt(s.SampleRate)),
+			Channels:      s.Channels,
+			ChannelLayout: s.ChannelLayout,
+			BitRate:       parseInt(s.BitRate),
+			Duration:      parseFloat(s.Duration),
+			AttachedPic:   s.Disposition["attached_pic"] == 1,
+		}
+
+		if s.CodecType == "video" {
+			stream.FrameRate = parseRational(s.AvgFrameRate)
+			if stream.FrameRate == 0 {
+				stream.FrameRate = parseRational(s.RFrameRate)
+			}
+			// Newer ffprobe versions report rotation in the display matrix side data,
+			// older ones in the "rotate" tag.
+			if rotate, ok := s.Tags["rotate"]; ok {
+				stream.Rotation = int(parseInt(rotate))
+			}
+			for _, sd := range s.SideDataList {
+				if sd.Rotation != 0 {
+					stream.Rotation = int(math.Round(sd.Rotation))
+				}
+			}
+		}
+		meta.Streams = append(meta.Streams, stream)
+		// MOV files may keep the timecode on a tmcd stream rather than the container
+		if meta.StartTimecode == "" {
+			meta.StartTimecode = s.Tags["timecode"]
+		}
+
+		switch {
+		case s.CodecType == "video" && !stream.AttachedPic && !haveVideo:
+			haveVideo = true
+			meta.Width = stream.Width
+			meta.Height = stream.Height
+			meta.FrameRate = stream.FrameRate
+			meta.Rotation = stream.Rotation
+		case s.CodecType == "audio" && !haveAudio:
+			haveAudio = true
+			meta.SampleRate = stream.SampleRate
+			meta.Channels = stream.Channels
+			meta.ChannelLayout = stream.ChannelLayout
+		}
+	}
+
+	// Fall back to the longest stream when the container has no duration
+	if meta.Duration == 0 {
+		for _, s := range meta.Streams {
+			meta.Duration = math.Max(meta.Duration, s.Duration)
+		}
+	}
+	return meta
+}
+
+// MediaType classifies a file as "video", "audio" or "image", preferring probed
+// metadata and falling back to the file extension.
+func MediaType(filename string, meta *models.MediaMetadata) string {
+	if meta == nil {
+		return mediaTypeFromExtension(filename)
+	}
+
+	hasVideo, hasAudio := false, false
+	for _, s := range meta.Streams {
+		switch {
+		case s.CodecType == "video" && !s.AttachedPic:
+			hasVideo = true
+		case s.CodecType == "audio":
+			hasAudio = true
+		}
+	}
+
+	switch {
+	case hasVideo && isImageFormat(meta.FormatName):
+		return "image"
+	case hasVideo:
+		return "video"
+	case hasAudio:
+		return "audio"
+	default:
+		return mediaTypeFromExtension(filename)
+	}
+}
+
+// isImageFormat reports whether ffprobe's format name is one of its still image demuxers
+func isImageFormat(formatName string) bool {
+	return formatName == "image2" || formatName == "gif" || strings.HasSuffix(formatName, "_pipe")
+}
+
+// mediaTypeFromExtension guesses the media type from a filename
+func mediaTypeFromExtension(filename string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	switch ext {
+	case ".mp4", ".avi", ".mov", ".mkv", ".webm":
+		return "video"
+	case ".mp3", ".wav", ".flac", ".aac":
+		return "audio"
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+		return "image"
+	default:
+		return "unknown"
+	}
+}
+
+func parseFloat(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
+
+func parseInt(s string) int64 {
+	v, _ := strconv.ParseInt(s, 10, 64)
+	return v
+}
+
+// parseRational parses ffprobe rationals such as "30000/1001"
+func parseRational(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		return parseFloat(s)
+	}
+	d := parseFloat(den)
+	if d == 0 {
+		return 0
+	}
+	return parseFloat(num) / d
+}
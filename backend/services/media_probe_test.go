@@ -0,0 +1,27 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestProbeStartTimecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		probe string
+		want  string
+	}{
+		{"container", `{"format": {"tags": {"timecode": "10:00:00:00"}}, "streams": [{"codec_type": "video"}]}`, "10:00:00:00"},
+		{"tmcd stream", `{"format": {}, "streams": [{"codec_type": "video"}, {"codec_type": "data", "tags": {"timecode": "01:00:00;00"}}]}`, "01:00:00;00"},
+		{"none", `{"format": {"tags": {"encoder": "Lavf"}}, "streams": [{"codec_type": "audio"}]}`, ""},
+	}
+	for _, tt := range tests {
+		var probe ffprobeOutput
+		if err := json.Unmarshal([]byte(tt.probe), &probe); err != nil {
+			t.Fatal(err)
+		}
+		if got := probe.toMetadata().StartTimecode; got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// otioMetadataKey namespaces what OTIO metadata this editor writes
+const otioMetadataKey = "video_editor"
+
+type otioTimeline struct {
+	Schema          string         `json:"OTIO_SCHEMA"`
+	Name            string         `json:"name"`
+	Metadata        map[string]any `json:"metadata"`
+	GlobalStartTime *otioTime      `json:"global_start_time"`
+	Tracks          otioStack      `json:"tracks"`
+}
+
+type otioStack struct {
+	Schema      string         `json:"OTIO_SCHEMA"`
+	Name        string         `json:"name"`
+	Metadata    map[string]any `json:"metadata"`
+	SourceRange *otioRange     `json:"source_range"`
+	Effects     []any          `json:"effects"`
+	Markers     []any          `json:"markers"`
+	Enabled     bool           `json:"enabled"`
+	Children    []otioTrack    `json:"children"`
+}
+
+type otioTrack struct {
+	Schema      string         `json:"OTIO_SCHEMA"`
+	Name        string         `json:"name"`
+	Metadata    map[string]any `json:"metadata"`
+	SourceRange *otioRange     `json:"source_range"`
+	Effects     []any          `json:"effects"`
+	Markers     []any          `json:"markers"`
+	Enabled     bool           `json:"enabled"`
+	Kind        string         `json:"kind"`
+	Children    []any          `json:"children"`
+}
+
+// otioItem is a clip or a gap
+type otioItem struct {
+	Schema                  string                   `json:"OTIO_SCHEMA"`
+	Name                    string                   `json:"name"`
+	Metadata                map[string]any           `json:"metadata"`
+	SourceRange             *otioRange               `json:"source_range"`
+	Effects                 []any                    `json:"effects"`
+	Markers                 []any                    `json:"markers"`
+	Enabled                 bool                     `json:"enabled"`
+	MediaReferences         map[string]otioReference `json:"media_references,omitempty"`
+	ActiveMediaReferenceKey string                   `json:"active_media_reference_key,omitempty"`
+}
+
+type otioReference struct {
+	Schema         string         `json:"OTIO_SCHEMA"`
+	Name           string         `json:"name"`
+	Metadata       map[string]any `json:"metadata"`
+	AvailableRange *otioRange     `json:"available_range"`
+	TargetURL      string         `json:"target_url,omitempty"`
+	GeneratorKind  string         `json:"generator_kind,omitempty"`
+	Parameters     map[string]any `json:"parameters,omitempty"`
+}
+
+type otioTransition struct {
+	Schema         string         `json:"OTIO_SCHEMA"`
+	Name           string         `json:"name"`
+	Metadata       map[string]any `json:"metadata"`
+	TransitionType string         `json:"transition_type"`
+	InOffset       otioTime       `json:"in_offset"`
+	OutOffset      otioTime       `json:"out_offset"`
+}
+
+type otioRange struct {
+	Schema    string   `json:"OTIO_SCHEMA"`
+	StartTime otioTime `json:"start_time"`
+	Duration  otioTime `json:"duration"`
+}
+
+type otioTime struct {
+	Schema string  `json:"OTIO_SCHEMA"`
+	Rate   float64 `json:"rate"`
+	Value  float64 `json:"value"`
+}
+
+// WriteOTIO writes the project as an OpenTimelineIO timeline. Every track is
+// written, video tracks first from the bottom, then audio tracks holding
+// audio clips and the sound of unmuted video clips. Text clips refer to a
+// "Text" generator with their text and font as parameters. Transitions are
+// written on video tracks; fades and dissolves as SMPTE dissolves, others as
+// custom transitions named by their type. Media are referred to by
+// filename, and each clip's metadata carries its clip and asset IDs.
+func WriteOTIO(w io.Writer, p *InterchangeProject) error {
+	var tracks []otioTrack
+	for i, track := range p.tracks("video") {
+		tracks = append(tracks, otioTrackOf(p, track, fmt.Sprintf("V%d", i+1), "Video", p.transitions(track)))
+	}
+	for i, track := range p.tracks("audio") {
+		tracks = append(tracks, otioTrackOf(p, track, fmt.Sprintf("A%d", i+1), "Audio", nil))
+	}
+	if tracks == nil {
+		tracks = []otioTrack{}
+	}
+	timeline := otioTimeline{
+		Schema: "Timeline.1",
+		Name:   p.Name,
+		Metadata: map[string]any{otioMetadataKey: map[string]any{
+			"aspect_ratio": p.Timeline.AspectRatio,
+			"width":        p.Width,
+			"height":       p.Height,
+		}},
+		GlobalStartTime: otioTimeAt(p.Rate, 0),
+		Tracks: otioStack{
+			Schema:   "Stack.1",
+			Name:     "tracks",
+			Metadata: map[string]any{},
+			Effects:  []any{},
+			Markers:  []any{},
+			Enabled:  true,
+			Children: tracks,
+		},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "    ")
+	return enc.Encode(timeline)
+}
+
+// otioTrackOf writes a track's clips with gaps between them and the given
+// transitions, keyed by the clip they lead into
+func otioTrackOf(p *InterchangeProject, track interchangeTrack, name, kind string, transitions map[string]interchangeTransition) otioTrack {
+	out := otioTrack{
+		Schema:   "Track.1",
+		Name:     name,
+		Metadata: map[string]any{},
+		Effects:  []any{},
+		Markers:  []any{},
+		Enabled:  true,
+		Kind:     kind,
+		Children: []any{},
+	}
+	at := int64(0)
+	for _, clip := range track.clips {
+		if clip.start > at {
+			out.Children = append(out.Children, otioItem{
+				Schema:      "Gap.1",
+				Metadata:    map[string]any{},
+				SourceRange: otioRangeOf(p.Rate, 0, clip.start-at),
+				Effects:     []any{},
+				Markers:     []any{},
+				Enabled:     true,
+			})
+		}
+		if t, ok := transitions[clip.item.ID]; ok {
+			transitionType := "SMPTE_Dissolve"
+			if edlTransitionCode(t.transition.Type) != "D" {
+				transitionType = "Custom_Transition"
+			}
+			out.Children = append(out.Children, otioTransition{
+				Schema:         "Transition.1",
+				Name:           t.transition.Type,
+				Metadata:       map[string]any{otioMetadataKey: map[string]any{"transition_id": t.transition.ID}},
+				TransitionType: transitionType,
+				InOffset:       *otioTimeAt(p.Rate, t.before),
+				OutOffset:      *otioTimeAt(p.Rate, t.after),
+			})
+		}
+		out.Children = append(out.Children, otioClip(p, clip))
+		at = clip.end()
+	}
+	return out
+}
+
+// otioClip writes a clip with a reference to its file, or for text clips to
+// a text generator
+func otioClip(p *InterchangeProject, clip interchangeClip) otioItem {
+	metadata := map[string]any{"clip_id": clip.item.ID}
+	ref := otioReference{Metadata: map[string]any{}}
+	if clip.item.Type == "text" {
+		text := clip.item.Text
+		if text == "" {
+			text = clip.item.Content
+		}
+		color := clip.item.Color
+		if color == "" {
+			color = clip.item.FontColor
+		}
+		ref.Schema = "GeneratorReference.1"
+		ref.Name = "Text"
+		ref.GeneratorKind = "Text"
+		ref.Parameters = map[string]any{
+			"text":        text,
+			"font_family": clip.item.FontFamily,
+			"font_size":   clip.item.FontSize,
+			"color":       color,
+		}
+	} else {
+		metadata["asset_id"] = clip.item.AssetID
+		ref.Schema = "ExternalReference.1"
+		ref.Name = clip.media.Name
+		ref.TargetURL = url.PathEscape(clip.media.Name)
+		if clip.media.Type != "image" && clip.media.Duration > 0 {
+			ref.AvailableRange = otioRangeOf(p.Rate, 0, p.Rate.Frames(clip.media.Duration))
+		}
+	}
+	return otioItem{
+		Schema:                  "Clip.2",
+		Name:                    clipName(clip),
+		Metadata:                map[string]any{otioMetadataKey: metadata},
+		SourceRange:             otioRangeOf(p.Rate, clip.in, clip.duration),
+		Effects:                 []any{},
+		Markers:                 []any{},
+		Enabled:                 true,
+		MediaReferences:         map[string]otioReference{"DEFAULT_MEDIA": ref},
+		ActiveMediaReferenceKey: "DEFAULT_MEDIA",
+	}
+}
+
+func otioRangeOf(rate FrameRate, start, duration int64) *otioRange {
+	return &otioRange{Schema: "TimeRange.1", StartTime: *otioTimeAt(rate, start), Duration: *otioTimeAt(rate, duration)}
+}
+
+func otioTimeAt(rate FrameRate, frames int64) *otioTime {
+	return &otioTime{Schema: "RationalTime.1", Rate: rate.FPS(), Value: float64(frames)}
+}
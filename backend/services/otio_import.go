@@ -0,0 +1,305 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// otioNode is any OpenTimelineIO object, read with the fields of all the
+// schemas the importer knows
+type otioNode struct {
+	Schema      string            `json:"OTIO_SCHEMA"`
+	Name        string            `json:"name"`
+	Metadata    map[string]any    `json:"metadata"`
+	SourceRange *otioRange        `json:"source_range"`
+	Enabled     *bool             `json:"enabled"`
+	Effects     []json.RawMessage `json:"effects"`
+
+	// Timeline, stacks and tracks
+	Tracks   *otioNode  `json:"tracks"`
+	Kind     string     `json:"kind"`
+	Children []otioNode `json:"children"`
+
+	// Clips: Clip.1 has one media reference, Clip.2 several
+	MediaReference          *otioNode            `json:"media_reference"`
+	MediaReferences         map[string]*otioNode `json:"media_references"`
+	ActiveMediaReferenceKey string               `json:"active_media_reference_key"`
+
+	// Media references
+	TargetURL      string         `json:"target_url"`
+	AvailableRange *otioRange     `json:"available_range"`
+	GeneratorKind  string         `json:"generator_kind"`
+	Parameters     map[string]any `json:"parameters"`
+
+	// Transitions
+	TransitionType string    `json:"transition_type"`
+	InOffset       *otioTime `json:"in_offset"`
+	OutOffset      *otioTime `json:"out_offset"`
+}
+
+// schema is the node's schema without its version, e.g. "Clip"
+func (n *otioNode) schema() string {
+	name, _, _ := strings.Cut(n.Schema, ".")
+	return name
+}
+
+func (n *otioNode) disabled() bool {
+	return n.Enabled != nil && !*n.Enabled
+}
+
+// mediaReference returns the media a clip plays, nil if it names none
+func (n *otioNode) mediaReference() *otioNode {
+	if n.MediaReferences != nil {
+		key := n.ActiveMediaReferenceKey
+		if key == "" {
+			key = "DEFAULT_MEDIA"
+		}
+		return n.MediaReferences[key]
+	}
+	return n.MediaReference
+}
+
+// editorMetadata returns a string this editor's export put in the metadata
+func (n *otioNode) editorMetadata(key string) string {
+	values, _ := n.Metadata[otioMetadataKey].(map[string]any)
+	value, _ := values[key].(string)
+	return value
+}
+
+// parseOTIO reads an OpenTimelineIO timeline. Video tracks are placed from
+// the bottom up, then audio tracks. Clips refer to media by URL; clips of a
+// "Text" generator become text clips. Nested stacks, other generators and
+// effects are left out with a warning, keeping the time they take.
+func parseOTIO(data []byte) (*importedTimeline, error) {
+	var root otioNode
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, invalidInterchange("%v", err)
+	}
+	if root.schema() != "Timeline" {
+		return nil, invalidInterchange("expected a Timeline, found %q", root.Schema)
+	}
+	if root.Tracks == nil {
+		return nil, invalidInterchange("timeline has no tracks")
+	}
+	t := &importedTimeline{name: root.Name, aspectRatio: root.editorMetadata("aspect_ratio")}
+
+	var video, audio []otioNode
+	for _, track := range root.Tracks.Children {
+		switch {
+		case track.schema() != "Track":
+			t.warn("%s %q is not a track and is not imported", track.schema(), track.Name)
+		case strings.EqualFold(track.Kind, "Video"):
+			video = append(video, track)
+		case strings.EqualFold(track.Kind, "Audio"):
+			audio = append(audio, track)
+		default:
+			t.warn("track %q of kind %q is not imported", track.Name, track.Kind)
+		}
+	}
+	for i, track := range video {
+		if err := t.addOTIOTrack(track, i, false, fmt.Sprintf("V%d", i+1)); err != nil {
+			return nil, err
+		}
+	}
+	for i, track := range audio {
+		if err := t.addOTIOTrack(track, len(video)+i, true, fmt.Sprintf("A%d", i+1)); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// addOTIOTrack places a track's clips on an editor track. A transition
+// joins the clips either side of it when both are imported.
+func (t *importedTimeline) addOTIOTrack(track otioNode, number int, audio bool, label string) error {
+	if track.disabled() {
+		t.warn("track %s is disabled and not imported", label)
+		return nil
+	}
+	at := 0.0
+	last := -1 // Index of the clip ending at the playhead
+	var pending *otioNode
+	for i := range track.Children {
+		child := &track.Children[i]
+		ref := fmt.Sprintf("%s item %d", label, i+1)
+		if child.schema() == "Transition" {
+			pending = child
+			continue
+		}
+		duration, err := otioDuration(child)
+		if err != nil {
+			return invalidInterchange("%s: %v", ref, err)
+		}
+		idx := -1
+		switch child.schema() {
+		case "Gap":
+		case "Clip":
+			if idx, err = t.addOTIOClip(child, ref, number, audio, at, duration); err != nil {
+				return err
+			}
+		case "Stack", "Track":
+			t.warn("%s: nested %s %q imported as a gap", ref, strings.ToLower(child.schema()), child.Name)
+		default:
+			t.warn("%s: %s is not imported", ref, child.Schema)
+		}
+		if pending != nil {
+			t.addOTIOTransition(pending, ref, audio, last, idx)
+			pending = nil
+		}
+		last = idx
+		at += duration
+	}
+	return nil
+}
+
+// addOTIOClip adds a clip starting at a time on the track and returns its
+// index, or -1 if it isn't imported
+func (t *importedTimeline) addOTIOClip(node *otioNode, ref string, track int, audio bool, start, duration float64) (int, error) {
+	if node.disabled() {
+		t.warn("%s: clip %q is disabled and not imported", ref, node.Name)
+		return -1, nil
+	}
+	if len(node.Effects) > 0 {
+		t.warn("%s: effects on clip %q are not imported", ref, node.Name)
+	}
+	media := node.mediaReference()
+	sourceStart := 0.0
+	if node.SourceRange != nil {
+		var err error
+		if sourceStart, err = otioSeconds(node.SourceRange.StartTime); err != nil {
+			return -1, invalidInterchange("%s: %v", ref, err)
+		}
+	}
+	// Source times count from the media's own start, often a timecode
+	if media != nil && media.AvailableRange != nil {
+		origin, err := otioSeconds(media.AvailableRange.StartTime)
+		if err != nil {
+			return -1, invalidInterchange("%s: %v", ref, err)
+		}
+		sourceStart = max(sourceStart-origin, 0)
+	}
+
+	clipType := "video"
+	if audio {
+		clipType = "audio"
+	}
+	clip := importedClip{ref: ref, assetID: node.editorMetadata("asset_id")}
+	if media != nil && media.schema() == "GeneratorReference" {
+		if !strings.EqualFold(media.GeneratorKind, "Text") || audio {
+			t.warn("%s: %s generator is not imported", ref, media.GeneratorKind)
+			return -1, nil
+		}
+		clip.item = importedItem("text", node.Name, track, start, start+duration, 0)
+		clip.item.Text, _ = media.Parameters["text"].(string)
+		clip.item.FontFamily, _ = media.Parameters["font_family"].(string)
+		clip.item.FontSize, _ = media.Parameters["font_size"].(float64)
+		clip.item.Color, _ = media.Parameters["color"].(string)
+		return t.addClip(clip)
+	}
+
+	var names []string
+	if media != nil {
+		if media.schema() == "ExternalReference" && media.TargetURL != "" {
+			names = append(names, otioMediaName(media.TargetURL))
+		}
+		names = append(names, media.Name)
+	}
+	name := node.Name
+	for _, value := range append(names, node.Name) {
+		if value == "" {
+			continue
+		}
+		clip.names = append(clip.names, importedName{value: value})
+		if name == "" {
+			name = value
+		}
+	}
+	clip.item = importedItem(clipType, name, track, start, start+duration, sourceStart)
+	if audio {
+		t.hasAudio = true
+	}
+	return t.addClip(clip)
+}
+
+// addOTIOTransition joins two imported clips, given by index, with a
+// transition. Known editor transition types are kept by name; others
+// become fades.
+func (t *importedTimeline) addOTIOTransition(node *otioNode, ref string, audio bool, from, to int) {
+	switch {
+	case audio:
+		t.warn("%s: audio transition imported as a cut", ref)
+		return
+	case from < 0 || to < 0:
+		t.warn("%s: transition without clips on both sides imported as a cut", ref)
+		return
+	case node.InOffset == nil || node.OutOffset == nil:
+		t.warn("%s: transition without offsets imported as a cut", ref)
+		return
+	}
+	in, errIn := otioSeconds(*node.InOffset)
+	out, errOut := otioSeconds(*node.OutOffset)
+	if errIn != nil || errOut != nil || in+out <= 0 {
+		t.warn("%s: transition without a length imported as a cut", ref)
+		return
+	}
+	transitionType := node.Name
+	if !transitionTypes[transitionType] {
+		transitionType = "fade"
+	}
+	t.addTransition(transitionType, from, to, in+out)
+}
+
+// otioDuration is how long an item takes on its track: its source range,
+// else for a clip the media's available range, for a track the sum of its
+// items and for a stack its longest track
+func otioDuration(n *otioNode) (float64, error) {
+	if n.SourceRange != nil {
+		return otioSeconds(n.SourceRange.Duration)
+	}
+	total := 0.0
+	switch n.schema() {
+	case "Clip":
+		if media := n.mediaReference(); media != nil && media.AvailableRange != nil {
+			return otioSeconds(media.AvailableRange.Duration)
+		}
+	case "Track", "Stack":
+		for i := range n.Children {
+			child := &n.Children[i]
+			if child.schema() == "Transition" {
+				continue
+			}
+			d, err := otioDuration(child)
+			if err != nil {
+				return 0, err
+			}
+			if n.schema() == "Track" {
+				total += d
+			} else {
+				total = max(total, d)
+			}
+		}
+		return total, nil
+	}
+	return 0, fmt.Errorf("%s %q has no duration", n.schema(), n.Name)
+}
+
+// otioSeconds converts a rational time to seconds
+func otioSeconds(t otioTime) (float64, error) {
+	if t.Rate <= 0 {
+		return 0, fmt.Errorf("invalid rate %v", t.Rate)
+	}
+	return t.Value / t.Rate, nil
+}
+
+// otioMediaName is the filename a media URL ends in
+func otioMediaName(target string) string {
+	if u, err := url.Parse(target); err == nil && u.Path != "" {
+		return mediaBaseName(u.Path)
+	}
+	if unescaped, err := url.PathUnescape(target); err == nil {
+		target = unescaped
+	}
+	return mediaBaseName(target)
+}
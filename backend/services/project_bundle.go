@@ -0,0 +1,503 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"video-editor/models"
+	"video-editor/storage"
+)
+
+const (
+	maxBundleFiles        = 1000
+	maxBundleTimelineSize = 16 << 20
+	maxBundleManifestSize = 1 << 20
+)
+
+// ErrInvalidBundle is returned for an uploaded bundle that is malformed,
+// tampered with or of an unknown version
+var ErrInvalidBundle = errors.New("invalid project bundle")
+
+// BundleService packs a project with its media into a zip that can be
+// imported into another account or environment
+type BundleService struct {
+	projects *ProjectService
+	assets   *AssetService
+	store    storage.Backend
+	workDir  string // Scratch space for bundles being written or unpacked
+}
+
+// NewBundleService creates a new BundleService
+func NewBundleService(projects *ProjectService, assets *AssetService, store storage.Backend, workDir string) *BundleService {
+	return &BundleService{
+		projects: projects,
+		assets:   assets,
+		store:    store,
+		workDir:  workDir,
+	}
+}
+
+func invalidBundle(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidBundle, fmt.Sprintf(format, args...))
+}
+
+// ExportBundle writes a project's timeline and every asset its clips play
+// into a zip in the work directory and returns its path, which the caller
+// removes. Clips refer to media by their asset ID, listed in the manifest.
+func (s *BundleService) ExportBundle(projectID, userID string) (string, *models.Project, error) {
+	project, err := s.projects.GetProject(projectID, userID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Collect the assets first, so a missing one fails before anything is written
+	timeline := cloneTimeline(project.Timeline)
+	var assets []*models.MediaAsset
+	seen := make(map[string]bool)
+	for i := range timeline.MediaItems {
+		clip := &timeline.MediaItems[i]
+		if clip.AssetID == "" && clip.URL == "" {
+			continue
+		}
+		asset, err := s.assets.ClipAsset(clip, userID)
+		if err != nil {
+			return "", nil, fmt.Errorf("clip %s: %v", clip.ID, err)
+		}
+		clip.AssetID, clip.URL = asset.ID.Hex(), ""
+		if !seen[clip.AssetID] {
+			seen[clip.AssetID] = true
+			assets = append(assets, asset)
+		}
+	}
+
+	if err := os.MkdirAll(s.workDir, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create work directory: %v", err)
+	}
+	file, err := os.CreateTemp(s.workDir, "bundle_*.zip")
+	if err != nil {
+		return "", nil, err
+	}
+	localPath := file.Name()
+	if err := s.writeBundle(file, project.Name, timeline, assets); err != nil {
+		file.Close()
+		os.Remove(localPath)
+		return "", nil, err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(localPath)
+		return "", nil, err
+	}
+	return localPath, project, nil
+}
+
+func (s *BundleService) writeBundle(w io.Writer, name string, timeline *models.Timeline, assets []*models.MediaAsset) error {
+	archive := zip.NewWriter(w)
+	manifest := models.BundleManifest{
+		SchemaVersion: models.BundleSchemaVersion,
+		ProjectName:   name,
+		ExportedAt:    time.Now().UTC(),
+		Omitted:       bundleOmissions(timeline),
+	}
+
+	data, err := json.MarshalIndent(models.BundleTimeline{
+		SchemaVersion: models.BundleSchemaVersion,
+		Name:          name,
+		Timeline:      timeline,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	entry, err := bundleEntry(archive, models.BundleTimelinePath, zip.Deflate, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	entry.Kind = "timeline"
+	manifest.Files = append(manifest.Files, entry)
+
+	for i, asset := range assets {
+		r, err := s.store.Get(asset.StorageKey)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", asset.Filename, err)
+		}
+		// Media is already compressed, so it is stored as it is
+		entryPath := fmt.Sprintf("media/%03d-%s", i+1, SanitizeFilename(asset.Filename))
+		entry, err := bundleEntry(archive, entryPath, zip.Store, r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %v", asset.Filename, err)
+		}
+		entry.Kind, entry.AssetID, entry.Filename = "media", asset.ID.Hex(), asset.Filename
+		manifest.Files = append(manifest.Files, entry)
+	}
+
+	// The manifest goes last, once every checksum is known
+	data, err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := bundleEntry(archive, models.BundleManifestPath, zip.Deflate, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// bundleOmissions lists the fonts text clips name. Fonts are referred to by
+// family and drawn from whatever is installed where the project is opened,
+// so there is no file to bundle; timelines have no LUTs or other external
+// files.
+func bundleOmissions(timeline *models.Timeline) []models.BundleOmission {
+	omitted := []models.BundleOmission{}
+	byFamily := make(map[string]int)
+	for _, clip := range timeline.MediaItems {
+		if clip.Type != "text" || clip.FontFamily == "" {
+			continue
+		}
+		i, ok := byFamily[clip.FontFamily]
+		if !ok {
+			i = len(omitted)
+			byFamily[clip.FontFamily] = i
+			omitted = append(omitted, models.BundleOmission{
+				Kind:   "font",
+				Name:   clip.FontFamily,
+				Reason: "fonts are named by family and must be installed where the project is opened",
+			})
+		}
+		omitted[i].ClipIDs = append(omitted[i].ClipIDs, clip.ID)
+	}
+	return omitted
+}
+
+// bundleEntry copies r into the archive, returning its size and checksum
+func bundleEntry(archive *zip.Writer, name string, method uint16, r io.Reader) (models.BundleFile, error) {
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Now()})
+	if err != nil {
+		return models.BundleFile{}, err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w, h), r)
+	if err != nil {
+		return models.BundleFile{}, err
+	}
+	return models.BundleFile{Path: name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// ImportBundle creates a project for the user from a bundle at bundlePath.
+// Every file is checked against the manifest before anything is kept. The
+// media become the user's assets, going through the same checks as an
+// upload, and the clips are pointed at them. name overrides the bundle's
+// project name.
+func (s *BundleService) ImportBundle(userID, bundlePath, name string) (*models.Project, error) {
+	archive, err := zip.OpenReader(bundlePath)
+	if err != nil {
+		return nil, invalidBundle("not a zip file")
+	}
+	defer archive.Close()
+
+	entries, err := bundleEntries(archive.File)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readBundleManifest(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	var timelineFile models.BundleFile
+	var media []models.BundleFile
+	var mediaSize int64
+	for _, file := range manifest.Files {
+		switch {
+		case file.Kind == "timeline" && file.Path == models.BundleTimelinePath:
+			timelineFile = file
+		case file.Kind == "media":
+			media = append(media, file)
+			mediaSize += file.Size
+		default:
+			return nil, invalidBundle("unexpected %s file %s", file.Kind, file.Path)
+		}
+	}
+	if timelineFile.Path == "" {
+		return nil, invalidBundle("no timeline")
+	}
+
+	data, err := readBundleFile(entries[timelineFile.Path], timelineFile, maxBundleTimelineSize)
+	if err != nil {
+		return nil, err
+	}
+	var bundled models.BundleTimeline
+	if err := json.Unmarshal(data, &bundled); err != nil {
+		return nil, invalidBundle("timeline: %v", err)
+	}
+	if bundled.Timeline == nil {
+		return nil, invalidBundle("no timeline")
+	}
+	byAsset := make(map[string]models.BundleFile, len(media))
+	for _, file := range media {
+		byAsset[file.AssetID] = file
+	}
+	for _, clip := range bundled.Timeline.MediaItems {
+		if clip.Type == "text" {
+			continue
+		}
+		if _, ok := byAsset[clip.AssetID]; !ok {
+			return nil, invalidBundle("clip %s plays media that is not in the bundle", clip.ID)
+		}
+	}
+
+	if err := ValidateTimeline(bundled.Timeline); err != nil {
+		return nil, invalidBundle("timeline: %v", err)
+	}
+
+	if err := s.assets.CheckQuota(userID, mediaSize); err != nil {
+		return nil, err
+	}
+	imported, err := s.importMedia(userID, entries, media)
+	if err != nil {
+		return nil, err
+	}
+
+	timeline := bundled.Timeline
+	for i := range timeline.MediaItems {
+		clip := &timeline.MediaItems[i]
+		if asset, ok := imported[clip.AssetID]; ok {
+			clip.AssetID, clip.URL = asset.ID.Hex(), ""
+		}
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		name = bundled.Name
+	}
+	if name == "" {
+		name = "Imported project"
+	}
+	project := &models.Project{
+		UserID:   userID,
+		Name:     name,
+		Edits:    []models.EditOperation{},
+		Timeline: timeline,
+	}
+	if err := s.projects.CreateProject(project); err != nil {
+		s.removeImported(userID, imported)
+		return nil, err
+	}
+	return project, nil
+}
+
+// bundleEntries indexes a bundle's files by path. Paths that could escape
+// the bundle root, links and duplicate names are refused.
+func bundleEntries(files []*zip.File) (map[string]*zip.File, error) {
+	if len(files) > maxBundleFiles {
+		return nil, invalidBundle("more than %d files", maxBundleFiles)
+	}
+	entries := make(map[string]*zip.File, len(files))
+	for _, f := range files {
+		if strings.HasSuffix(f.Name, "/") && f.FileInfo().IsDir() {
+			if !safeBundlePath(strings.TrimSuffix(f.Name, "/")) {
+				return nil, invalidBundle("unsafe path %q", f.Name)
+			}
+			continue
+		}
+		if !safeBundlePath(f.Name) {
+			return nil, invalidBundle("unsafe path %q", f.Name)
+		}
+		if !f.Mode().IsRegular() {
+			return nil, invalidBundle("%s is not a regular file", f.Name)
+		}
+		if _, ok := entries[f.Name]; ok {
+			return nil, invalidBundle("%s appears more than once", f.Name)
+		}
+		entries[f.Name] = f
+	}
+	return entries, nil
+}
+
+// safeBundlePath reports whether a zip entry name is a clean relative path
+// that stays inside the bundle
+func safeBundlePath(name string) bool {
+	if name == "" || strings.ContainsAny(name, "\\\x00") || path.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return false
+	}
+	if path.Clean(name) != name {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." || part == "." || strings.Contains(part, ":") {
+			return false
+		}
+	}
+	return true
+}
+
+// readBundleManifest reads a bundle's manifest and checks that it lists
+// exactly the bundle's other files
+func readBundleManifest(entries map[string]*zip.File) (*models.BundleManifest, error) {
+	f, ok := entries[models.BundleManifestPath]
+	if !ok {
+		return nil, invalidBundle("no manifest")
+	}
+	data, err := readZipFile(f, maxBundleManifestSize)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &models.BundleManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, invalidBundle("manifest: %v", err)
+	}
+	if manifest.SchemaVersion < 1 || manifest.SchemaVersion > models.BundleSchemaVersion {
+		return nil, invalidBundle("schema version %d is not supported, expected at most %d", manifest.SchemaVersion, models.BundleSchemaVersion)
+	}
+
+	listed := map[string]bool{models.BundleManifestPath: true}
+	assetIDs := make(map[string]bool)
+	for _, file := range manifest.Files {
+		if !safeBundlePath(file.Path) {
+			return nil, invalidBundle("unsafe path %q", file.Path)
+		}
+		if listed[file.Path] {
+			return nil, invalidBundle("%s is listed more than once", file.Path)
+		}
+		listed[file.Path] = true
+		entry, ok := entries[file.Path]
+		if !ok {
+			return nil, invalidBundle("%s is missing", file.Path)
+		}
+		if file.Size < 0 || entry.UncompressedSize64 != uint64(file.Size) {
+			return nil, invalidBundle("%s has the wrong size", file.Path)
+		}
+		if file.Kind == "media" {
+			if file.AssetID == "" || assetIDs[file.AssetID] {
+				return nil, invalidBundle("%s needs a unique asset_id", file.Path)
+			}
+			assetIDs[file.AssetID] = true
+		}
+	}
+	for name := range entries {
+		if !listed[name] {
+			return nil, invalidBundle("%s is not in the manifest", name)
+		}
+	}
+	return manifest, nil
+}
+
+// importMedia ingests a bundle's media as the user's assets, keyed by the
+// asset IDs the timeline uses. Nothing is kept if any file fails.
+func (s *BundleService) importMedia(userID string, entries map[string]*zip.File, media []models.BundleFile) (map[string]*models.MediaAsset, error) {
+	if err := os.MkdirAll(s.workDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %v", err)
+	}
+	imported := make(map[string]*models.MediaAsset, len(media))
+	for _, file := range media {
+		filename := file.Filename
+		if filename == "" {
+			filename = path.Base(file.Path)
+		}
+		asset, err := s.importFile(userID, entries[file.Path], file, filename)
+		if err != nil {
+			s.removeImported(userID, imported)
+			return nil, fmt.Errorf("%s: %w", file.Path, err)
+		}
+		imported[file.AssetID] = asset
+	}
+	return imported, nil
+}
+
+func (s *BundleService) importFile(userID string, f *zip.File, file models.BundleFile, filename string) (*models.MediaAsset, error) {
+	tempName, err := StorageFilename(filename)
+	if err != nil {
+		return nil, err
+	}
+	tempPath := filepath.Join(s.workDir, tempName)
+	out, err := os.Create(tempPath)
+	if err != nil {
+		return nil, err
+	}
+	err = copyBundleFile(out, f, file)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+	defer os.Remove(tempPath)
+	return s.assets.IngestFile(userID, filename, tempPath, file.Size, strings.ToLower(file.SHA256))
+}
+
+// removeImported deletes assets created by an import that did not finish
+func (s *BundleService) removeImported(userID string, imported map[string]*models.MediaAsset) {
+	for _, asset := range imported {
+		if _, err := s.assets.DeleteAsset(asset.ID.Hex(), userID, true); err != nil {
+			log.Printf("Warning: failed to remove imported asset %s: %v", asset.ID.Hex(), err)
+		}
+	}
+}
+
+// readBundleFile reads a small bundle file, checking it against the manifest
+func readBundleFile(f *zip.File, file models.BundleFile, limit int64) ([]byte, error) {
+	if file.Size > limit {
+		return nil, invalidBundle("%s is too large", file.Path)
+	}
+	var buf bytes.Buffer
+	if err := copyBundleFile(&buf, f, file); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// copyBundleFile copies a bundle file, failing if it doesn't match the size
+// and checksum in the manifest
+func copyBundleFile(w io.Writer, f *zip.File, file models.BundleFile) error {
+	r, err := f.Open()
+	if err != nil {
+		return invalidBundle("%s: %v", file.Path, err)
+	}
+	defer r.Close()
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), io.LimitReader(r, file.Size+1))
+	if err != nil {
+		return invalidBundle("%s: %v", file.Path, err)
+	}
+	return checkBundleFile(file, n, h)
+}
+
+func checkBundleFile(file models.BundleFile, size int64, h hash.Hash) error {
+	if size != file.Size {
+		return invalidBundle("%s has the wrong size", file.Path)
+	}
+	if !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), file.SHA256) {
+		return invalidBundle("%s does not match its checksum", file.Path)
+	}
+	return nil
+}
+
+// readZipFile reads a zip entry of at most limit bytes
+func readZipFile(f *zip.File, limit int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, invalidBundle("%s is too large", f.Name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, invalidBundle("%s: %v", f.Name, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, invalidBundle("%s: %v", f.Name, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, invalidBundle("%s is too large", f.Name)
+	}
+	return data, nil
+}
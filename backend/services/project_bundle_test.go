@@ -0,0 +1,287 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+
+	"video-editor/models"
+)
+
+func TestWriteBundleRecordsOmittedFonts(t *testing.T) {
+	timeline := &models.Timeline{
+		AspectRatio: "16:9",
+		Duration:    6,
+		MediaItems: []models.TimelineItem{
+			{ID: "t1", Type: "text", Text: "Title", FontFamily: "Roboto", StartTime: 0, EndTime: 2, Duration: 2},
+			{ID: "t2", Type: "text", Text: "Plain", StartTime: 2, EndTime: 4, Duration: 2},
+			{ID: "t3", Type: "text", Text: "Credits", FontFamily: "Roboto", StartTime: 4, EndTime: 6, Duration: 2},
+			{ID: "t4", Type: "text", Text: "Logo", FontFamily: "Lobster", Track: 1, StartTime: 0, EndTime: 6, Duration: 6},
+		},
+	}
+	var buf bytes.Buffer
+	if err := (&BundleService{}).writeBundle(&buf, "Fonts", timeline, nil); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := bundleEntries(archive.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := readBundleManifest(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.SchemaVersion != models.BundleSchemaVersion {
+		t.Errorf("schema version %d", manifest.SchemaVersion)
+	}
+	if len(manifest.Omitted) != 2 {
+		t.Fatalf("omitted %+v, want Roboto and Lobster", manifest.Omitted)
+	}
+	for i, want := range []struct {
+		name  string
+		clips []string
+	}{{"Roboto", []string{"t1", "t3"}}, {"Lobster", []string{"t4"}}} {
+		got := manifest.Omitted[i]
+		if got.Kind != "font" || got.Name != want.name || !slices.Equal(got.ClipIDs, want.clips) || got.Reason == "" {
+			t.Errorf("omitted %d: %+v, want font %s for %v", i, got, want.name, want.clips)
+		}
+	}
+
+	// A bundle without fonts still says so
+	var plain bytes.Buffer
+	if err := (&BundleService{}).writeBundle(&plain, "Plain", &models.Timeline{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	archive, _ = zip.NewReader(bytes.NewReader(plain.Bytes()), int64(plain.Len()))
+	for _, f := range archive.File {
+		if f.Name != models.BundleManifestPath {
+			continue
+		}
+		r, _ := f.Open()
+		var raw map[string]json.RawMessage
+		json.NewDecoder(r).Decode(&raw)
+		r.Close()
+		if string(raw["omitted"]) != "[]" {
+			t.Errorf("omitted is %s, want []", raw["omitted"])
+		}
+	}
+}
+
+// testZipEntry is a file written into a test bundle as given, however unsafe
+type testZipEntry struct {
+	name string
+	mode fs.FileMode
+	data string
+}
+
+func testZip(t *testing.T, entries []testZipEntry) []*zip.File {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		if e.mode != 0 {
+			header.SetMode(e.mode)
+		}
+		w, err := archive.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.data))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Unsafe names are reported with the archive when GODEBUG=zipinsecurepath=0
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		t.Fatal(err)
+	}
+	return r.File
+}
+
+func testBundleFile(path, kind, data string) models.BundleFile {
+	sum := sha256.Sum256([]byte(data))
+	return models.BundleFile{Path: path, Kind: kind, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+}
+
+func TestSafeBundlePath(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"timeline.json", true},
+		{"media/001-clip.mp4", true},
+		{"media/clip with spaces.mov", true},
+		{"", false},
+		{"../evil.mp4", false},
+		{"media/../../evil.mp4", false},
+		{"media/..", false},
+		{"./timeline.json", false},
+		{"media//clip.mp4", false},
+		{"media/", false},
+		{"/etc/passwd", false},
+		{"media\\clip.mp4", false},
+		{"..\\evil.mp4", false},
+		{"C:/Windows/evil.dll", false},
+		{"C:evil.mp4", false},
+		{"media/clip.mp4:stream", false},
+		{"media/clip\x00.mp4", false},
+	}
+	for _, tt := range tests {
+		if got := safeBundlePath(tt.name); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBundleEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testZipEntry
+		err     string // Empty if the entries are accepted
+	}{
+		{"plain", []testZipEntry{{name: "manifest.json"}, {name: "media/"}, {name: "media/a.mp4"}}, ""},
+		{"parent", []testZipEntry{{name: "../a.mp4"}}, "unsafe path"},
+		{"nested parent", []testZipEntry{{name: "media/../../a.mp4"}}, "unsafe path"},
+		{"absolute", []testZipEntry{{name: "/tmp/a.mp4"}}, "unsafe path"},
+		{"backslash", []testZipEntry{{name: "..\\a.mp4"}}, "unsafe path"},
+		{"drive letter", []testZipEntry{{name: "C:/a.mp4"}}, "unsafe path"},
+		{"unsafe directory", []testZipEntry{{name: "../media/"}}, "unsafe path"},
+		{"symlink", []testZipEntry{{name: "media/a.mp4", mode: fs.ModeSymlink | 0777, data: "/etc/passwd"}}, "not a regular file"},
+		{"duplicate", []testZipEntry{{name: "media/a.mp4"}, {name: "media/a.mp4"}}, "more than once"},
+	}
+	for _, tt := range tests {
+		_, err := bundleEntries(testZip(t, tt.entries))
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (!errors.Is(err, ErrInvalidBundle) || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	many := make([]testZipEntry, maxBundleFiles+1)
+	for i := range many {
+		many[i].name = fmt.Sprintf("media/%d.mp4", i)
+	}
+	if _, err := bundleEntries(testZip(t, many)); !errors.Is(err, ErrInvalidBundle) {
+		t.Errorf("%d files: got %v", len(many), err)
+	}
+}
+
+func TestReadBundleManifest(t *testing.T) {
+	const timeline, media = `{"schema_version": 2}`, "not really video"
+	valid := func() *models.BundleManifest {
+		clip := testBundleFile("media/001-a.mp4", "media", media)
+		clip.AssetID = "a1"
+		return &models.BundleManifest{
+			SchemaVersion: models.BundleSchemaVersion,
+			Files:         []models.BundleFile{testBundleFile(models.BundleTimelinePath, "timeline", timeline), clip},
+			Omitted:       []models.BundleOmission{},
+		}
+	}
+	tests := []struct {
+		name   string
+		change func(m *models.BundleManifest)
+		extra  []testZipEntry
+		err    string
+	}{
+		{"valid", func(m *models.BundleManifest) {}, nil, ""},
+		{"version 1", func(m *models.BundleManifest) { m.SchemaVersion = 1 }, nil, ""},
+		{"newer version", func(m *models.BundleManifest) { m.SchemaVersion = models.BundleSchemaVersion + 1 }, nil, "schema version 3 is not supported"},
+		{"no version", func(m *models.BundleManifest) { m.SchemaVersion = 0 }, nil, "schema version 0"},
+		{"unsafe listed path", func(m *models.BundleManifest) { m.Files[1].Path = "../001-a.mp4" }, nil, "unsafe path"},
+		{"listed twice", func(m *models.BundleManifest) { m.Files = append(m.Files, m.Files[1]) }, nil, "listed more than once"},
+		{"missing", func(m *models.BundleManifest) { m.Files[1].Path = "media/002-b.mp4" }, nil, "media/002-b.mp4 is missing"},
+		{"unlisted", func(m *models.BundleManifest) {}, []testZipEntry{{name: "media/extra.mp4", data: "x"}}, "media/extra.mp4 is not in the manifest"},
+		{"size mismatch", func(m *models.BundleManifest) { m.Files[1].Size++ }, nil, "wrong size"},
+		{"negative size", func(m *models.BundleManifest) { m.Files[1].Size = -1 }, nil, "wrong size"},
+		{"no asset id", func(m *models.BundleManifest) { m.Files[1].AssetID = "" }, nil, "unique asset_id"},
+		{"repeated asset id", func(m *models.BundleManifest) {
+			other := testBundleFile("media/extra.mp4", "media", "x")
+			other.AssetID = "a1"
+			m.Files = append(m.Files, other)
+		}, []testZipEntry{{name: "media/extra.mp4", data: "x"}}, "unique asset_id"},
+	}
+	for _, tt := range tests {
+		manifest := valid()
+		tt.change(manifest)
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := append([]testZipEntry{
+			{name: models.BundleTimelinePath, data: timeline},
+			{name: "media/001-a.mp4", data: media},
+			{name: models.BundleManifestPath, data: string(data)},
+		}, tt.extra...)
+		entries, err := bundleEntries(testZip(t, files))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		_, err = readBundleManifest(entries)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (!errors.Is(err, ErrInvalidBundle) || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	entries, _ := bundleEntries(testZip(t, []testZipEntry{{name: models.BundleTimelinePath, data: timeline}}))
+	if _, err := readBundleManifest(entries); err == nil || !strings.Contains(err.Error(), "no manifest") {
+		t.Errorf("no manifest: got %v", err)
+	}
+	entries, _ = bundleEntries(testZip(t, []testZipEntry{{name: models.BundleManifestPath, data: "{"}}))
+	if _, err := readBundleManifest(entries); !errors.Is(err, ErrInvalidBundle) {
+		t.Errorf("malformed manifest: got %v", err)
+	}
+}
+
+func TestReadBundleFileChecksum(t *testing.T) {
+	const data = `{"schema_version": 2}`
+	f := testZip(t, []testZipEntry{{name: models.BundleTimelinePath, data: data}})[0]
+	file := testBundleFile(models.BundleTimelinePath, "timeline", data)
+	got, err := readBundleFile(f, file, maxBundleTimelineSize)
+	if err != nil || string(got) != data {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	tampered := file
+	tampered.SHA256 = strings.Repeat("0", 64)
+	short := file
+	short.Size--
+	long := file
+	long.Size++
+	tests := []struct {
+		name string
+		file models.BundleFile
+		err  string
+	}{
+		{"checksum", tampered, "does not match its checksum"},
+		{"shorter", short, "wrong size"},
+		{"longer", long, "wrong size"},
+	}
+	for _, tt := range tests {
+		_, err := readBundleFile(f, tt.file, maxBundleTimelineSize)
+		if !errors.Is(err, ErrInvalidBundle) || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+	if _, err := readBundleFile(f, file, int64(len(data)-1)); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("over the limit: got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"video-editor/db"
+	"video-editor/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// maxOperationAttempts is how many times an operation is re-applied when
+	// another write to the timeline lands between reading and saving it
+	maxOperationAttempts = 3
+
+	maxOperationListSize = 200
+)
+
+var (
+	// ErrNothingToUndo is returned when a session has no operation left to undo
+	ErrNothingToUndo = errors.New("nothing to undo")
+	// ErrNothingToRedo is returned when a session has no undone operation to redo
+	ErrNothingToRedo = errors.New("nothing to redo")
+)
+
+// ApplyOperation applies an operation to a project's stored timeline, saves
+// it as a new revision and records it in the session's operation log. An
+// operation clears the session's redo history.
+func (s *ProjectService) ApplyOperation(projectID, userID, sessionID string, op models.TimelineOperation) (*models.Project, *models.OperationLogEntry, error) {
+	if sessionID == "" {
+		return nil, nil, errors.New("session_id is required")
+	}
+	project, inverse, err := s.applyToTimeline(projectID, userID, &op)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A new operation forks the history, so undone operations can't be redone
+	_, err = s.operationsCollection.UpdateMany(db.Ctx, bson.M{
+		"project_id": project.ID,
+		"session_id": sessionID,
+		"undone":     true,
+		"discarded":  bson.M{"$ne": true},
+	}, bson.M{"$set": bson.M{"discarded": true}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry := &models.OperationLogEntry{
+		ID:        primitive.NewObjectID(),
+		ProjectID: project.ID,
+		UserID:    userID,
+		SessionID: sessionID,
+		Revision:  project.Revision,
+		Operation: op,
+		Inverse:   *inverse,
+		CreatedAt: time.Now(),
+	}
+	if _, err := s.operationsCollection.InsertOne(db.Ctx, entry); err != nil {
+		return nil, nil, err
+	}
+	return project, entry, nil
+}
+
+// Undo reverts the session's latest operation that is not already undone.
+// The timeline may have changed since; if the operation's inverse no longer
+// applies, an error wrapping ErrInvalidOperation is returned.
+func (s *ProjectService) Undo(projectID, userID, sessionID string) (*models.Project, *models.OperationLogEntry, error) {
+	current, err := s.GetProject(projectID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry := &models.OperationLogEntry{}
+	filter := bson.M{"project_id": current.ID, "session_id": sessionID, "undone": false}
+	opts := options.FindOne().SetSort(bson.D{{Key: "revision", Value: -1}})
+	if err := s.operationsCollection.FindOne(db.Ctx, filter, opts).Decode(entry); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, ErrNothingToUndo
+		}
+		return nil, nil, err
+	}
+
+	inverse := entry.Inverse
+	project, _, err := s.applyToTimeline(projectID, userID, &inverse)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry.Undone, entry.UndoneAt = true, project.Revision
+	update := bson.M{"$set": bson.M{"undone": true, "undone_at": project.Revision}}
+	if _, err := s.operationsCollection.UpdateByID(db.Ctx, entry.ID, update); err != nil {
+		return nil, nil, err
+	}
+	return project, entry, nil
+}
+
+// Redo applies the session's most recently undone operation again
+func (s *ProjectService) Redo(projectID, userID, sessionID string) (*models.Project, *models.OperationLogEntry, error) {
+	current, err := s.GetProject(projectID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry := &models.OperationLogEntry{}
+	filter := bson.M{
+		"project_id": current.ID,
+		"session_id": sessionID,
+		"undone":     true,
+		"discarded":  bson.M{"$ne": true},
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "undone_at", Value: -1}})
+	if err := s.operationsCollection.FindOne(db.Ctx, filter, opts).Decode(entry); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, ErrNothingToRedo
+		}
+		return nil, nil, err
+	}
+
+	op := entry.Operation
+	project, inverse, err := s.applyToTimeline(projectID, userID, &op)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The inverse is worked out again, since other edits may have moved things
+	entry.Undone, entry.UndoneAt = false, 0
+	entry.Revision, entry.Inverse = project.Revision, *inverse
+	update := bson.M{
+		"$set":   bson.M{"undone": false, "revision": project.Revision, "inverse": inverse},
+		"$unset": bson.M{"undone_at": ""},
+	}
+	if _, err := s.operationsCollection.UpdateByID(db.Ctx, entry.ID, update); err != nil {
+		return nil, nil, err
+	}
+	return project, entry, nil
+}
+
+// ListOperations returns a project's most recent logged operations, newest
+// first, optionally only those of one session
+func (s *ProjectService) ListOperations(projectID, userID, sessionID string, limit int) ([]models.OperationLogEntry, error) {
+	project, err := s.GetProject(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > maxOperationListSize {
+		limit = maxOperationListSize
+	}
+	filter := bson.M{"project_id": project.ID}
+	if sessionID != "" {
+		filter["session_id"] = sessionID
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(int64(limit))
+	cursor, err := s.operationsCollection.Find(db.Ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	entries := []models.OperationLogEntry{}
+	if err := cursor.All(db.Ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// applyToTimeline applies an operation to the project's current timeline and
+// saves the result, starting over if another write gets in first
+func (s *ProjectService) applyToTimeline(projectID, userID string, op *models.TimelineOperation) (*models.Project, *models.TimelineOperation, error) {
+	for attempt := 1; ; attempt++ {
+		current, err := s.GetProject(projectID, userID)
+		if err != nil {
+			return nil, nil, err
+		}
+		applied := *op
+		timeline, inverse, err := ApplyOperation(current.Timeline, &applied)
+		if err != nil {
+			return nil, nil, err
+		}
+		project, err := s.SaveTimeline(projectID, userID, current.Revision, timeline, "")
+		var conflict *TimelineConflictError
+		if errors.As(err, &conflict) && attempt < maxOperationAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		*op = applied
+		return project, inverse, nil
+	}
+}
@@ -3,6 +3,10 @@ package services
 import (
 	_ "context"
 	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
 	"time"
 
 	"video-editor/db"
@@ -11,26 +15,93 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const (
+	defaultProjectPageSize = 20
+	maxProjectPageSize     = 100
+)
+
+// ErrProjectNotFound is returned when a project does not exist, belongs to
+// another user or is not in the state an operation needs
+var ErrProjectNotFound = errors.New("project not found or unauthorized")
+
+// projectSortFields are the fields projects can be listed by
+var projectSortFields = map[string]bool{
+	"updated_at": true,
+	"created_at": true,
+	"name":       true,
+	"deleted_at": true,
+}
+
 // ProjectService handles video project CRUD operations
 type ProjectService struct {
-	projectsCollection *mongo.Collection
+	projectsCollection   *mongo.Collection
+	versionsCollection   *mongo.Collection
+	operationsCollection *mongo.Collection
+	trashRetention       time.Duration // How long deleted projects can be restored
+	versionRetention     time.Duration // How long unlabeled snapshots are kept
+}
+
+// ProjectQuery filters, sorts and paginates a project listing
+type ProjectQuery struct {
+	Search  string // Case-insensitive substring of the name
+	Sort    string // One of projectSortFields, "updated_at" by default
+	Desc    bool
+	Trashed bool // List the trash instead of live projects
+	Page    int  // 1-based
+	Limit   int
+}
+
+// ProjectUpdate holds the editable fields of a project. Nil fields are left
+// unchanged. The timeline is not among them: it is only written by
+// SaveTimeline and the other revision-checked writes.
+type ProjectUpdate struct {
+	Name     *string                 `json:"name"`
+	VideoURL *string                 `json:"video_url"`
+	Edits    *[]models.EditOperation `json:"edits"`
 }
 
 // NewProjectService creates a new ProjectService
-func NewProjectService(client *mongo.Client, dbName string) *ProjectService {
+func NewProjectService(client *mongo.Client, dbName string, trashRetention, versionRetention time.Duration) *ProjectService {
 	return &ProjectService{
-		projectsCollection: client.Database(dbName).Collection("projects"),
+		projectsCollection:   client.Database(dbName).Collection("projects"),
+		versionsCollection:   client.Database(dbName).Collection("project_versions"),
+		operationsCollection: client.Database(dbName).Collection("project_operations"),
+		trashRetention:       trashRetention,
+		versionRetention:     versionRetention,
+	}
+}
+
+// liveFilter matches a project the user owns or collaborates on, unless it
+// is in the trash
+func liveFilter(objID primitive.ObjectID, userID string) bson.M {
+	return bson.M{
+		"_id":        objID,
+		"$or":        bson.A{bson.M{"user_id": userID}, bson.M{"collaborators": userID}},
+		"deleted_at": bson.M{"$exists": false},
 	}
 }
 
+// ownedFilter matches a live project only for its owner, for the changes
+// collaborators can't make
+func ownedFilter(objID primitive.ObjectID, userID string) bson.M {
+	return bson.M{"_id": objID, "user_id": userID, "deleted_at": bson.M{"$exists": false}}
+}
+
+// trashedFilter matches a user's project only while it is in the trash
+func trashedFilter(objID primitive.ObjectID, userID string) bson.M {
+	return bson.M{"_id": objID, "user_id": userID, "deleted_at": bson.M{"$exists": true}}
+}
+
 // CreateProject creates a new video project
 func (s *ProjectService) CreateProject(project *models.Project) error {
 	project.ID = primitive.NewObjectID()
 	project.CreatedAt = time.Now()
 	project.UpdatedAt = time.Now()
 	project.Status = "draft" // Initial status
+	project.DeletedAt = nil
 
 	_, err := s.projectsCollection.InsertOne(db.Ctx, project)
 	return err
@@ -44,19 +115,341 @@ func (s *ProjectService) GetProject(projectID string, userID string) (*models.Pr
 	}
 
 	project := &models.Project{}
-	filter := bson.M{"_id": objID, "user_id": userID}
-	err = s.projectsCollection.FindOne(db.Ctx, filter).Decode(project)
+	err = s.projectsCollection.FindOne(db.Ctx, liveFilter(objID, userID)).Decode(project)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrProjectNotFound
+		}
+		return nil, err
+	}
+	return project, nil
+}
+
+// ListProjects returns a page of the user's projects matching the query, along
+// with the total number of matches. Page and Limit are normalized in place.
+func (s *ProjectService) ListProjects(userID string, query *ProjectQuery) ([]models.Project, int64, error) {
+	filter := bson.M{"user_id": userID, "deleted_at": bson.M{"$exists": query.Trashed}}
+	if !query.Trashed {
+		// Projects shared with the user are listed with their own
+		delete(filter, "user_id")
+		filter["$or"] = bson.A{bson.M{"user_id": userID}, bson.M{"collaborators": userID}}
+	}
+	if query.Search != "" {
+		filter["name"] = bson.M{"$regex": regexp.QuoteMeta(query.Search), "$options": "i"}
+	}
+
+	if !projectSortFields[query.Sort] {
+		query.Sort = "updated_at"
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultProjectPageSize
+	}
+	if query.Limit > maxProjectPageSize {
+		query.Limit = maxProjectPageSize
+	}
+	if query.Page < 1 {
+		query.Page = 1
+	}
+
+	total, err := s.projectsCollection.CountDocuments(db.Ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	order := 1
+	if query.Desc {
+		order = -1
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: query.Sort, Value: order}, {Key: "_id", Value: order}}).
+		SetSkip(int64((query.Page - 1) * query.Limit)).
+		SetLimit(int64(query.Limit)).
+		// Timelines can be large and listings do not show them
+		SetProjection(bson.M{"timeline": 0}).
+		// Sort names case-insensitively
+		SetCollation(&options.Collation{Locale: "en", Strength: 2})
+	cursor, err := s.projectsCollection.Find(db.Ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	projects := []models.Project{}
+	if err := cursor.All(db.Ctx, &projects); err != nil {
+		return nil, 0, err
+	}
+	for i := range projects {
+		s.setPurgeAt(&projects[i])
+	}
+	return projects, total, nil
+}
+
+// UpdateProject changes the editable fields of a live project and returns it
+func (s *ProjectService) UpdateProject(projectID, userID string, update ProjectUpdate) (*models.Project, error) {
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		return nil, errors.New("invalid project ID format")
+	}
+
+	set := bson.M{"updated_at": time.Now()}
+	if update.Name != nil {
+		if *update.Name == "" {
+			return nil, errors.New("project name cannot be empty")
+		}
+		set["name"] = *update.Name
+	}
+	if update.VideoURL != nil {
+		set["video_url"] = *update.VideoURL
+	}
+	if update.Edits != nil {
+		set["edits"] = *update.Edits
+	}
+
+	project := &models.Project{}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.projectsCollection.FindOneAndUpdate(db.Ctx, liveFilter(objID, userID), bson.M{"$set": set}, opts).Decode(project)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrProjectNotFound
+		}
+		return nil, err
+	}
+	return project, nil
+}
+
+// DuplicateProject copies a live project's content into a new draft. An empty
+// name defaults to the original's with " (copy)" appended.
+func (s *ProjectService) DuplicateProject(projectID, userID, name string) (*models.Project, error) {
+	original, err := s.GetProject(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = original.Name + " (copy)"
+	}
+
+	duplicate := &models.Project{
+		UserID:   userID,
+		Name:     name,
+		VideoURL: original.VideoURL,
+		Edits:    original.Edits,
+		Timeline: original.Timeline,
+	}
+	if err := s.CreateProject(duplicate); err != nil {
+		return nil, err
+	}
+	return duplicate, nil
+}
+
+// AddCollaborator lets another user open and edit a project. Only the owner
+// can share a project, and collaborators can't trash it.
+func (s *ProjectService) AddCollaborator(projectID, ownerID, collaboratorID string) (*models.Project, error) {
+	if collaboratorID == "" || collaboratorID == ownerID {
+		return nil, errors.New("collaborator must be another user")
+	}
+	return s.updateCollaborators(projectID, ownerID, bson.M{"$addToSet": bson.M{"collaborators": collaboratorID}})
+}
+
+// RemoveCollaborator takes a user's access to a project away again
+func (s *ProjectService) RemoveCollaborator(projectID, ownerID, collaboratorID string) (*models.Project, error) {
+	return s.updateCollaborators(projectID, ownerID, bson.M{"$pull": bson.M{"collaborators": collaboratorID}})
+}
+
+func (s *ProjectService) updateCollaborators(projectID, ownerID string, update bson.M) (*models.Project, error) {
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		return nil, errors.New("invalid project ID format")
+	}
+
+	update["$set"] = bson.M{"updated_at": time.Now()}
+	project := &models.Project{}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.projectsCollection.FindOneAndUpdate(db.Ctx, ownedFilter(objID, ownerID), update, opts).Decode(project)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrProjectNotFound
+		}
+		return nil, err
+	}
+	return project, nil
+}
+
+// TimelineConflictError is returned when a timeline write is based on a
+// revision that has since been replaced
+type TimelineConflictError struct {
+	Current *models.Project // The project as it is now stored
+}
+
+func (e *TimelineConflictError) Error() string {
+	return fmt.Sprintf("timeline has changed since it was loaded, current revision is %d", e.Current.Revision)
+}
+
+// SaveTimeline replaces a project's timeline if it is still at baseRevision,
+// the revision the caller loaded, and returns the project at its new revision.
+// A *TimelineConflictError carrying the stored project is returned otherwise.
+// The new revision is snapshotted with the optional label.
+func (s *ProjectService) SaveTimeline(projectID, userID string, baseRevision int64, timeline *models.Timeline, label string) (*models.Project, error) {
+	return s.saveTimeline(projectID, userID, baseRevision, timeline, label, 0)
+}
+
+func (s *ProjectService) saveTimeline(projectID, userID string, baseRevision int64, timeline *models.Timeline, label string, restoredFrom int64) (*models.Project, error) {
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		return nil, errors.New("invalid project ID format")
+	}
+	if err := ValidateTimeline(timeline); err != nil {
+		return nil, err
+	}
+
+	filter := liveFilter(objID, userID)
+	filter["timeline_revision"] = baseRevision
+	if baseRevision == 0 {
+		// Projects saved before revisions were tracked have none stored
+		filter["timeline_revision"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	update := bson.M{
+		"$set": bson.M{"timeline": timeline, "updated_at": time.Now()},
+		"$inc": bson.M{"timeline_revision": 1},
+	}
+
+	project := &models.Project{}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.projectsCollection.FindOneAndUpdate(db.Ctx, filter, update, opts).Decode(project)
+	if err == mongo.ErrNoDocuments {
+		current, err := s.GetProject(projectID, userID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &TimelineConflictError{Current: current}
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.snapshot(project, userID, label, restoredFrom)
+	return project, nil
+}
+
+// TrashProject moves a live project to the trash, where it can be restored
+// until the retention period runs out
+func (s *ProjectService) TrashProject(projectID, userID string) (*models.Project, error) {
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		return nil, errors.New("invalid project ID format")
+	}
+
+	project := &models.Project{}
+	update := bson.M{"$set": bson.M{"deleted_at": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.projectsCollection.FindOneAndUpdate(db.Ctx, ownedFilter(objID, userID), update, opts).Decode(project)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrProjectNotFound
+		}
+		return nil, err
+	}
+	s.setPurgeAt(project)
+	return project, nil
+}
+
+// RestoreProject takes a project back out of the trash
+func (s *ProjectService) RestoreProject(projectID, userID string) (*models.Project, error) {
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		return nil, errors.New("invalid project ID format")
+	}
+
+	project := &models.Project{}
+	update := bson.M{
+		"$unset": bson.M{"deleted_at": ""},
+		"$set":   bson.M{"updated_at": time.Now()},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.projectsCollection.FindOneAndUpdate(db.Ctx, trashedFilter(objID, userID), update, opts).Decode(project)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("project not found or unauthorized")
+			return nil, ErrProjectNotFound
 		}
 		return nil, err
 	}
 	return project, nil
 }
 
-// UpdateProjectStatus updates the status of a project
-func (s *ProjectService) UpdateProjectStatus(projectID string, status string, outputURL string) error {
+// PurgeProject permanently deletes a project that is already in the trash
+func (s *ProjectService) PurgeProject(projectID, userID string) error {
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		return errors.New("invalid project ID format")
+	}
+
+	result, err := s.projectsCollection.DeleteOne(db.Ctx, trashedFilter(objID, userID))
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrProjectNotFound
+	}
+	if _, err := s.versionsCollection.DeleteMany(db.Ctx, bson.M{"project_id": objID}); err != nil {
+		return err
+	}
+	_, err = s.operationsCollection.DeleteMany(db.Ctx, bson.M{"project_id": objID})
+	return err
+}
+
+// StartTrashWorker periodically purges projects that have been in the trash
+// longer than the retention period
+func (s *ProjectService) StartTrashWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.purgeExpired(); err != nil {
+			log.Printf("Failed to purge trashed projects: %v", err)
+		}
+	}
+}
+
+// purgeExpired deletes every project trashed before the retention period
+func (s *ProjectService) purgeExpired() error {
+	cutoff := time.Now().Add(-s.trashRetention)
+	filter := bson.M{"deleted_at": bson.M{"$lt": cutoff}}
+	cursor, err := s.projectsCollection.Find(db.Ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var expired []models.Project
+	if err := cursor.All(db.Ctx, &expired); err != nil {
+		return err
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	ids := make([]primitive.ObjectID, len(expired))
+	for i, project := range expired {
+		ids[i] = project.ID
+	}
+
+	result, err := s.projectsCollection.DeleteMany(db.Ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	if _, err := s.versionsCollection.DeleteMany(db.Ctx, bson.M{"project_id": bson.M{"$in": ids}}); err != nil {
+		return err
+	}
+	if _, err := s.operationsCollection.DeleteMany(db.Ctx, bson.M{"project_id": bson.M{"$in": ids}}); err != nil {
+		return err
+	}
+	log.Printf("Purged %d projects trashed before %s", result.DeletedCount, cutoff.Format(time.RFC3339))
+	return nil
+}
+
+// setPurgeAt fills in when a trashed project will be purged
+func (s *ProjectService) setPurgeAt(project *models.Project) {
+	if project.DeletedAt == nil {
+		return
+	}
+	purgeAt := project.DeletedAt.Add(s.trashRetention)
+	project.PurgeAt = &purgeAt
+}
+
+// UpdateProjectStatus updates the status of a user's project
+func (s *ProjectService) UpdateProjectStatus(projectID, userID, status, outputURL string) error {
 	objID, err := primitive.ObjectIDFromHex(projectID)
 	if err != nil {
 		return errors.New("invalid project ID format")
@@ -69,21 +462,25 @@ func (s *ProjectService) UpdateProjectStatus(projectID string, status string, ou
 			"updated_at": time.Now(),
 		},
 	}
-	_, err = s.projectsCollection.UpdateByID(db.Ctx, objID, update)
+	_, err = s.projectsCollection.UpdateOne(db.Ctx, liveFilter(objID, userID), update)
 	return err
 }
 
-// AddEditToProject adds an editing operation to a project
-func (s *ProjectService) AddEditToProject(projectID string, edit models.EditOperation) error {
-	objID, err := primitive.ObjectIDFromHex(projectID)
+// AppendTimelineItems adds clips to the end of a project's timeline as it
+// was at baseRevision, extending its duration to cover them. Like
+// SaveTimeline, it returns a *TimelineConflictError if the timeline has been
+// saved since.
+func (s *ProjectService) AppendTimelineItems(projectID, userID string, baseRevision int64, items []models.TimelineItem) (*models.Project, error) {
+	project, err := s.GetProject(projectID, userID)
 	if err != nil {
-		return errors.New("invalid project ID format")
+		return nil, err
 	}
-
-	update := bson.M{
-		"$push": bson.M{"edits": edit},
-		"$set":  bson.M{"updated_at": time.Now()},
+	if project.Revision != baseRevision {
+		return nil, &TimelineConflictError{Current: project}
 	}
-	_, err = s.projectsCollection.UpdateByID(db.Ctx, objID, update)
-	return err
+
+	timeline := cloneTimeline(project.Timeline)
+	timeline.MediaItems = append(timeline.MediaItems, items...)
+	timeline.Duration = math.Max(timeline.Duration, contentEnd(timeline))
+	return s.SaveTimeline(projectID, userID, baseRevision, timeline, "")
 }
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"video-editor/db"
+	"video-editor/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultVersionPageSize = 50
+	maxVersionPageSize     = 200
+
+	// Unlabeled snapshots are all kept for versionKeepAll, then thinned to
+	// the newest of each hour until versionKeepHourly, then to the newest of
+	// each day until the retention period ends
+	versionKeepAll    = 24 * time.Hour
+	versionKeepHourly = 7 * 24 * time.Hour
+)
+
+// ErrVersionNotFound is returned when a project has no snapshot at a revision
+var ErrVersionNotFound = errors.New("version not found")
+
+// snapshot records the project's current timeline as an immutable version and
+// thins older snapshots. Failures are logged rather than failing the save.
+func (s *ProjectService) snapshot(project *models.Project, authorID, label string, restoredFrom int64) {
+	version := &models.ProjectVersion{
+		ID:           primitive.NewObjectID(),
+		ProjectID:    project.ID,
+		Revision:     project.Revision,
+		AuthorID:     authorID,
+		Label:        label,
+		RestoredFrom: restoredFrom,
+		Timeline:     project.Timeline,
+		CreatedAt:    time.Now(),
+	}
+	if _, err := s.versionsCollection.InsertOne(db.Ctx, version); err != nil {
+		log.Printf("Failed to snapshot project %s at revision %d: %v", project.ID.Hex(), project.Revision, err)
+		return
+	}
+	if err := s.thinVersions(project.ID, project.Revision); err != nil {
+		log.Printf("Failed to thin versions of project %s: %v", project.ID.Hex(), err)
+	}
+}
+
+// ListVersions returns a page of a project's snapshots, newest first and
+// without their timelines, along with the total number of snapshots
+func (s *ProjectService) ListVersions(projectID, userID string, page, limit int) ([]models.ProjectVersion, int64, error) {
+	project, err := s.GetProject(projectID, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if limit <= 0 {
+		limit = defaultVersionPageSize
+	}
+	if limit > maxVersionPageSize {
+		limit = maxVersionPageSize
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	filter := bson.M{"project_id": project.ID}
+	total, err := s.versionsCollection.CountDocuments(db.Ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "revision", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"timeline": 0})
+	cursor, err := s.versionsCollection.Find(db.Ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	versions := []models.ProjectVersion{}
+	if err := cursor.All(db.Ctx, &versions); err != nil {
+		return nil, 0, err
+	}
+	return versions, total, nil
+}
+
+// GetVersion returns a project's snapshot at a revision, including its timeline
+func (s *ProjectService) GetVersion(projectID, userID string, revision int64) (*models.ProjectVersion, error) {
+	project, err := s.GetProject(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.getVersion(project.ID, revision)
+}
+
+func (s *ProjectService) getVersion(projectID primitive.ObjectID, revision int64) (*models.ProjectVersion, error) {
+	version := &models.ProjectVersion{}
+	err := s.versionsCollection.FindOne(db.Ctx, bson.M{"project_id": projectID, "revision": revision}).Decode(version)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrVersionNotFound
+		}
+		return nil, err
+	}
+	return version, nil
+}
+
+// LabelVersion names a snapshot, which also keeps it from being thinned. An
+// empty label makes it an ordinary snapshot again.
+func (s *ProjectService) LabelVersion(projectID, userID string, revision int64, label string) (*models.ProjectVersion, error) {
+	project, err := s.GetProject(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	update := bson.M{"$set": bson.M{"label": label}}
+	if label == "" {
+		update = bson.M{"$unset": bson.M{"label": ""}}
+	}
+	version := &models.ProjectVersion{}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"timeline": 0})
+	filter := bson.M{"project_id": project.ID, "revision": revision}
+	if err := s.versionsCollection.FindOneAndUpdate(db.Ctx, filter, update, opts).Decode(version); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrVersionNotFound
+		}
+		return nil, err
+	}
+	return version, nil
+}
+
+// DiffVersions compares the timelines of two snapshots clip by clip. A to
+// revision of 0 compares against the project's current timeline.
+func (s *ProjectService) DiffVersions(projectID, userID string, from, to int64) (*TimelineDiff, error) {
+	project, err := s.GetProject(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	before, err := s.getVersion(project.ID, from)
+	if err != nil {
+		return nil, err
+	}
+	after := project.Timeline
+	if to == 0 {
+		to = project.Revision
+	} else {
+		version, err := s.getVersion(project.ID, to)
+		if err != nil {
+			return nil, err
+		}
+		after = version.Timeline
+	}
+	diff := DiffTimelines(before.Timeline, after)
+	diff.From, diff.To = from, to
+	return diff, nil
+}
+
+// RestoreVersion makes a snapshot's timeline current again as a new revision,
+// leaving the history in between intact
+func (s *ProjectService) RestoreVersion(projectID, userID string, revision int64) (*models.Project, error) {
+	project, err := s.GetProject(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	version, err := s.getVersion(project.ID, revision)
+	if err != nil {
+		return nil, err
+	}
+	timeline := version.Timeline
+	if timeline == nil {
+		timeline = &models.Timeline{MediaItems: []models.TimelineItem{}}
+	}
+	return s.saveTimeline(projectID, userID, project.Revision, timeline, "", revision)
+}
+
+// thinVersions deletes the unlabeled snapshots of a project that the
+// retention policy no longer keeps
+func (s *ProjectService) thinVersions(projectID primitive.ObjectID, latest int64) error {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "revision", Value: -1}}).
+		SetProjection(bson.M{"timeline": 0})
+	cursor, err := s.versionsCollection.Find(db.Ctx, bson.M{"project_id": projectID, "label": bson.M{"$exists": false}}, opts)
+	if err != nil {
+		return err
+	}
+	var versions []models.ProjectVersion
+	if err := cursor.All(db.Ctx, &versions); err != nil {
+		return err
+	}
+
+	stale := versionsToThin(versions, latest, time.Now(), s.versionRetention)
+	if len(stale) == 0 {
+		return nil
+	}
+	_, err = s.versionsCollection.DeleteMany(db.Ctx, bson.M{"_id": bson.M{"$in": stale}})
+	return err
+}
+
+// versionsToThin picks the snapshots to delete from versions sorted newest
+// first. Within each hour or day bucket the newest snapshot survives;
+// labeled snapshots and the latest revision are always kept.
+func versionsToThin(versions []models.ProjectVersion, latest int64, now time.Time, retention time.Duration) []primitive.ObjectID {
+	type bucketKey struct {
+		size  time.Duration
+		start time.Time
+	}
+	var stale []primitive.ObjectID
+	kept := make(map[bucketKey]bool)
+	for _, v := range versions {
+		if v.Label != "" || v.Revision == latest {
+			continue
+		}
+		age := now.Sub(v.CreatedAt)
+		var bucket bucketKey
+		switch {
+		case age > retention:
+			stale = append(stale, v.ID)
+			continue
+		case age <= versionKeepAll:
+			continue
+		case age <= versionKeepHourly:
+			bucket = bucketKey{time.Hour, v.CreatedAt.Truncate(time.Hour)}
+		default:
+			bucket = bucketKey{24 * time.Hour, v.CreatedAt.Truncate(24 * time.Hour)}
+		}
+		if kept[bucket] {
+			stale = append(stale, v.ID)
+			continue
+		}
+		kept[bucket] = true
+	}
+	return stale
+}
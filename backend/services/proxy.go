@@ -0,0 +1,60 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"video-editor/models"
+	"video-editor/storage"
+)
+
+const (
+	// proxyHeight is the maximum height of preview proxies
+	proxyHeight = 540
+	// proxyGOP is the keyframe interval of proxies in frames. Short GOPs keep
+	// seeking in the browser responsive.
+	proxyGOP = 15
+)
+
+// generateProxy transcodes a video asset to a small, keyframe-dense H.264 file
+// for smooth preview in the editor. Exports keep reading the original.
+func (vp *VideoProcessor) generateProxy(asset *models.MediaAsset) (string, error) {
+	if asset.Type != "video" || !hasVideoStream(asset.Metadata) {
+		return "", errNoVideoStream
+	}
+
+	inputPath, cleanup, err := storage.Fetch(vp.store, asset.StorageKey, vp.workDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to stage asset: %v", err)
+	}
+	defer cleanup()
+
+	if err := os.MkdirAll(vp.workDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create work directory: %v", err)
+	}
+	outputPath := filepath.Join(vp.workDir, fmt.Sprintf("proxy_%s.mp4", asset.ID.Hex()))
+	defer os.Remove(outputPath)
+
+	if err := runFFmpeg(proxyArgs(inputPath, outputPath)); err != nil {
+		return "", fmt.Errorf("ffmpeg proxy failed: %v", err)
+	}
+	return vp.storeDerived(asset, models.DerivedProxy, outputPath, "proxy.mp4")
+}
+
+// proxyArgs builds the ffmpeg arguments for a proxy. Sources smaller than
+// proxyHeight are not upscaled.
+func proxyArgs(inputPath, outputPath string) []string {
+	gop := strconv.Itoa(proxyGOP)
+	return []string{
+		"-i", inputPath,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", fmt.Sprintf("scale=-2:'min(%d,ih)'", proxyHeight),
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "28", "-pix_fmt", "yuv420p",
+		"-g", gop, "-keyint_min", gop, "-sc_threshold", "0",
+		"-c:a", "aac", "-b:a", "96k", "-ac", "2",
+		"-movflags", "+faststart",
+		"-y", outputPath,
+	}
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"fmt"
+
+	"video-editor/db"
+	"video-editor/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// QuotaExceededError is returned when an upload or export would exceed a user's quota
+type QuotaExceededError struct {
+	QuotaBytes     int64
+	UsedBytes      int64
+	RequestedBytes int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("storage quota exceeded: %d of %d bytes used, %d more requested",
+		e.UsedBytes, e.QuotaBytes, e.RequestedBytes)
+}
+
+// StorageUsage breaks down how much storage a user occupies
+type StorageUsage struct {
+	QuotaBytes     int64 `json:"quota_bytes"` // 0 means unlimited
+	UsedBytes      int64 `json:"used_bytes"`
+	RemainingBytes int64 `json:"remaining_bytes,omitempty"`
+	UploadsBytes   int64 `json:"uploads_bytes"` // Deduplicated original uploads
+	ExportsBytes   int64 `json:"exports_bytes"`
+	DerivedBytes   int64 `json:"derived_bytes"` // Proxies, thumbnails, waveforms
+}
+
+// QuotaService computes storage usage and enforces per-user quotas. Users get
+// the default quota unless an override exists in the user_quotas collection.
+type QuotaService struct {
+	quotasCollection *mongo.Collection
+	blobsCollection  *mongo.Collection
+	assetsCollection *mongo.Collection
+	store            storage.Backend
+	defaultQuota     int64
+}
+
+// NewQuotaService creates a new QuotaService. A defaultQuota of 0 disables quotas.
+func NewQuotaService(client *mongo.Client, dbName string, store storage.Backend, defaultQuota int64) *QuotaService {
+	return &QuotaService{
+		quotasCollection: client.Database(dbName).Collection("user_quotas"),
+		blobsCollection:  client.Database(dbName).Collection("media_blobs"),
+		assetsCollection: client.Database(dbName).Collection("media_assets"),
+		store:            store,
+		defaultQuota:     defaultQuota,
+	}
+}
+
+// GetQuota returns the user's quota in bytes, 0 meaning unlimited
+func (s *QuotaService) GetQuota(userID string) (int64, error) {
+	var override struct {
+		QuotaBytes int64 `bson:"quota_bytes"`
+	}
+	err := s.quotasCollection.FindOne(db.Ctx, bson.M{"user_id": userID}).Decode(&override)
+	if err == mongo.ErrNoDocuments {
+		return s.defaultQuota, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return override.QuotaBytes, nil
+}
+
+// Usage computes the user's current storage usage
+func (s *QuotaService) Usage(userID string) (*StorageUsage, error) {
+	quota, err := s.GetQuota(userID)
+	if err != nil {
+		return nil, err
+	}
+	usage := &StorageUsage{QuotaBytes: quota}
+
+	if usage.UploadsBytes, err = s.sum(s.blobsCollection, userID, "$size", nil); err != nil {
+		return nil, err
+	}
+	unwindDerived := bson.D{{Key: "$unwind", Value: "$derived"}}
+	if usage.DerivedBytes, err = s.sum(s.assetsCollection, userID, "$derived.size", unwindDerived); err != nil {
+		return nil, err
+	}
+	if usage.ExportsBytes, err = storage.TotalSize(s.store, userID+"/exports/"); err != nil {
+		return nil, err
+	}
+
+	usage.UsedBytes = usage.UploadsBytes + usage.ExportsBytes + usage.DerivedBytes
+	if quota > 0 && quota > usage.UsedBytes {
+		usage.RemainingBytes = quota - usage.UsedBytes
+	}
+	return usage, nil
+}
+
+// CheckAvailable returns a *QuotaExceededError if storing additional bytes
+// would put the user over quota
+func (s *QuotaService) CheckAvailable(userID string, additional int64) error {
+	quota, err := s.GetQuota(userID)
+	if err != nil || quota == 0 {
+		return err
+	}
+	usage, err := s.Usage(userID)
+	if err != nil {
+		return err
+	}
+	if usage.UsedBytes+additional > quota {
+		return &QuotaExceededError{QuotaBytes: quota, UsedBytes: usage.UsedBytes, RequestedBytes: additional}
+	}
+	return nil
+}
+
+// sum adds up a numeric field over the user's documents in a collection
+func (s *QuotaService) sum(collection *mongo.Collection, userID, field string, unwind bson.D) (int64, error) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"user_id": userID}}}}
+	if unwind != nil {
+		pipeline = append(pipeline, unwind)
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": field}}}})
+
+	cursor, err := collection.Aggregate(db.Ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	var result []struct {
+		Total int64 `bson:"total"`
+	}
+	if err := cursor.All(db.Ctx, &result); err != nil {
+		return 0, err
+	}
+	if len(result) == 0 {
+		return 0, nil
+	}
+	return result[0].Total, nil
+}
@@ -0,0 +1,389 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"video-editor/db"
+	"video-editor/models"
+	"video-editor/storage"
+	"video-editor/websocket"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxBatchRows caps how many exports one batch can queue
+const maxBatchRows = 500
+
+// ErrBatchNotFound is returned when a batch doesn't exist or belongs to another user
+var ErrBatchNotFound = errors.New("batch not found or unauthorized")
+
+// unsafeFileChars are replaced in row names used as file names
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// ParseBatchRows reads the slot values of a batch, one map per row. data is
+// either a JSON array of objects or a CSV file whose header row names the
+// slots. JSON numbers and booleans are used as written.
+func ParseBatchRows(data []byte) ([]map[string]string, error) {
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	var rows []map[string]string
+	var err error
+	if bytes.HasPrefix(data, []byte("[")) {
+		rows, err = parseJSONRows(data)
+	} else {
+		rows, err = parseCSVRows(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no rows to render")
+	}
+	if len(rows) > maxBatchRows {
+		return nil, fmt.Errorf("a batch can have at most %d rows", maxBatchRows)
+	}
+	return rows, nil
+}
+
+func parseJSONRows(data []byte) ([]map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw []map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON rows: %v", err)
+	}
+	rows := make([]map[string]string, len(raw))
+	for i, object := range raw {
+		rows[i] = make(map[string]string, len(object))
+		for key, value := range object {
+			switch v := value.(type) {
+			case nil:
+			case string:
+				rows[i][key] = v
+			case json.Number, bool:
+				rows[i][key] = fmt.Sprint(v)
+			default:
+				return nil, fmt.Errorf("row %d: %s must be a string or number", i+1, key)
+			}
+		}
+	}
+	return rows, nil
+}
+
+func parseCSVRows(data []byte) ([]map[string]string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(header))
+		for i, key := range header {
+			row[key] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// StartBatch fills the template once per row and queues an export of each.
+// A row that can't be filled, e.g. because an asset is missing, fails on its
+// own without stopping the others. A "name" value names the row's output file.
+func (vp *VideoProcessor) StartBatch(userID, name string, template *models.ProjectTemplate, rows []map[string]string, settings ExportSettings) (*models.RenderBatch, error) {
+	if err := settings.Normalize(); err != nil {
+		return nil, err
+	}
+	// Every row would fail the same way
+	if err := CheckTemplateMedia(template, userID); err != nil {
+		return nil, err
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		name = template.Name
+	}
+	now := time.Now()
+	batch := &models.RenderBatch{
+		ID:         primitive.NewObjectID(),
+		UserID:     userID,
+		TemplateID: template.ID.Hex(),
+		Name:       name,
+		Status:     "processing",
+		Total:      len(rows),
+		Rows:       make([]models.BatchRow, len(rows)),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	var jobs []models.VideoProcessingJob
+	for i, values := range rows {
+		rowName := values["name"]
+		if rowName == "" {
+			rowName = fmt.Sprintf("row-%d", i+1)
+		}
+		row := models.BatchRow{
+			Index:  i,
+			Name:   fmt.Sprintf("%03d-%s.%s", i+1, unsafeFileChars.ReplaceAllString(rowName, "_"), settings.Format),
+			Values: values,
+			Status: "pending",
+		}
+		timeline, err := vp.fillBatchRow(userID, template, values)
+		if err != nil {
+			row.Status, row.Error = "failed", err.Error()
+			batch.Failed++
+			batch.Rows[i] = row
+			continue
+		}
+		job := models.VideoProcessingJob{
+			ID:     primitive.NewObjectID(),
+			UserID: userID,
+			Action: "export",
+			Params: map[string]interface{}{
+				"projectData": timeline,
+				"settings":    settings,
+				"batch_id":    batch.ID,
+				"batch_row":   i,
+			},
+			Status:    "pending",
+			CreatedAt: now,
+		}
+		row.JobID = job.ID.Hex()
+		batch.Rows[i] = row
+		jobs = append(jobs, job)
+	}
+	if len(jobs) == 0 {
+		batch.Status, batch.FinishedAt = "failed", &now
+	}
+	if _, err := vp.batchesCollection.InsertOne(db.Ctx, batch); err != nil {
+		return nil, err
+	}
+
+	// The queue is bounded, so a large batch is fed in as the worker drains it
+	go func() {
+		for _, job := range jobs {
+			AddJobToQueue(job)
+		}
+	}()
+	vp.fillBatchURLs(batch)
+	return batch, nil
+}
+
+// fillBatchRow fills a template's slots with one row of values. Values of
+// asset slots are the IDs of the user's assets.
+func (vp *VideoProcessor) fillBatchRow(userID string, template *models.ProjectTemplate, values map[string]string) (*models.Timeline, error) {
+	texts := make(map[string]string)
+	assets := make(map[string]*models.MediaAsset)
+	for _, slot := range template.Slots {
+		value := values[slot.Name]
+		if value == "" {
+			continue
+		}
+		if slot.Kind == models.SlotText {
+			texts[slot.Name] = value
+			continue
+		}
+		asset, err := vp.assets.GetAsset(value, userID)
+		if err != nil {
+			return nil, fmt.Errorf("slot %s: %w", slot.Name, err)
+		}
+		assets[slot.Name] = asset
+	}
+	return FillTemplate(template, texts, assets)
+}
+
+// finishBatchRow records the outcome of an export that belongs to a batch.
+// Once every row is done the completed outputs are zipped.
+func (vp *VideoProcessor) finishBatchRow(hub *websocket.Hub, job models.VideoProcessingJob, outputURL string, jobErr error) {
+	batchID, ok := job.Params["batch_id"].(primitive.ObjectID)
+	if !ok {
+		return
+	}
+	index, _ := job.Params["batch_row"].(int)
+	prefix := fmt.Sprintf("rows.%d.", index)
+	set := bson.M{"updated_at": time.Now()}
+	inc := bson.M{}
+	if jobErr != nil {
+		set[prefix+"status"], set[prefix+"error"] = "failed", jobErr.Error()
+		inc["failed"] = 1
+	} else {
+		key, _ := vp.store.KeyFromURL(outputURL)
+		set[prefix+"status"], set[prefix+"output_key"] = "completed", key
+		inc["completed"] = 1
+	}
+
+	batch := &models.RenderBatch{}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := vp.batchesCollection.FindOneAndUpdate(db.Ctx, bson.M{"_id": batchID}, bson.M{"$set": set, "$inc": inc}, opts).Decode(batch)
+	if err != nil {
+		log.Printf("Failed to update batch %s row %d: %v", batchID.Hex(), index, err)
+		return
+	}
+	done := batch.Completed + batch.Failed
+	hub.BroadcastToUser(batch.UserID, fmt.Sprintf("Batch %s: %d/%d rows done", batch.ID.Hex(), done, batch.Total))
+	if done < batch.Total {
+		return
+	}
+
+	if err := vp.finishBatch(batch); err != nil {
+		log.Printf("Failed to finish batch %s: %v", batch.ID.Hex(), err)
+		hub.BroadcastToUser(batch.UserID, fmt.Sprintf("Batch %s: Failed! %v", batch.ID.Hex(), err))
+		return
+	}
+	vp.fillBatchURLs(batch)
+	hub.BroadcastToUser(batch.UserID, fmt.Sprintf("Batch %s: Completed! Output: %s", batch.ID.Hex(), batch.ZipURL))
+}
+
+// finishBatch zips the completed outputs of a batch and sets its final status
+func (vp *VideoProcessor) finishBatch(batch *models.RenderBatch) error {
+	now := time.Now()
+	batch.FinishedAt = &now
+	switch {
+	case batch.Completed == 0:
+		batch.Status = "failed"
+	case batch.Failed > 0:
+		batch.Status = "completed_with_errors"
+	default:
+		batch.Status = "completed"
+	}
+	set := bson.M{"status": batch.Status, "finished_at": now, "updated_at": now}
+	if batch.Completed > 0 {
+		key, err := vp.zipBatch(batch)
+		if err != nil {
+			batch.Status = "failed"
+			set["status"] = batch.Status
+			vp.batchesCollection.UpdateByID(db.Ctx, batch.ID, bson.M{"$set": set})
+			return err
+		}
+		batch.ZipKey = key
+		set["zip_key"] = key
+	}
+	_, err := vp.batchesCollection.UpdateByID(db.Ctx, batch.ID, bson.M{"$set": set})
+	return err
+}
+
+// zipBatch archives the completed outputs of a batch into the user's exports.
+// Videos are already compressed, so they are stored as they are.
+func (vp *VideoProcessor) zipBatch(batch *models.RenderBatch) (string, error) {
+	if err := os.MkdirAll(vp.workDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create work directory: %v", err)
+	}
+	name := fmt.Sprintf("batch_%s.zip", batch.ID.Hex())
+	localPath := filepath.Join(vp.workDir, name)
+	defer os.Remove(localPath)
+
+	file, err := os.Create(localPath)
+	if err != nil {
+		return "", err
+	}
+	archive := zip.NewWriter(file)
+	for _, row := range batch.Rows {
+		if row.Status != "completed" || row.OutputKey == "" {
+			continue
+		}
+		if err := addToZip(vp.store, archive, row.OutputKey, row.Name); err != nil {
+			archive.Close()
+			file.Close()
+			return "", fmt.Errorf("failed to add %s: %v", row.Name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	key := batch.UserID + "/exports/" + name
+	if err := storage.PutFile(vp.store, key, localPath); err != nil {
+		return "", fmt.Errorf("failed to store zip: %v", err)
+	}
+	return key, nil
+}
+
+func addToZip(store storage.Backend, archive *zip.Writer, key, name string) error {
+	r, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// GetBatch returns one of the user's batches with its per-row status
+func (vp *VideoProcessor) GetBatch(batchID, userID string) (*models.RenderBatch, error) {
+	objID, err := primitive.ObjectIDFromHex(batchID)
+	if err != nil {
+		return nil, errors.New("invalid batch ID format")
+	}
+	batch := &models.RenderBatch{}
+	if err := vp.batchesCollection.FindOne(db.Ctx, bson.M{"_id": objID, "user_id": userID}).Decode(batch); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrBatchNotFound
+		}
+		return nil, err
+	}
+	vp.fillBatchURLs(batch)
+	return batch, nil
+}
+
+// ListBatches returns the user's most recent batches without their rows
+func (vp *VideoProcessor) ListBatches(userID string) ([]models.RenderBatch, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(50).
+		SetProjection(bson.M{"rows": 0})
+	cursor, err := vp.batchesCollection.Find(db.Ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	batches := []models.RenderBatch{}
+	if err := cursor.All(db.Ctx, &batches); err != nil {
+		return nil, err
+	}
+	for i := range batches {
+		vp.fillBatchURLs(&batches[i])
+	}
+	return batches, nil
+}
+
+// fillBatchURLs computes a batch's progress and signs its output URLs
+func (vp *VideoProcessor) fillBatchURLs(batch *models.RenderBatch) {
+	if batch.Total > 0 {
+		batch.Progress = roundMillis(float64(batch.Completed+batch.Failed) * 100 / float64(batch.Total))
+	}
+	if batch.ZipKey != "" {
+		batch.ZipURL, _ = vp.store.SignedURL(batch.ZipKey, signedURLExpiry)
+	}
+	for i := range batch.Rows {
+		if key := batch.Rows[i].OutputKey; key != "" {
+			batch.Rows[i].OutputURL, _ = vp.store.SignedURL(key, signedURLExpiry)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"video-editor/db"
+	"video-editor/models"
+	"video-editor/storage"
+	"video-editor/websocket"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseBatchRows(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []map[string]string
+		err  string // Empty if the rows parse
+	}{
+		{"json", `[{"name": "intro", "title": "Hello", "count": 3, "live": true, "logo": null}]`,
+			[]map[string]string{{"name": "intro", "title": "Hello", "count": "3", "live": "true"}}, ""},
+		{"json big number", `[{"id": 12345678901234567890}]`, []map[string]string{{"id": "12345678901234567890"}}, ""},
+		{"csv", "name, title\nintro,\"Hello, world\"\noutro, Bye\n",
+			[]map[string]string{{"name": "intro", "title": "Hello, world"}, {"name": "outro", "title": "Bye"}}, ""},
+		{"csv with bom", "\xef\xbb\xbf title \r\nHello\r\n", []map[string]string{{"title": "Hello"}}, ""},
+		{"json with bom", "\xef\xbb\xbf [{\"title\": \"Hello\"}]", []map[string]string{{"title": "Hello"}}, ""},
+		{"empty", "", nil, "no rows"},
+		{"header only", "name,title\n", nil, "no rows"},
+		{"empty json", "[]", nil, "no rows"},
+		{"nested value", `[{"title": {"text": "Hello"}}]`, nil, "row 1: title must be a string or number"},
+		{"invalid json", `[{"title": "Hello"`, nil, "invalid JSON rows"},
+		{"ragged csv", "name,title\nintro\n", nil, "invalid CSV"},
+		{"too many", "name\n" + strings.Repeat("row\n", maxBatchRows+1), nil, fmt.Sprintf("at most %d rows", maxBatchRows)},
+	}
+	for _, tt := range tests {
+		rows, err := ParseBatchRows([]byte(tt.data))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.EqualFunc(rows, tt.want, maps.Equal) {
+			t.Errorf("%s: got %v, want %v", tt.name, rows, tt.want)
+		}
+	}
+}
+
+// testBatch stores a batch of rows, each with an output in the store unless
+// its name is empty
+func testBatch(t *testing.T, vp *VideoProcessor, names ...string) *models.RenderBatch {
+	t.Helper()
+	batch := &models.RenderBatch{
+		ID:        primitive.NewObjectID(),
+		UserID:    "alice",
+		Status:    "processing",
+		Total:     len(names),
+		CreatedAt: time.Now(),
+	}
+	for i, name := range names {
+		row := models.BatchRow{Index: i, Name: name, Status: "pending"}
+		if name != "" {
+			row.OutputKey = fmt.Sprintf("alice/exports/export_%d.mp4", i)
+			content := "video " + name
+			if err := vp.store.Put(row.OutputKey, strings.NewReader(content), int64(len(content)), "video/mp4"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		batch.Rows = append(batch.Rows, row)
+	}
+	if _, err := vp.batchesCollection.InsertOne(db.Ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	return batch
+}
+
+func testVideoProcessor(t *testing.T) *VideoProcessor {
+	t.Helper()
+	client, name := testDatabase(t)
+	store, _ := testStore(t)
+	return NewVideoProcessor(client, name, store, nil, t.TempDir())
+}
+
+// zipContents reads the files of an archive in storage
+func zipContents(t *testing.T, store storage.Backend, key string) map[string]string {
+	t.Helper()
+	r, err := store.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range archive.File {
+		fr, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(fr)
+		fr.Close()
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func TestFinishBatch(t *testing.T) {
+	vp := testVideoProcessor(t)
+	tests := []struct {
+		name      string
+		rows      []string // Output names, empty for a row without output
+		completed int
+		failed    int
+		status    string
+		zipped    map[string]string // Nil when there is no zip
+		err       bool
+	}{
+		{"all completed", []string{"a.mp4", "b.mp4"}, 2, 0, "completed",
+			map[string]string{"a.mp4": "video a.mp4", "b.mp4": "video b.mp4"}, false},
+		{"some failed", []string{"a.mp4", ""}, 1, 1, "completed_with_errors",
+			map[string]string{"a.mp4": "video a.mp4"}, false},
+		{"all failed", []string{"", ""}, 0, 2, "failed", nil, false},
+	}
+	for _, tt := range tests {
+		batch := testBatch(t, vp, tt.rows...)
+		for i := range batch.Rows {
+			if batch.Rows[i].OutputKey != "" {
+				batch.Rows[i].Status = "completed"
+			} else {
+				batch.Rows[i].Status = "failed"
+			}
+		}
+		batch.Completed, batch.Failed = tt.completed, tt.failed
+		if err := vp.finishBatch(batch); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		stored := &models.RenderBatch{}
+		if err := vp.batchesCollection.FindOne(db.Ctx, bson.M{"_id": batch.ID}).Decode(stored); err != nil {
+			t.Fatal(err)
+		}
+		if batch.Status != tt.status || stored.Status != tt.status || stored.FinishedAt == nil {
+			t.Errorf("%s: status %q, stored %q finished %v, want %q", tt.name, batch.Status, stored.Status, stored.FinishedAt, tt.status)
+		}
+		if tt.zipped == nil {
+			if stored.ZipKey != "" {
+				t.Errorf("%s: zipped a batch with no outputs", tt.name)
+			}
+			continue
+		}
+		if stored.ZipKey == "" || stored.ZipKey != batch.ZipKey {
+			t.Errorf("%s: zip key %q, stored %q", tt.name, batch.ZipKey, stored.ZipKey)
+			continue
+		}
+		if files := zipContents(t, vp.store, stored.ZipKey); !maps.Equal(files, tt.zipped) {
+			t.Errorf("%s: zipped %v, want %v", tt.name, files, tt.zipped)
+		}
+	}
+
+	// An output that has gone missing fails the batch
+	batch := testBatch(t, vp, "a.mp4")
+	batch.Rows[0].Status, batch.Completed = "completed", 1
+	if err := vp.store.Delete(batch.Rows[0].OutputKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := vp.finishBatch(batch); err == nil {
+		t.Error("missing output: expected an error")
+	}
+	stored := &models.RenderBatch{}
+	vp.batchesCollection.FindOne(db.Ctx, bson.M{"_id": batch.ID}).Decode(stored)
+	if stored.Status != "failed" {
+		t.Errorf("missing output: status %q, want failed", stored.Status)
+	}
+}
+
+func TestFinishBatchRow(t *testing.T) {
+	vp := testVideoProcessor(t)
+	hub := websocket.NewHub(nil)
+	batch := testBatch(t, vp, "a.mp4", "")
+	job := func(row int) models.VideoProcessingJob {
+		return models.VideoProcessingJob{
+			ID:     primitive.NewObjectID(),
+			UserID: "alice",
+			Action: "export",
+			Params: map[string]interface{}{"batch_id": batch.ID, "batch_row": row},
+		}
+	}
+	read := func() *models.RenderBatch {
+		stored := &models.RenderBatch{}
+		if err := vp.batchesCollection.FindOne(db.Ctx, bson.M{"_id": batch.ID}).Decode(stored); err != nil {
+			t.Fatal(err)
+		}
+		return stored
+	}
+
+	// A job that never started counts as a failed row
+	vp.finishBatchRow(hub, job(1), "", errors.New("failed to start processing: no database"))
+	stored := read()
+	if stored.Failed != 1 || stored.Rows[1].Status != "failed" || !strings.Contains(stored.Rows[1].Error, "failed to start") {
+		t.Errorf("after failed row: %+v", stored)
+	}
+	if stored.Status != "processing" {
+		t.Errorf("finished with a row pending: %q", stored.Status)
+	}
+
+	outputURL, err := vp.store.SignedURL(batch.Rows[0].OutputKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vp.finishBatchRow(hub, job(0), outputURL, nil)
+	stored = read()
+	if stored.Completed != 1 || stored.Rows[0].Status != "completed" || stored.Rows[0].OutputKey != batch.Rows[0].OutputKey {
+		t.Errorf("after completed row: %+v", stored)
+	}
+	if stored.Status != "completed_with_errors" || stored.ZipKey == "" {
+		t.Errorf("last row should finish the batch: status %q, zip %q", stored.Status, stored.ZipKey)
+	}
+
+	// Jobs outside a batch are left alone
+	vp.finishBatchRow(hub, models.VideoProcessingJob{ID: primitive.NewObjectID(), Params: map[string]interface{}{}}, "", errors.New("boom"))
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"video-editor/models"
+	"video-editor/storage"
+)
+
+const (
+	// DefaultSceneThreshold is the scene score above which a frame starts a
+	// new scene when the caller does not pick one
+	DefaultSceneThreshold = 0.3
+
+	// sceneAnalysisThreshold is the lowest score kept by an analysis. Keeping
+	// weak boundaries lets later requests raise the threshold without
+	// decoding the video again.
+	sceneAnalysisThreshold = 0.1
+
+	// minSceneLength is the shortest clip SceneClips produces, in seconds.
+	// Flashes and fades often score as several cuts in a row.
+	minSceneLength = 0.5
+)
+
+// ValidSceneThreshold reports whether a threshold is a usable scene score
+func ValidSceneThreshold(threshold float64) bool {
+	return threshold > 0 && threshold < 1
+}
+
+// detectScenes scores every frame of a video asset with ffmpeg's scene
+// filter and stores the boundaries above the job's threshold on the asset
+func (vp *VideoProcessor) detectScenes(asset *models.MediaAsset, threshold float64) (string, error) {
+	if asset.Type != "video" || !hasVideoStream(asset.Metadata) {
+		return "", errNoVideoStream
+	}
+	if !ValidSceneThreshold(threshold) || threshold > sceneAnalysisThreshold {
+		threshold = sceneAnalysisThreshold
+	}
+
+	inputPath, cleanup, err := storage.Fetch(vp.store, asset.StorageKey, vp.workDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to stage asset: %v", err)
+	}
+	defer cleanup()
+
+	if err := os.MkdirAll(vp.workDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create work directory: %v", err)
+	}
+	scoresPath := filepath.Join(vp.workDir, fmt.Sprintf("scenes_%s.txt", asset.ID.Hex()))
+	defer os.Remove(scoresPath)
+
+	if err := runFFmpeg(sceneArgs(inputPath, scoresPath, threshold)); err != nil {
+		return "", fmt.Errorf("ffmpeg scene detection failed: %v", err)
+	}
+
+	f, err := os.Open(scoresPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	boundaries, err := ParseSceneScores(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read scene scores: %v", err)
+	}
+
+	analysis := &models.SceneAnalysis{
+		Threshold:  threshold,
+		Boundaries: boundaries,
+		AnalyzedAt: time.Now(),
+	}
+	if err := vp.assets.SetScenes(asset.ID, analysis); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d boundaries", len(boundaries)), nil
+}
+
+// sceneArgs builds the ffmpeg arguments to write the time and score of every
+// frame scoring above threshold to scoresPath. Nothing is encoded.
+func sceneArgs(inputPath, scoresPath string, threshold float64) []string {
+	// Quoting keeps ':' in the path from being read as an option separator
+	filter := fmt.Sprintf("select='gt(scene,%f)',metadata=print:file='%s'", threshold, filepath.ToSlash(scoresPath))
+	return []string{
+		"-i", inputPath,
+		"-map", "0:v:0",
+		"-an",
+		"-vf", filter,
+		"-f", "null",
+		"-y", os.DevNull,
+	}
+}
+
+// ParseSceneScores reads the output of ffmpeg's metadata=print filter after
+// select='gt(scene,T)': a "frame:N pts:P pts_time:T" line followed by
+// "lavfi.scene_score=S" for every selected frame
+func ParseSceneScores(r io.Reader) ([]models.SceneBoundary, error) {
+	var boundaries []models.SceneBoundary
+	current := -1.0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "frame:") {
+			current = -1
+			for _, field := range strings.Fields(line) {
+				if v, ok := strings.CutPrefix(field, "pts_time:"); ok {
+					if t, err := strconv.ParseFloat(v, 64); err == nil {
+						current = t
+					}
+				}
+			}
+			continue
+		}
+		v, ok := strings.CutPrefix(line, "lavfi.scene_score=")
+		if !ok || current < 0 {
+			continue
+		}
+		score, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scene score %q", v)
+		}
+		boundaries = append(boundaries, models.SceneBoundary{Time: current, Score: score})
+		current = -1
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(boundaries, func(i, j int) bool { return boundaries[i].Time < boundaries[j].Time })
+	return boundaries, nil
+}
+
+// FilterSceneBoundaries returns the boundaries scoring above threshold
+func FilterSceneBoundaries(analysis *models.SceneAnalysis, threshold float64) []models.SceneBoundary {
+	boundaries := []models.SceneBoundary{}
+	for _, b := range analysis.Boundaries {
+		if b.Score > threshold {
+			boundaries = append(boundaries, b)
+		}
+	}
+	return boundaries
+}
+
+// SceneClips splits a video asset at the given boundaries into consecutive
+// clips on one track, the first starting at startTime on the timeline.
+// Scenes shorter than minSceneLength are merged into the scene before them.
+func SceneClips(asset *models.MediaAsset, boundaries []models.SceneBoundary, track int, startTime float64) []models.TimelineItem {
+	duration := 0.0
+	if asset.Metadata != nil {
+		duration = asset.Metadata.Duration
+	}
+	if duration <= 0 {
+		return nil
+	}
+
+	cuts := []float64{0}
+	for _, b := range boundaries {
+		if b.Time-cuts[len(cuts)-1] < minSceneLength || duration-b.Time < minSceneLength {
+			continue
+		}
+		cuts = append(cuts, b.Time)
+	}
+	cuts = append(cuts, duration)
+
+	name := strings.TrimSuffix(asset.Filename, filepath.Ext(asset.Filename))
+	clips := make([]models.TimelineItem, 0, len(cuts)-1)
+	position := startTime
+	batch := time.Now().UnixNano()
+	for i := 0; i+1 < len(cuts); i++ {
+		length := roundMillis(cuts[i+1] - cuts[i])
+		clips = append(clips, models.TimelineItem{
+			ID:          fmt.Sprintf("%s-scene-%d-%d", asset.ID.Hex(), batch, i+1),
+			Type:        "video",
+			Name:        fmt.Sprintf("%s (scene %d)", name, i+1),
+			AssetID:     asset.ID.Hex(),
+			Track:       track,
+			StartTime:   roundMillis(position),
+			EndTime:     roundMillis(position + length),
+			Duration:    length,
+			SourceStart: roundMillis(cuts[i]),
+		})
+		position += length
+	}
+	return clips
+}
+
+// roundMillis rounds seconds to whole milliseconds
+func roundMillis(seconds float64) float64 {
+	return math.Round(seconds*1000) / 1000
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"video-editor/models"
+	"video-editor/storage"
+)
+
+const (
+	// DefaultSilenceNoiseDB is the level below which audio counts as silent
+	DefaultSilenceNoiseDB = -30.0
+	// DefaultMinSilence is the shortest silence removed, in seconds
+	DefaultMinSilence = 0.5
+	// DefaultSilencePadding is how much silence is kept next to sound, in
+	// seconds, so cuts do not clip the start and end of words
+	DefaultSilencePadding = 0.1
+
+	// silenceAnalysisMinDuration is the shortest silence kept by an analysis.
+	// Longer minimums are answered by filtering without decoding again.
+	silenceAnalysisMinDuration = 0.1
+
+	// minKeptSegment drops slivers of sound left between two cuts, in seconds
+	minKeptSegment = 0.05
+)
+
+// ErrClipNotFound is returned when an edit names a clip that is not on the timeline
+var ErrClipNotFound = errors.New("clip not found on the timeline")
+
+// SilenceOptions controls how silences are detected and cut
+type SilenceOptions struct {
+	NoiseDB    float64 `json:"noise_db"`    // Level below which audio counts as silent, e.g. -30
+	MinSilence float64 `json:"min_silence"` // Shortest silence removed, in seconds
+	Padding    float64 `json:"padding"`     // Silence kept on each side of sound, in seconds
+}
+
+// DefaultSilenceOptions returns the options used for fields a request leaves out
+func DefaultSilenceOptions() SilenceOptions {
+	return SilenceOptions{
+		NoiseDB:    DefaultSilenceNoiseDB,
+		MinSilence: DefaultMinSilence,
+		Padding:    DefaultSilencePadding,
+	}
+}
+
+// Validate checks the options are within the ranges silencedetect supports
+func (o *SilenceOptions) Validate() error {
+	if o.NoiseDB < -90 || o.NoiseDB >= 0 {
+		return errors.New("noise_db must be between -90 and 0")
+	}
+	if o.MinSilence < silenceAnalysisMinDuration {
+		return fmt.Errorf("min_silence must be at least %g seconds", silenceAnalysisMinDuration)
+	}
+	if o.Padding < 0 || 2*o.Padding >= o.MinSilence {
+		return errors.New("padding must not be negative and must be less than half of min_silence")
+	}
+	return nil
+}
+
+// SilenceEdit is the result of removing silences from a clip
+type SilenceEdit struct {
+	ClipID          string                   `json:"clip_id"`
+	Clips           []models.TimelineItem    `json:"clips"`   // Replace the original clip
+	Removed         []models.SilenceInterval `json:"removed"` // Cut stretches, in source time
+	RemovedDuration float64                  `json:"removed_duration"`
+	Timeline        *models.Timeline         `json:"timeline"` // The timeline after the edit
+}
+
+// detectSilences runs ffmpeg's silencedetect over an asset's audio and stores
+// the silent intervals on the asset
+func (vp *VideoProcessor) detectSilences(asset *models.MediaAsset, noiseDB float64) (string, error) {
+	if !hasAudioStream(asset.Metadata) {
+		return "", errNoAudioStream
+	}
+	if noiseDB == 0 {
+		noiseDB = DefaultSilenceNoiseDB
+	}
+
+	inputPath, cleanup, err := storage.Fetch(vp.store, asset.StorageKey, vp.workDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to stage asset: %v", err)
+	}
+	defer cleanup()
+
+	if err := os.MkdirAll(vp.workDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create work directory: %v", err)
+	}
+	logPath := filepath.Join(vp.workDir, fmt.Sprintf("silences_%s.txt", asset.ID.Hex()))
+	defer os.Remove(logPath)
+
+	if err := runFFmpeg(silenceArgs(inputPath, logPath, noiseDB)); err != nil {
+		return "", fmt.Errorf("ffmpeg silence detection failed: %v", err)
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	intervals, err := ParseSilences(f, asset.Metadata.Duration)
+	if err != nil {
+		return "", fmt.Errorf("failed to read silences: %v", err)
+	}
+
+	analysis := &models.SilenceAnalysis{
+		NoiseDB:     noiseDB,
+		MinDuration: silenceAnalysisMinDuration,
+		Intervals:   intervals,
+		AnalyzedAt:  time.Now(),
+	}
+	if err := vp.assets.SetSilences(asset.ID, analysis); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d silences", len(intervals)), nil
+}
+
+// silenceArgs builds the ffmpeg arguments to write the start and end of every
+// silence in the first audio track to logPath. Nothing is encoded.
+func silenceArgs(inputPath, logPath string, noiseDB float64) []string {
+	filter := fmt.Sprintf("silencedetect=noise=%gdB:d=%g,ametadata=print:file='%s'",
+		noiseDB, silenceAnalysisMinDuration, filepath.ToSlash(logPath))
+	return []string{
+		"-i", inputPath,
+		"-map", "0:a:0",
+		"-vn",
+		"-af", filter,
+		"-f", "null",
+		"-y", os.DevNull,
+	}
+}
+
+// ParseSilences reads the output of ffmpeg's ametadata=print filter after
+// silencedetect. A silence still open at the end of the input lasts until
+// duration.
+func ParseSilences(r io.Reader, duration float64) ([]models.SilenceInterval, error) {
+	intervals := []models.SilenceInterval{}
+	open := -1.0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || (key != "lavfi.silence_start" && key != "lavfi.silence_end") {
+			continue
+		}
+		t, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", key, value)
+		}
+		if key == "lavfi.silence_start" {
+			open = math.Max(t, 0)
+			continue
+		}
+		if open < 0 {
+			// The input started silent
+			open = 0
+		}
+		intervals = append(intervals, models.SilenceInterval{Start: open, End: t})
+		open = -1
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if open >= 0 && duration > open {
+		intervals = append(intervals, models.SilenceInterval{Start: open, End: duration})
+	}
+	return intervals, nil
+}
+
+// FilterSilences returns the intervals lasting at least minDuration
+func FilterSilences(analysis *models.SilenceAnalysis, minDuration float64) []models.SilenceInterval {
+	intervals := []models.SilenceInterval{}
+	for _, s := range analysis.Intervals {
+		if s.End-s.Start >= minDuration {
+			intervals = append(intervals, s)
+		}
+	}
+	return intervals
+}
+
+// RemoveSilences replaces a clip on a copy of the timeline with sub-clips
+// that skip its silences, played back to back. Later clips on the same track
+// move up by the removed time. The timeline passed in is not modified.
+func RemoveSilences(timeline *models.Timeline, clipID string, silences []models.SilenceInterval, opts SilenceOptions) (*SilenceEdit, error) {
+	index := -1
+	if timeline != nil {
+		for i, item := range timeline.MediaItems {
+			if item.ID == clipID {
+				index = i
+				break
+			}
+		}
+	}
+	if index < 0 {
+		return nil, ErrClipNotFound
+	}
+	clip := timeline.MediaItems[index]
+	if clip.Type != "video" && clip.Type != "audio" {
+		return nil, errors.New("silences can only be removed from video and audio clips")
+	}
+	sourceStart, duration := clipSpan(clip)
+	sourceEnd := sourceStart + duration
+
+	// Cut each long enough silence inside the clip, keeping padding next to sound
+	var removed []models.SilenceInterval
+	for _, s := range silences {
+		if s.End-s.Start < opts.MinSilence || s.End <= sourceStart || s.Start >= sourceEnd {
+			continue
+		}
+		cut := models.SilenceInterval{Start: sourceStart, End: sourceEnd}
+		if s.Start > sourceStart {
+			cut.Start = s.Start + opts.Padding
+		}
+		if s.End < sourceEnd {
+			cut.End = s.End - opts.Padding
+		}
+		if cut.End > cut.Start {
+			removed = append(removed, cut)
+		}
+	}
+
+	// The sound between the cuts becomes the new clips
+	var clips []models.TimelineItem
+	position, keptFrom := clip.StartTime, sourceStart
+	keep := func(until float64) {
+		length := until - keptFrom
+		if length < minKeptSegment {
+			return
+		}
+		sub := clip
+		sub.ID = fmt.Sprintf("%s-%d", clip.ID, len(clips)+1)
+		sub.SourceStart = roundMillis(keptFrom)
+		sub.Duration = roundMillis(length)
+		sub.StartTime = roundMillis(position)
+		sub.EndTime = roundMillis(position + length)
+		clips = append(clips, sub)
+		position += length
+	}
+	for _, cut := range removed {
+		keep(cut.Start)
+		keptFrom = cut.End
+	}
+	keep(sourceEnd)
+
+	shift := roundMillis(clip.StartTime + duration - position)
+	edited := &models.Timeline{
+		Duration:    timeline.Duration,
+		AspectRatio: timeline.AspectRatio,
+		MediaItems:  make([]models.TimelineItem, 0, len(timeline.MediaItems)+len(clips)),
+	}
+	oldEnd, newEnd := 0.0, 0.0
+	for i, item := range timeline.MediaItems {
+		oldEnd = math.Max(oldEnd, item.EndTime)
+		if i == index {
+			edited.MediaItems = append(edited.MediaItems, clips...)
+			if len(clips) > 0 {
+				newEnd = math.Max(newEnd, clips[len(clips)-1].EndTime)
+			}
+			continue
+		}
+		if item.Track == clip.Track && item.StartTime >= clip.EndTime {
+			item.StartTime = roundMillis(item.StartTime - shift)
+			item.EndTime = roundMillis(item.EndTime - shift)
+		}
+		newEnd = math.Max(newEnd, item.EndTime)
+		edited.MediaItems = append(edited.MediaItems, item)
+	}
+	// A timeline that ended with its last clip still does
+	if timeline.Duration <= oldEnd {
+		edited.Duration = newEnd
+	}
+	// Transitions into and out of the clip now join its first and last pieces
+	for _, t := range timeline.Transitions {
+		if t.FromClipID == clipID || t.ToClipID == clipID {
+			if len(clips) == 0 {
+				continue
+			}
+			if t.FromClipID == clipID {
+				t.FromClipID = clips[len(clips)-1].ID
+			}
+			if t.ToClipID == clipID {
+				t.ToClipID = clips[0].ID
+			}
+		}
+		edited.Transitions = append(edited.Transitions, t)
+	}
+
+	if removed == nil {
+		removed = []models.SilenceInterval{}
+	}
+	return &SilenceEdit{
+		ClipID:          clipID,
+		Clips:           clips,
+		Removed:         removed,
+		RemovedDuration: shift,
+		Timeline:        edited,
+	}, nil
+}
@@ -0,0 +1,393 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"video-editor/db"
+	"video-editor/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrTemplateNotFound is returned when a template doesn't exist or isn't
+// visible to the user
+var ErrTemplateNotFound = errors.New("template not found or unauthorized")
+
+// ErrTemplateMediaNotShared is returned for a template whose media clips
+// outside asset slots would reach users who can't play the author's media
+var ErrTemplateMediaNotShared = errors.New("media clips of a workspace template must be in asset slots")
+
+// TemplateService publishes projects as templates and creates projects from them
+type TemplateService struct {
+	templatesCollection *mongo.Collection
+	projects            *ProjectService
+	assets              *AssetService
+	workspaces          *WorkspaceService
+}
+
+// TemplateDraft describes a template to publish from a project
+type TemplateDraft struct {
+	Name        string                `json:"name" binding:"required"`
+	Description string                `json:"description"`
+	Visibility  string                `json:"visibility"` // Private unless set
+	WorkspaceID string                `json:"workspace_id"`
+	Slots       []models.TemplateSlot `json:"slots"`
+}
+
+// NewTemplateService creates a new TemplateService
+func NewTemplateService(client *mongo.Client, dbName string, projects *ProjectService, assets *AssetService, workspaces *WorkspaceService) *TemplateService {
+	return &TemplateService{
+		templatesCollection: client.Database(dbName).Collection("project_templates"),
+		projects:            projects,
+		assets:              assets,
+		workspaces:          workspaces,
+	}
+}
+
+// PublishTemplate saves a copy of a project's timeline as a template
+func (s *TemplateService) PublishTemplate(projectID, userID string, draft TemplateDraft) (*models.ProjectTemplate, error) {
+	project, err := s.projects.GetProject(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	timeline := project.Timeline
+	if timeline == nil {
+		timeline = &models.Timeline{MediaItems: []models.TimelineItem{}}
+	}
+	if err := ValidateTemplateSlots(timeline, draft.Slots); err != nil {
+		return nil, err
+	}
+
+	switch draft.Visibility {
+	case "", models.TemplatePrivate:
+		draft.Visibility, draft.WorkspaceID = models.TemplatePrivate, ""
+	case models.TemplateWorkspace:
+		member, err := s.workspaces.IsMember(draft.WorkspaceID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !member {
+			return nil, ErrWorkspaceNotFound
+		}
+		// Other members can't play the author's media, only their own
+		if ids := unslottedMediaClips(timeline, draft.Slots); len(ids) > 0 {
+			return nil, fmt.Errorf("%w: clips %s", ErrTemplateMediaNotShared, strings.Join(ids, ", "))
+		}
+	default:
+		return nil, fmt.Errorf("unknown visibility %q", draft.Visibility)
+	}
+
+	template := &models.ProjectTemplate{
+		ID:              primitive.NewObjectID(),
+		OwnerID:         userID,
+		Name:            strings.TrimSpace(draft.Name),
+		Description:     draft.Description,
+		Visibility:      draft.Visibility,
+		WorkspaceID:     draft.WorkspaceID,
+		Slots:           draft.Slots,
+		Timeline:        timeline,
+		SourceProjectID: project.ID,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+	if template.Slots == nil {
+		template.Slots = []models.TemplateSlot{}
+	}
+	if _, err := s.templatesCollection.InsertOne(db.Ctx, template); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// visibleFilter matches the templates a user owns or shares a workspace with
+func (s *TemplateService) visibleFilter(userID string) (bson.M, error) {
+	workspaceIDs, err := s.workspaces.WorkspaceIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"owner_id": userID},
+		bson.M{"visibility": models.TemplateWorkspace, "workspace_id": bson.M{"$in": workspaceIDs}},
+	}}, nil
+}
+
+// ListTemplates returns the templates the user can start a project from,
+// newest first and without their timelines
+func (s *TemplateService) ListTemplates(userID string) ([]models.ProjectTemplate, error) {
+	filter, err := s.visibleFilter(userID)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetProjection(bson.M{"timeline": 0})
+	cursor, err := s.templatesCollection.Find(db.Ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	templates := []models.ProjectTemplate{}
+	if err := cursor.All(db.Ctx, &templates); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// GetTemplate returns a template visible to the user, including its timeline
+func (s *TemplateService) GetTemplate(templateID, userID string) (*models.ProjectTemplate, error) {
+	objID, err := primitive.ObjectIDFromHex(templateID)
+	if err != nil {
+		return nil, errors.New("invalid template ID format")
+	}
+	filter, err := s.visibleFilter(userID)
+	if err != nil {
+		return nil, err
+	}
+	filter["_id"] = objID
+
+	template := &models.ProjectTemplate{}
+	if err := s.templatesCollection.FindOne(db.Ctx, filter).Decode(template); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrTemplateNotFound
+		}
+		return nil, err
+	}
+	return template, nil
+}
+
+// DeleteTemplate removes one of the user's own templates. Projects created
+// from it are unaffected.
+func (s *TemplateService) DeleteTemplate(templateID, userID string) error {
+	objID, err := primitive.ObjectIDFromHex(templateID)
+	if err != nil {
+		return errors.New("invalid template ID format")
+	}
+	result, err := s.templatesCollection.DeleteOne(db.Ctx, bson.M{"_id": objID, "owner_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrTemplateNotFound
+	}
+	return nil
+}
+
+// CreateFromTemplate creates a project for the user from a template. values
+// fills the slots by name: an asset ID of the user's for asset slots, the
+// text for text slots.
+func (s *TemplateService) CreateFromTemplate(templateID, userID, name string, values map[string]string) (*models.Project, error) {
+	template, err := s.GetTemplate(templateID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckTemplateMedia(template, userID); err != nil {
+		return nil, err
+	}
+	texts := make(map[string]string)
+	assets := make(map[string]*models.MediaAsset)
+	for _, slot := range template.Slots {
+		value, ok := values[slot.Name]
+		if !ok || value == "" {
+			continue
+		}
+		if slot.Kind == models.SlotText {
+			texts[slot.Name] = value
+			continue
+		}
+		asset, err := s.assets.GetAsset(value, userID)
+		if err != nil {
+			return nil, fmt.Errorf("slot %s: %w", slot.Name, err)
+		}
+		assets[slot.Name] = asset
+	}
+	timeline, err := FillTemplate(template, texts, assets)
+	if err != nil {
+		return nil, err
+	}
+
+	if name = strings.TrimSpace(name); name == "" {
+		name = template.Name
+	}
+	project := &models.Project{
+		UserID:   userID,
+		Name:     name,
+		Edits:    []models.EditOperation{},
+		Timeline: timeline,
+	}
+	if err := s.projects.CreateProject(project); err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+// ValidateTemplateSlots checks that every slot has a unique name and points
+// at clips of the timeline it can fill. Text slots fill text clips; an asset
+// slot fills video, audio or image clips, all of one type. A clip belongs to
+// at most one slot.
+func ValidateTemplateSlots(timeline *models.Timeline, slots []models.TemplateSlot) error {
+	clips := make(map[string]models.TimelineItem, len(timeline.MediaItems))
+	for _, item := range timeline.MediaItems {
+		clips[item.ID] = item
+	}
+	names := make(map[string]bool, len(slots))
+	filled := make(map[string]string)
+	for _, slot := range slots {
+		if slot.Name == "" {
+			return errors.New("every slot needs a name")
+		}
+		if names[slot.Name] {
+			return fmt.Errorf("slot name %q is used more than once", slot.Name)
+		}
+		names[slot.Name] = true
+		if slot.Kind != models.SlotAsset && slot.Kind != models.SlotText {
+			return fmt.Errorf("slot %s has unknown kind %q", slot.Name, slot.Kind)
+		}
+		if len(slot.ClipIDs) == 0 {
+			return fmt.Errorf("slot %s has no clips", slot.Name)
+		}
+
+		clipType := ""
+		for _, id := range slot.ClipIDs {
+			clip, ok := clips[id]
+			if !ok {
+				return fmt.Errorf("slot %s: clip %s is not on the timeline", slot.Name, id)
+			}
+			if other, ok := filled[id]; ok {
+				return fmt.Errorf("clip %s is in slots %s and %s", id, other, slot.Name)
+			}
+			filled[id] = slot.Name
+			if slot.Kind == models.SlotText {
+				if clip.Type != "text" {
+					return fmt.Errorf("slot %s: clip %s is not a text clip", slot.Name, id)
+				}
+				continue
+			}
+			if clip.Type == "text" {
+				return fmt.Errorf("slot %s: clip %s is a text clip", slot.Name, id)
+			}
+			if clipType != "" && clip.Type != clipType {
+				return fmt.Errorf("slot %s mixes %s and %s clips", slot.Name, clipType, clip.Type)
+			}
+			clipType = clip.Type
+		}
+	}
+	return nil
+}
+
+// unslottedMediaClips returns the IDs of clips that play media but are in no
+// asset slot, so a project from the template keeps the author's asset
+func unslottedMediaClips(timeline *models.Timeline, slots []models.TemplateSlot) []string {
+	slotted := make(map[string]bool)
+	for _, slot := range slots {
+		if slot.Kind != models.SlotAsset {
+			continue
+		}
+		for _, id := range slot.ClipIDs {
+			slotted[id] = true
+		}
+	}
+	var ids []string
+	for _, item := range timeline.MediaItems {
+		if item.Type != "text" && (item.AssetID != "" || item.URL != "") && !slotted[item.ID] {
+			ids = append(ids, item.ID)
+		}
+	}
+	return ids
+}
+
+// CheckTemplateMedia refuses a template for anyone but its author while it
+// has media clips outside asset slots, as their projects could not export.
+// Templates published before workspace templates were checked may have them.
+func CheckTemplateMedia(template *models.ProjectTemplate, userID string) error {
+	if template.OwnerID == userID || template.Timeline == nil {
+		return nil
+	}
+	if ids := unslottedMediaClips(template.Timeline, template.Slots); len(ids) > 0 {
+		return fmt.Errorf("%w: clips %s", ErrTemplateMediaNotShared, strings.Join(ids, ", "))
+	}
+	return nil
+}
+
+// FillTemplate returns a copy of a template's timeline with its slots filled.
+// Text slots without a value keep the template's text. Asset slots point their
+// clips at the asset, playing it from its start and shortening clips the
+// asset is too short for; without an asset their clips are left out, since
+// the template's own media belongs to its owner. Required slots must be filled.
+func FillTemplate(template *models.ProjectTemplate, texts map[string]string, assets map[string]*models.MediaAsset) (*models.Timeline, error) {
+	timeline := cloneTimeline(template.Timeline)
+	index := make(map[string]int, len(timeline.MediaItems))
+	for i, item := range timeline.MediaItems {
+		index[item.ID] = i
+	}
+
+	removed := make(map[string]bool)
+	for _, slot := range template.Slots {
+		text, hasText := texts[slot.Name]
+		asset := assets[slot.Name]
+		if slot.Required && !hasText && asset == nil {
+			return nil, fmt.Errorf("slot %s is required", slot.Name)
+		}
+		for _, id := range slot.ClipIDs {
+			i, ok := index[id]
+			if !ok {
+				continue
+			}
+			clip := &timeline.MediaItems[i]
+			switch {
+			case slot.Kind == models.SlotText && hasText:
+				clip.Text = text
+				if clip.Content != "" {
+					clip.Content = text
+				}
+			case slot.Kind == models.SlotAsset && asset != nil:
+				if asset.Type != clip.Type {
+					return nil, fmt.Errorf("slot %s takes %s assets, not %s", slot.Name, clip.Type, asset.Type)
+				}
+				fillClip(clip, asset)
+			case slot.Kind == models.SlotAsset:
+				removed[id] = true
+			}
+		}
+	}
+
+	if len(removed) > 0 {
+		kept := timeline.MediaItems[:0]
+		for _, item := range timeline.MediaItems {
+			if !removed[item.ID] {
+				kept = append(kept, item)
+			}
+		}
+		timeline.MediaItems = kept
+		var transitions []models.Transition
+		for _, t := range timeline.Transitions {
+			if !removed[t.FromClipID] && !removed[t.ToClipID] {
+				transitions = append(transitions, t)
+			}
+		}
+		timeline.Transitions = transitions
+	}
+	if err := ValidateTimeline(timeline); err != nil {
+		return nil, err
+	}
+	return timeline, nil
+}
+
+// fillClip points a template clip at an asset
+func fillClip(clip *models.TimelineItem, asset *models.MediaAsset) {
+	clip.AssetID = asset.ID.Hex()
+	clip.URL = asset.URL
+	clip.Name = asset.Filename
+	clip.SourceStart = 0
+	if clip.Type == "image" || asset.Metadata == nil || asset.Metadata.Duration <= 0 {
+		return
+	}
+	if _, duration := clipSpan(*clip); asset.Metadata.Duration < duration {
+		clip.Duration = roundMillis(asset.Metadata.Duration)
+		clip.EndTime = roundMillis(clip.StartTime + asset.Metadata.Duration)
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"video-editor/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testTemplate has a logo slot over two image clips, a required video slot
+// whose clip leads into a transition, and a headline slot over two texts
+func testTemplate() *models.ProjectTemplate {
+	return &models.ProjectTemplate{
+		OwnerID: "author",
+		Timeline: &models.Timeline{
+			AspectRatio: "16:9",
+			Duration:    10,
+			MediaItems: []models.TimelineItem{
+				{ID: "v1", Type: "video", AssetID: "author-clip", StartTime: 0, EndTime: 6, Duration: 6, SourceStart: 3},
+				{ID: "v2", Type: "video", AssetID: "author-outro", StartTime: 6, EndTime: 10, Duration: 4},
+				{ID: "i1", Type: "image", AssetID: "author-logo", Track: 1, StartTime: 0, EndTime: 2, Duration: 2},
+				{ID: "i2", Type: "image", AssetID: "author-logo", Track: 1, StartTime: 8, EndTime: 10, Duration: 2},
+				{ID: "t1", Type: "text", Text: "Headline", Content: "Headline", Track: 2, StartTime: 0, EndTime: 3, Duration: 3},
+				{ID: "t2", Type: "text", Text: "Headline", Track: 2, StartTime: 7, EndTime: 10, Duration: 3},
+			},
+			Transitions: []models.Transition{
+				{ID: "v1-to-v2", Type: "fade", FromClipID: "v1", ToClipID: "v2", Duration: 1},
+			},
+		},
+		Slots: []models.TemplateSlot{
+			{Name: "logo", Kind: models.SlotAsset, ClipIDs: []string{"i1", "i2"}},
+			{Name: "clip", Kind: models.SlotAsset, ClipIDs: []string{"v1"}, Required: true},
+			{Name: "outro", Kind: models.SlotAsset, ClipIDs: []string{"v2"}},
+			{Name: "headline", Kind: models.SlotText, ClipIDs: []string{"t1", "t2"}},
+		},
+	}
+}
+
+func TestValidateTemplateSlots(t *testing.T) {
+	slot := func(name, kind string, ids ...string) models.TemplateSlot {
+		return models.TemplateSlot{Name: name, Kind: kind, ClipIDs: ids}
+	}
+	tests := []struct {
+		name  string
+		slots []models.TemplateSlot
+		ok    bool
+	}{
+		{"template's own", testTemplate().Slots, true},
+		{"none", nil, true},
+		{"no name", []models.TemplateSlot{slot("", models.SlotText, "t1")}, false},
+		{"duplicate name", []models.TemplateSlot{slot("a", models.SlotText, "t1"), slot("a", models.SlotText, "t2")}, false},
+		{"unknown kind", []models.TemplateSlot{slot("a", "color", "t1")}, false},
+		{"no clips", []models.TemplateSlot{slot("a", models.SlotText)}, false},
+		{"missing clip", []models.TemplateSlot{slot("a", models.SlotAsset, "nope")}, false},
+		{"clip in two slots", []models.TemplateSlot{slot("a", models.SlotAsset, "i1"), slot("b", models.SlotAsset, "i1")}, false},
+		{"text slot on media", []models.TemplateSlot{slot("a", models.SlotText, "v1")}, false},
+		{"asset slot on text", []models.TemplateSlot{slot("a", models.SlotAsset, "t1")}, false},
+		{"mixed types", []models.TemplateSlot{slot("a", models.SlotAsset, "v1", "i1")}, false},
+	}
+	for _, tt := range tests {
+		err := ValidateTemplateSlots(testTemplate().Timeline, tt.slots)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestFillTemplate(t *testing.T) {
+	clip := &models.MediaAsset{ID: primitive.NewObjectID(), Type: "video", Filename: "mine.mp4",
+		Metadata: &models.MediaMetadata{Duration: 4.5}}
+	logo := &models.MediaAsset{ID: primitive.NewObjectID(), Type: "image", Filename: "logo.png"}
+
+	template := testTemplate()
+	timeline, err := FillTemplate(template, map[string]string{"headline": "Launch"}, map[string]*models.MediaAsset{
+		"clip": clip,
+		"logo": logo,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := make(map[string]models.TimelineItem)
+	var ids []string
+	for _, item := range timeline.MediaItems {
+		byID[item.ID] = item
+		ids = append(ids, item.ID)
+	}
+
+	// The empty outro slot drops its clip and the transition into it
+	if !slices.Equal(ids, []string{"v1", "i1", "i2", "t1", "t2"}) {
+		t.Errorf("clips %v", ids)
+	}
+	if len(timeline.Transitions) != 0 {
+		t.Errorf("transitions %+v", timeline.Transitions)
+	}
+	// The clip plays the asset from its start, cut to the asset's length
+	if v1 := byID["v1"]; v1.AssetID != clip.ID.Hex() || v1.Name != "mine.mp4" || v1.SourceStart != 0 || v1.Duration != 4.5 || v1.EndTime != 4.5 {
+		t.Errorf("v1: %+v", v1)
+	}
+	for _, id := range []string{"i1", "i2"} {
+		if i := byID[id]; i.AssetID != logo.ID.Hex() || i.Duration != 2 {
+			t.Errorf("%s: %+v", id, i)
+		}
+	}
+	if t1, t2 := byID["t1"], byID["t2"]; t1.Text != "Launch" || t1.Content != "Launch" || t2.Text != "Launch" || t2.Content != "" {
+		t.Errorf("texts: %+v, %+v", t1, t2)
+	}
+	// The template itself is untouched
+	if template.Timeline.MediaItems[0].AssetID != "author-clip" || template.Timeline.MediaItems[4].Text != "Headline" {
+		t.Error("FillTemplate modified the template")
+	}
+
+	// Text slots left empty keep the template's text
+	timeline, err = FillTemplate(testTemplate(), nil, map[string]*models.MediaAsset{"clip": clip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timeline.MediaItems) != 3 || timeline.MediaItems[1].Text != "Headline" {
+		t.Errorf("clips %+v", timeline.MediaItems)
+	}
+
+	if _, err := FillTemplate(testTemplate(), nil, map[string]*models.MediaAsset{"logo": logo}); err == nil {
+		t.Error("expected an error for the required clip slot")
+	}
+	if _, err := FillTemplate(testTemplate(), nil, map[string]*models.MediaAsset{"clip": logo}); err == nil {
+		t.Error("expected an error for an image in a video slot")
+	}
+}
+
+func TestCheckTemplateMedia(t *testing.T) {
+	template := testTemplate()
+	if err := CheckTemplateMedia(template, "member"); err != nil {
+		t.Errorf("all media slotted: %v", err)
+	}
+
+	// A background bed outside any slot would play the author's asset
+	template.Timeline.MediaItems = append(template.Timeline.MediaItems,
+		models.TimelineItem{ID: "bed", Type: "audio", AssetID: "author-music", Track: 3, StartTime: 0, EndTime: 10, Duration: 10})
+	if err := CheckTemplateMedia(template, "author"); err != nil {
+		t.Errorf("author: %v", err)
+	}
+	if err := CheckTemplateMedia(template, "member"); !errors.Is(err, ErrTemplateMediaNotShared) {
+		t.Errorf("member: got %v, want ErrTemplateMediaNotShared", err)
+	}
+	if ids := unslottedMediaClips(template.Timeline, template.Slots); !slices.Equal(ids, []string{"bed"}) {
+		t.Errorf("unslotted %v", ids)
+	}
+}
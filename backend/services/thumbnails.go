@@ -0,0 +1,174 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"video-editor/models"
+	"video-editor/storage"
+)
+
+const (
+	// thumbWidth is the width of each frame in a thumbnail sprite
+	thumbWidth = 160
+	// thumbColumns is the number of frames per sprite row
+	thumbColumns = 10
+	// thumbMinInterval is the shortest gap between sprite frames, in seconds
+	thumbMinInterval = 2.0
+	// thumbMaxCount bounds the sprite size for long videos by widening the interval
+	thumbMaxCount = 200
+	// posterMaxWidth is the largest width of a poster frame
+	posterMaxWidth = 1280
+
+	// spriteName is how the WebVTT index refers to its sprite; it is replaced
+	// by a signed URL when the index is served
+	spriteName = "sprite.jpg"
+)
+
+// spriteLayout describes how frames are tiled in a thumbnail sprite
+type spriteLayout struct {
+	Interval float64 // Seconds between frames
+	Count    int
+	Columns  int
+	Rows     int
+	Width    int // Size of a single frame
+	Height   int
+}
+
+// newSpriteLayout picks the frame interval and tile grid for a video
+func newSpriteLayout(meta *models.MediaMetadata) spriteLayout {
+	interval := math.Max(thumbMinInterval, meta.Duration/thumbMaxCount)
+	count := int(math.Ceil(meta.Duration / interval))
+	if count < 1 {
+		count = 1
+	}
+	columns := thumbColumns
+	if count < columns {
+		columns = count
+	}
+
+	// ffmpeg rotates frames upright, so the display aspect swaps for portrait video
+	width, height := meta.Width, meta.Height
+	if meta.Rotation%180 != 0 {
+		width, height = height, width
+	}
+	thumbHeight := thumbWidth * 9 / 16
+	if width > 0 && height > 0 {
+		thumbHeight = int(math.Round(float64(thumbWidth)*float64(height)/float64(width)/2)) * 2
+	}
+
+	return spriteLayout{
+		Interval: interval,
+		Count:    count,
+		Columns:  columns,
+		Rows:     (count + columns - 1) / columns,
+		Width:    thumbWidth,
+		Height:   thumbHeight,
+	}
+}
+
+// vtt renders the WebVTT thumbnails index, one cue per sprite frame
+func (l spriteLayout) vtt(duration float64) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i := 0; i < l.Count; i++ {
+		start := float64(i) * l.Interval
+		end := math.Min(start+l.Interval, duration)
+		if end <= start {
+			break
+		}
+		x := (i % l.Columns) * l.Width
+		y := (i / l.Columns) * l.Height
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), spriteName, x, y, l.Width, l.Height)
+	}
+	return b.String()
+}
+
+// vttTimestamp formats seconds as HH:MM:SS.mmm
+func vttTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// generateThumbnails creates the poster frame of an asset and, for videos, a
+// thumbnail sprite with its WebVTT index. It returns the poster URL.
+func (vp *VideoProcessor) generateThumbnails(asset *models.MediaAsset) (string, error) {
+	if !hasVideoStream(asset.Metadata) {
+		return "", errNoVideoStream
+	}
+
+	inputPath, cleanup, err := storage.Fetch(vp.store, asset.StorageKey, vp.workDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to stage asset: %v", err)
+	}
+	defer cleanup()
+
+	if err := os.MkdirAll(vp.workDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create work directory: %v", err)
+	}
+	prefix := filepath.Join(vp.workDir, "thumbs_"+asset.ID.Hex())
+	posterPath, spritePath, vttPath := prefix+"_poster.jpg", prefix+"_sprite.jpg", prefix+".vtt"
+	defer os.Remove(posterPath)
+	defer os.Remove(spritePath)
+	defer os.Remove(vttPath)
+
+	// Skip black lead-in frames, without seeking far into short clips
+	seek := math.Min(asset.Metadata.Duration*0.1, 5)
+	if err := runFFmpeg(posterArgs(inputPath, posterPath, seek)); err != nil {
+		return "", fmt.Errorf("ffmpeg poster failed: %v", err)
+	}
+	posterURL, err := vp.storeDerived(asset, models.DerivedPoster, posterPath, "poster.jpg")
+	if err != nil {
+		return "", err
+	}
+
+	// Still images only get a poster
+	if asset.Type != "video" || asset.Metadata.Duration <= 0 {
+		return posterURL, nil
+	}
+
+	layout := newSpriteLayout(asset.Metadata)
+	if err := runFFmpeg(spriteArgs(inputPath, spritePath, layout)); err != nil {
+		return "", fmt.Errorf("ffmpeg sprite failed: %v", err)
+	}
+	if err := os.WriteFile(vttPath, []byte(layout.vtt(asset.Metadata.Duration)), 0644); err != nil {
+		return "", err
+	}
+	if _, err := vp.storeDerived(asset, models.DerivedSprite, spritePath, spriteName); err != nil {
+		return "", err
+	}
+	if _, err := vp.storeDerived(asset, models.DerivedThumbnailsVTT, vttPath, "thumbnails.vtt"); err != nil {
+		return "", err
+	}
+	return posterURL, nil
+}
+
+// posterArgs builds the ffmpeg arguments to grab a single frame at seek seconds
+func posterArgs(inputPath, outputPath string, seek float64) []string {
+	return []string{
+		"-ss", fmt.Sprintf("%f", seek),
+		"-i", inputPath,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", posterMaxWidth),
+		"-q:v", "3",
+		"-y", outputPath,
+	}
+}
+
+// spriteArgs builds the ffmpeg arguments to tile frames into a single image.
+// Cells past the last frame are left black.
+func spriteArgs(inputPath, outputPath string, l spriteLayout) []string {
+	filter := fmt.Sprintf("fps=1/%f,scale=%d:%d,tile=%dx%d", l.Interval, l.Width, l.Height, l.Columns, l.Rows)
+	return []string{
+		"-i", inputPath,
+		"-an",
+		"-vf", filter,
+		"-frames:v", "1",
+		"-q:v", "5",
+		"-y", outputPath,
+	}
+}
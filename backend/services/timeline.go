@@ -0,0 +1,163 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"video-editor/models"
+)
+
+// timelineItemTypes are the kinds of clips a timeline can hold
+var timelineItemTypes = map[string]bool{
+	"video": true,
+	"audio": true,
+	"image": true,
+	"text":  true,
+}
+
+// transitionTypes are the ffmpeg xfade transitions a timeline can use
+var transitionTypes = map[string]bool{
+	"fade":        true,
+	"dissolve":    true,
+	"fadeblack":   true,
+	"fadewhite":   true,
+	"wipeleft":    true,
+	"wiperight":   true,
+	"wipeup":      true,
+	"wipedown":    true,
+	"slideleft":   true,
+	"slideright":  true,
+	"slideup":     true,
+	"slidedown":   true,
+	"circleopen":  true,
+	"circleclose": true,
+}
+
+// ValidateTimeline checks a timeline sent by a client before it is stored.
+// Every clip needs a unique ID, a known type and a non-negative placement.
+// Transitions need a unique ID, a known type and two of the timeline's clips.
+func ValidateTimeline(timeline *models.Timeline) error {
+	if timeline == nil {
+		return errors.New("timeline is required")
+	}
+	if timeline.MediaItems == nil {
+		timeline.MediaItems = []models.TimelineItem{}
+	}
+	if timeline.Duration < 0 {
+		return errors.New("timeline duration cannot be negative")
+	}
+	seen := make(map[string]bool, len(timeline.MediaItems))
+	for i, item := range timeline.MediaItems {
+		if item.ID == "" {
+			return fmt.Errorf("clip %d has no id", i)
+		}
+		if seen[item.ID] {
+			return fmt.Errorf("clip id %q is used more than once", item.ID)
+		}
+		seen[item.ID] = true
+		if !timelineItemTypes[item.Type] {
+			return fmt.Errorf("clip %s has unknown type %q", item.ID, item.Type)
+		}
+		if item.StartTime < 0 || item.EndTime < item.StartTime || item.SourceStart < 0 || item.Track < 0 {
+			return fmt.Errorf("clip %s has an invalid placement", item.ID)
+		}
+	}
+
+	transitions := make(map[string]bool, len(timeline.Transitions))
+	for i, t := range timeline.Transitions {
+		if t.ID == "" {
+			return fmt.Errorf("transition %d has no id", i)
+		}
+		if transitions[t.ID] {
+			return fmt.Errorf("transition id %q is used more than once", t.ID)
+		}
+		transitions[t.ID] = true
+		if !transitionTypes[t.Type] {
+			return fmt.Errorf("transition %s has unknown type %q", t.ID, t.Type)
+		}
+		if !seen[t.FromClipID] || !seen[t.ToClipID] || t.FromClipID == t.ToClipID {
+			return fmt.Errorf("transition %s must join two clips of the timeline", t.ID)
+		}
+		if t.Duration <= 0 {
+			return fmt.Errorf("transition %s needs a positive duration", t.ID)
+		}
+	}
+	return nil
+}
+
+// TimelineDiff lists the clips that differ between two timelines
+type TimelineDiff struct {
+	From         int64                 `json:"from"` // Revisions compared
+	To           int64                 `json:"to"`
+	Added        []models.TimelineItem `json:"added"`
+	Removed      []models.TimelineItem `json:"removed"`
+	Changed      []ClipChange          `json:"changed"`
+	FromDuration float64               `json:"from_duration"`
+	ToDuration   float64               `json:"to_duration"`
+}
+
+// ClipChange is a clip present in both timelines with different properties
+type ClipChange struct {
+	ID     string              `json:"id"`
+	Fields []string            `json:"fields"` // JSON names of the changed properties
+	Before models.TimelineItem `json:"before"`
+	After  models.TimelineItem `json:"after"`
+}
+
+// DiffTimelines matches clips by ID and reports those added, removed or changed
+// going from one timeline to the other. Either timeline may be nil.
+func DiffTimelines(from, to *models.Timeline) *TimelineDiff {
+	diff := &TimelineDiff{
+		Added:   []models.TimelineItem{},
+		Removed: []models.TimelineItem{},
+		Changed: []ClipChange{},
+	}
+	var fromItems, toItems []models.TimelineItem
+	if from != nil {
+		fromItems, diff.FromDuration = from.MediaItems, from.Duration
+	}
+	if to != nil {
+		toItems, diff.ToDuration = to.MediaItems, to.Duration
+	}
+
+	before := make(map[string]models.TimelineItem, len(fromItems))
+	for _, item := range fromItems {
+		before[item.ID] = item
+	}
+	after := make(map[string]bool, len(toItems))
+	for _, item := range toItems {
+		after[item.ID] = true
+		old, ok := before[item.ID]
+		if !ok {
+			diff.Added = append(diff.Added, item)
+			continue
+		}
+		if fields := changedFields(old, item); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, ClipChange{ID: item.ID, Fields: fields, Before: old, After: item})
+		}
+	}
+	for _, item := range fromItems {
+		if !after[item.ID] {
+			diff.Removed = append(diff.Removed, item)
+		}
+	}
+	return diff
+}
+
+// changedFields returns the JSON names of the properties that differ between
+// two versions of a clip
+func changedFields(a, b models.TimelineItem) []string {
+	var fields []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	t := va.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			continue
+		}
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+	return fields
+}
@@ -0,0 +1,451 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"video-editor/models"
+)
+
+// ErrInvalidOperation is wrapped by every error for an operation that cannot
+// be applied to the timeline as it is
+var ErrInvalidOperation = errors.New("invalid operation")
+
+// clipProperties are the clip fields set_property may change. Placement has
+// its own operations so that it can be validated and inverted exactly.
+var clipProperties = map[string]bool{
+	"name":       true,
+	"url":        true,
+	"x":          true,
+	"y":          true,
+	"width":      true,
+	"height":     true,
+	"position":   true,
+	"text":       true,
+	"content":    true,
+	"color":      true,
+	"fontSize":   true,
+	"fontFamily": true,
+	"fontColor":  true,
+	"fontWeight": true,
+	"fontStyle":  true,
+	"textAlign":  true,
+	"isMuted":    true,
+}
+
+// timeEpsilon is how far apart two times can be and still be the same, in seconds
+const timeEpsilon = 0.001
+
+func invalidOperation(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidOperation, fmt.Sprintf(format, args...))
+}
+
+// ApplyOperation applies an operation to a copy of the timeline and returns
+// the edited copy with the operation that reverts it. Values the server
+// chooses, like the ID of a split-off clip, are filled in on op so that
+// applying it again gives the same result. The timeline passed in is not
+// modified.
+func ApplyOperation(timeline *models.Timeline, op *models.TimelineOperation) (*models.Timeline, *models.TimelineOperation, error) {
+	edited := cloneTimeline(timeline)
+	oldEnd := contentEnd(edited)
+
+	var inverse *models.TimelineOperation
+	var err error
+	switch op.Type {
+	case models.OpAddClip:
+		inverse, err = addClip(edited, op)
+	case models.OpDeleteClip:
+		inverse, err = deleteClip(edited, op)
+	case models.OpMoveClip:
+		inverse, err = moveClip(edited, op)
+	case models.OpTrimClip:
+		inverse, err = trimClip(edited, op)
+	case models.OpSplitClip:
+		inverse, err = splitClip(edited, op)
+	case models.OpJoinClips:
+		inverse, err = joinClips(edited, op)
+	case models.OpSetProperty:
+		inverse, err = setProperty(edited, op)
+	case models.OpAddTransition:
+		inverse, err = addTransition(edited, op)
+	case models.OpRemoveTransition:
+		inverse, err = removeTransition(edited, op)
+	default:
+		err = invalidOperation("unknown operation type %q", op.Type)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A timeline that ended with its last clip still does
+	newEnd := contentEnd(edited)
+	if edited.Duration <= oldEnd || newEnd > edited.Duration {
+		edited.Duration = newEnd
+	}
+	if err := ValidateTimeline(edited); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidOperation, err)
+	}
+	return edited, inverse, nil
+}
+
+// cloneTimeline copies a timeline deeply enough for operations to edit it
+func cloneTimeline(timeline *models.Timeline) *models.Timeline {
+	if timeline == nil {
+		return &models.Timeline{MediaItems: []models.TimelineItem{}}
+	}
+	clone := *timeline
+	clone.MediaItems = append([]models.TimelineItem{}, timeline.MediaItems...)
+	if timeline.Transitions != nil {
+		clone.Transitions = append([]models.Transition{}, timeline.Transitions...)
+	}
+	return &clone
+}
+
+// ExportDuration is how long a timeline renders: its duration, or until its
+// last clip ends if that is later
+func ExportDuration(timeline *models.Timeline) float64 {
+	if timeline == nil {
+		return 0
+	}
+	return math.Max(timeline.Duration, contentEnd(timeline))
+}
+
+// contentEnd returns when the last clip of a timeline ends
+func contentEnd(timeline *models.Timeline) float64 {
+	end := 0.0
+	for _, item := range timeline.MediaItems {
+		end = math.Max(end, item.EndTime)
+	}
+	return end
+}
+
+func findClip(timeline *models.Timeline, id string) (int, error) {
+	if id == "" {
+		return -1, invalidOperation("clip_id is required")
+	}
+	for i, item := range timeline.MediaItems {
+		if item.ID == id {
+			return i, nil
+		}
+	}
+	return -1, invalidOperation("clip %s is not on the timeline", id)
+}
+
+func findTransition(timeline *models.Timeline, id string) (int, error) {
+	if id == "" {
+		return -1, invalidOperation("transition_id is required")
+	}
+	for i, t := range timeline.Transitions {
+		if t.ID == id {
+			return i, nil
+		}
+	}
+	return -1, invalidOperation("transition %s is not on the timeline", id)
+}
+
+// hasSource reports whether a clip plays part of a media file, so that
+// trimming and splitting it moves its source start
+func hasSource(item models.TimelineItem) bool {
+	return item.Type == "video" || item.Type == "audio"
+}
+
+func addClip(timeline *models.Timeline, op *models.TimelineOperation) (*models.TimelineOperation, error) {
+	if op.Clip == nil {
+		return nil, invalidOperation("clip is required")
+	}
+	clip := *op.Clip
+	if clip.ID == "" {
+		return nil, invalidOperation("clip needs an id")
+	}
+	if _, err := findClip(timeline, clip.ID); err == nil {
+		return nil, invalidOperation("clip %s is already on the timeline", clip.ID)
+	}
+	if clip.EndTime == 0 && clip.Duration > 0 {
+		clip.EndTime = roundMillis(clip.StartTime + clip.Duration)
+	}
+
+	index := len(timeline.MediaItems)
+	if op.Index != nil {
+		if *op.Index < 0 || *op.Index > index {
+			return nil, invalidOperation("index %d is out of range", *op.Index)
+		}
+		index = *op.Index
+	}
+	timeline.MediaItems = append(timeline.MediaItems, models.TimelineItem{})
+	copy(timeline.MediaItems[index+1:], timeline.MediaItems[index:])
+	timeline.MediaItems[index] = clip
+
+	for _, t := range op.Transitions {
+		if t.FromClipID != clip.ID && t.ToClipID != clip.ID {
+			return nil, invalidOperation("transition %s does not involve clip %s", t.ID, clip.ID)
+		}
+		timeline.Transitions = append(timeline.Transitions, t)
+	}
+	return &models.TimelineOperation{Type: models.OpDeleteClip, ClipID: clip.ID}, nil
+}
+
+// deleteClip removes a clip along with its transitions, which the inverse
+// puts back
+func deleteClip(timeline *models.Timeline, op *models.TimelineOperation) (*models.TimelineOperation, error) {
+	index, err := findClip(timeline, op.ClipID)
+	if err != nil {
+		return nil, err
+	}
+	clip := timeline.MediaItems[index]
+	timeline.MediaItems = append(timeline.MediaItems[:index], timeline.MediaItems[index+1:]...)
+
+	var removed, kept []models.Transition
+	for _, t := range timeline.Transitions {
+		if t.FromClipID == clip.ID || t.ToClipID == clip.ID {
+			removed = append(removed, t)
+			continue
+		}
+		kept = append(kept, t)
+	}
+	timeline.Transitions = kept
+	return &models.TimelineOperation{Type: models.OpAddClip, Clip: &clip, Index: &index, Transitions: removed}, nil
+}
+
+// moveClip changes the track or start time of a clip, keeping its length
+func moveClip(timeline *models.Timeline, op *models.TimelineOperation) (*models.TimelineOperation, error) {
+	index, err := findClip(timeline, op.ClipID)
+	if err != nil {
+		return nil, err
+	}
+	if op.Track == nil && op.StartTime == nil {
+		return nil, invalidOperation("move_clip needs a track or start_time")
+	}
+	clip := &timeline.MediaItems[index]
+	track, start := clip.Track, clip.StartTime
+	if op.Track != nil {
+		clip.Track = *op.Track
+	}
+	if op.StartTime != nil {
+		clip.EndTime = roundMillis(*op.StartTime + clip.EndTime - clip.StartTime)
+		clip.StartTime = *op.StartTime
+	}
+	return &models.TimelineOperation{Type: models.OpMoveClip, ClipID: clip.ID, Track: &track, StartTime: &start}, nil
+}
+
+// trimClip sets where a clip starts on the timeline, where it starts in its
+// source and how long it plays. Fields left out keep their values.
+func trimClip(timeline *models.Timeline, op *models.TimelineOperation) (*models.TimelineOperation, error) {
+	index, err := findClip(timeline, op.ClipID)
+	if err != nil {
+		return nil, err
+	}
+	if op.StartTime == nil && op.SourceStart == nil && op.Duration == nil {
+		return nil, invalidOperation("trim_clip needs a start_time, source_start or duration")
+	}
+	clip := &timeline.MediaItems[index]
+	sourceStart, duration := clipSpan(*clip)
+	start := clip.StartTime
+	if op.SourceStart != nil {
+		if !hasSource(*clip) {
+			return nil, invalidOperation("%s clips have no source to trim", clip.Type)
+		}
+		clip.SourceStart = *op.SourceStart
+	}
+	if op.StartTime != nil {
+		clip.StartTime = *op.StartTime
+	}
+	if op.Duration != nil {
+		if *op.Duration <= 0 {
+			return nil, invalidOperation("duration must be positive")
+		}
+		clip.Duration = *op.Duration
+	} else {
+		clip.Duration = duration
+	}
+	clip.EndTime = roundMillis(clip.StartTime + clip.Duration)
+	inverse := &models.TimelineOperation{Type: models.OpTrimClip, ClipID: clip.ID, StartTime: &start, Duration: &duration}
+	if hasSource(*clip) {
+		inverse.SourceStart = &sourceStart
+	}
+	return inverse, nil
+}
+
+// splitClip cuts a clip in two at a timeline time. The second part gets
+// NewClipID, or a generated ID, and takes over the clip's outgoing transitions.
+func splitClip(timeline *models.Timeline, op *models.TimelineOperation) (*models.TimelineOperation, error) {
+	index, err := findClip(timeline, op.ClipID)
+	if err != nil {
+		return nil, err
+	}
+	if op.At == nil {
+		return nil, invalidOperation("split_clip needs a time to split at")
+	}
+	clip := timeline.MediaItems[index]
+	at := *op.At
+	if at <= clip.StartTime+timeEpsilon || at >= clip.EndTime-timeEpsilon {
+		return nil, invalidOperation("%g is not inside clip %s", at, clip.ID)
+	}
+	if op.NewClipID == "" {
+		for n := 2; ; n++ {
+			id := fmt.Sprintf("%s-%d", clip.ID, n)
+			if _, err := findClip(timeline, id); err != nil {
+				op.NewClipID = id
+				break
+			}
+		}
+	} else if _, err := findClip(timeline, op.NewClipID); err == nil {
+		return nil, invalidOperation("clip %s is already on the timeline", op.NewClipID)
+	}
+
+	sourceStart, duration := clipSpan(clip)
+	first, second := clip, clip
+	first.EndTime = roundMillis(at)
+	first.Duration = roundMillis(at - clip.StartTime)
+	second.ID = op.NewClipID
+	second.StartTime = roundMillis(at)
+	second.Duration = roundMillis(duration - first.Duration)
+	if hasSource(clip) {
+		second.SourceStart = roundMillis(sourceStart + first.Duration)
+	}
+
+	timeline.MediaItems[index] = first
+	timeline.MediaItems = append(timeline.MediaItems, models.TimelineItem{})
+	copy(timeline.MediaItems[index+2:], timeline.MediaItems[index+1:])
+	timeline.MediaItems[index+1] = second
+	for i, t := range timeline.Transitions {
+		if t.FromClipID == clip.ID {
+			timeline.Transitions[i].FromClipID = second.ID
+		}
+	}
+	return &models.TimelineOperation{Type: models.OpJoinClips, ClipID: clip.ID, NewClipID: second.ID}, nil
+}
+
+// joinClips merges NewClipID into the clip just before it, undoing a split.
+// The clips must be back to back pieces of the same source with the same
+// properties, and not joined by a transition.
+func joinClips(timeline *models.Timeline, op *models.TimelineOperation) (*models.TimelineOperation, error) {
+	index, err := findClip(timeline, op.ClipID)
+	if err != nil {
+		return nil, err
+	}
+	nextIndex, err := findClip(timeline, op.NewClipID)
+	if err != nil {
+		return nil, err
+	}
+	first, second := timeline.MediaItems[index], timeline.MediaItems[nextIndex]
+	firstStart, firstDuration := clipSpan(first)
+	secondStart, secondDuration := clipSpan(second)
+	if math.Abs(second.StartTime-first.EndTime) > timeEpsilon {
+		return nil, invalidOperation("clip %s does not start where clip %s ends", second.ID, first.ID)
+	}
+	if hasSource(first) && math.Abs(secondStart-(firstStart+firstDuration)) > timeEpsilon {
+		return nil, invalidOperation("clip %s does not continue the source of clip %s", second.ID, first.ID)
+	}
+	compare := second
+	compare.ID, compare.StartTime, compare.EndTime = first.ID, first.StartTime, first.EndTime
+	compare.SourceStart, compare.Duration = first.SourceStart, first.Duration
+	if fields := changedFields(first, compare); len(fields) > 0 {
+		return nil, invalidOperation("clips %s and %s differ in %v", first.ID, second.ID, fields)
+	}
+	for _, t := range timeline.Transitions {
+		if t.FromClipID == first.ID && t.ToClipID == second.ID {
+			return nil, invalidOperation("transition %s joins the clips", t.ID)
+		}
+	}
+
+	timeline.MediaItems[index].EndTime = second.EndTime
+	timeline.MediaItems[index].Duration = roundMillis(firstDuration + secondDuration)
+	timeline.MediaItems = append(timeline.MediaItems[:nextIndex], timeline.MediaItems[nextIndex+1:]...)
+	for i, t := range timeline.Transitions {
+		if t.FromClipID == second.ID {
+			timeline.Transitions[i].FromClipID = first.ID
+		}
+		if t.ToClipID == second.ID {
+			timeline.Transitions[i].ToClipID = first.ID
+		}
+	}
+	at := second.StartTime
+	return &models.TimelineOperation{Type: models.OpSplitClip, ClipID: first.ID, At: &at, NewClipID: second.ID}, nil
+}
+
+// setProperty changes one of clipProperties. The clip goes through its JSON
+// form so that properties are named and typed as the editor sends them; a
+// null value clears the property.
+func setProperty(timeline *models.Timeline, op *models.TimelineOperation) (*models.TimelineOperation, error) {
+	index, err := findClip(timeline, op.ClipID)
+	if err != nil {
+		return nil, err
+	}
+	if !clipProperties[op.Property] {
+		return nil, invalidOperation("property %q cannot be set", op.Property)
+	}
+
+	encoded, err := json.Marshal(timeline.MediaItems[index])
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, err
+	}
+	old := fields[op.Property]
+	if len(op.Value) == 0 || string(op.Value) == "null" {
+		delete(fields, op.Property)
+	} else {
+		fields[op.Property] = op.Value
+	}
+	if encoded, err = json.Marshal(fields); err != nil {
+		return nil, err
+	}
+	var clip models.TimelineItem
+	if err := json.Unmarshal(encoded, &clip); err != nil {
+		return nil, invalidOperation("invalid value for %s: %v", op.Property, err)
+	}
+	timeline.MediaItems[index] = clip
+	return &models.TimelineOperation{Type: models.OpSetProperty, ClipID: clip.ID, Property: op.Property, Value: old}, nil
+}
+
+// addTransition blends two clips on the same track. The transition can last
+// no longer than either clip.
+func addTransition(timeline *models.Timeline, op *models.TimelineOperation) (*models.TimelineOperation, error) {
+	if op.Transition == nil {
+		return nil, invalidOperation("transition is required")
+	}
+	from, err := findClip(timeline, op.Transition.FromClipID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := findClip(timeline, op.Transition.ToClipID)
+	if err != nil {
+		return nil, err
+	}
+	fromClip, toClip := timeline.MediaItems[from], timeline.MediaItems[to]
+	if fromClip.Track != toClip.Track {
+		return nil, invalidOperation("clips %s and %s are on different tracks", fromClip.ID, toClip.ID)
+	}
+	_, fromDuration := clipSpan(fromClip)
+	_, toDuration := clipSpan(toClip)
+	if op.Transition.Duration > math.Min(fromDuration, toDuration) {
+		return nil, invalidOperation("transition is longer than its clips")
+	}
+	for _, t := range timeline.Transitions {
+		if t.FromClipID == fromClip.ID && t.ToClipID == toClip.ID {
+			return nil, invalidOperation("transition %s already joins the clips", t.ID)
+		}
+	}
+	if op.Transition.ID == "" {
+		op.Transition.ID = fmt.Sprintf("%s-to-%s", fromClip.ID, toClip.ID)
+	}
+	if _, err := findTransition(timeline, op.Transition.ID); err == nil {
+		return nil, invalidOperation("transition %s is already on the timeline", op.Transition.ID)
+	}
+	timeline.Transitions = append(timeline.Transitions, *op.Transition)
+	return &models.TimelineOperation{Type: models.OpRemoveTransition, TransitionID: op.Transition.ID}, nil
+}
+
+func removeTransition(timeline *models.Timeline, op *models.TimelineOperation) (*models.TimelineOperation, error) {
+	index, err := findTransition(timeline, op.TransitionID)
+	if err != nil {
+		return nil, err
+	}
+	transition := timeline.Transitions[index]
+	timeline.Transitions = append(timeline.Transitions[:index], timeline.Transitions[index+1:]...)
+	return &models.TimelineOperation{Type: models.OpAddTransition, Transition: &transition}, nil
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"video-editor/models"
+)
+
+func testTimeline() *models.Timeline {
+	return &models.Timeline{
+		AspectRatio: "16:9",
+		Duration:    8,
+		MediaItems: []models.TimelineItem{
+			{ID: "v1", Type: "video", AssetID: "a", Track: 0, StartTime: 0, EndTime: 4, Duration: 4, SourceStart: 2},
+			{ID: "v2", Type: "video", AssetID: "b", Track: 0, StartTime: 4, EndTime: 8, Duration: 4},
+			{ID: "a1", Type: "audio", AssetID: "c", Track: 1, StartTime: 0, EndTime: 6, Duration: 6, SourceStart: 1},
+			{ID: "i1", Type: "image", AssetID: "d", Track: 2, StartTime: 1, EndTime: 3, Duration: 2},
+			{ID: "t1", Type: "text", Text: "Title", FontSize: 48, Track: 3, StartTime: 2, EndTime: 5, Duration: 3},
+		},
+		Transitions: []models.Transition{
+			{ID: "v1-to-v2", Type: "fade", FromClipID: "v1", ToClipID: "v2", Duration: 1},
+		},
+	}
+}
+
+func TestApplyOperationInverse(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	n := func(v int) *int { return &v }
+	tests := []struct {
+		name string
+		op   models.TimelineOperation
+	}{
+		{"add video", models.TimelineOperation{Type: models.OpAddClip, Clip: &models.TimelineItem{ID: "v3", Type: "video", AssetID: "e", StartTime: 8, Duration: 2}}},
+		{"add text at index", models.TimelineOperation{Type: models.OpAddClip, Index: n(1), Clip: &models.TimelineItem{ID: "t2", Type: "text", Text: "Hi", Track: 4, StartTime: 0, EndTime: 1, Duration: 1}}},
+		{"delete video with transition", models.TimelineOperation{Type: models.OpDeleteClip, ClipID: "v2"}},
+		{"delete image", models.TimelineOperation{Type: models.OpDeleteClip, ClipID: "i1"}},
+		{"delete text", models.TimelineOperation{Type: models.OpDeleteClip, ClipID: "t1"}},
+		{"move audio", models.TimelineOperation{Type: models.OpMoveClip, ClipID: "a1", Track: n(5), StartTime: f(3)}},
+		{"move past end", models.TimelineOperation{Type: models.OpMoveClip, ClipID: "v2", StartTime: f(10)}},
+		{"move text", models.TimelineOperation{Type: models.OpMoveClip, ClipID: "t1", StartTime: f(0.5)}},
+		{"trim video", models.TimelineOperation{Type: models.OpTrimClip, ClipID: "v1", StartTime: f(0.5), SourceStart: f(2.5), Duration: f(3.5)}},
+		{"trim audio", models.TimelineOperation{Type: models.OpTrimClip, ClipID: "a1", Duration: f(2)}},
+		{"trim image", models.TimelineOperation{Type: models.OpTrimClip, ClipID: "i1", StartTime: f(2), Duration: f(4)}},
+		{"trim text", models.TimelineOperation{Type: models.OpTrimClip, ClipID: "t1", Duration: f(1.5)}},
+		{"split video", models.TimelineOperation{Type: models.OpSplitClip, ClipID: "v1", At: f(1.5)}},
+		{"split image", models.TimelineOperation{Type: models.OpSplitClip, ClipID: "i1", At: f(2), NewClipID: "i2"}},
+		{"split text", models.TimelineOperation{Type: models.OpSplitClip, ClipID: "t1", At: f(3)}},
+		{"set text property", models.TimelineOperation{Type: models.OpSetProperty, ClipID: "t1", Property: "text", Value: json.RawMessage(`"Credits"`)}},
+		{"set new property", models.TimelineOperation{Type: models.OpSetProperty, ClipID: "i1", Property: "x", Value: json.RawMessage(`120`)}},
+		{"clear property", models.TimelineOperation{Type: models.OpSetProperty, ClipID: "t1", Property: "fontSize", Value: json.RawMessage(`null`)}},
+		{"add transition", models.TimelineOperation{Type: models.OpAddTransition, Transition: &models.Transition{Type: "wipeleft", FromClipID: "i1", ToClipID: "t1", Duration: 0.5}}},
+		{"remove transition", models.TimelineOperation{Type: models.OpRemoveTransition, TransitionID: "v1-to-v2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := testTimeline()
+			if tt.op.Type == models.OpAddTransition {
+				// Put the image and text clips on one track so they can be joined
+				original.MediaItems[4].Track = 2
+			}
+			want := mustJSON(t, original)
+
+			edited, inverse, err := ApplyOperation(original, &tt.op)
+			if err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			if mustJSON(t, edited) == want {
+				t.Fatalf("operation did not change the timeline")
+			}
+			if mustJSON(t, original) != want {
+				t.Fatalf("operation modified the timeline passed in")
+			}
+
+			reverted, redo, err := ApplyOperation(edited, inverse)
+			if err != nil {
+				t.Fatalf("apply inverse %+v: %v", inverse, err)
+			}
+			if got := mustJSON(t, reverted); got != want {
+				t.Errorf("inverse gave\n%s\nwant\n%s", got, want)
+			}
+
+			// The inverse of the inverse redoes the operation
+			redone, _, err := ApplyOperation(reverted, redo)
+			if err != nil {
+				t.Fatalf("redo %+v: %v", redo, err)
+			}
+			if got, want := mustJSON(t, redone), mustJSON(t, edited); got != want {
+				t.Errorf("redo gave\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyOperationErrors(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name string
+		op   models.TimelineOperation
+	}{
+		{"unknown type", models.TimelineOperation{Type: "explode"}},
+		{"missing clip", models.TimelineOperation{Type: models.OpDeleteClip, ClipID: "nope"}},
+		{"duplicate clip", models.TimelineOperation{Type: models.OpAddClip, Clip: &models.TimelineItem{ID: "v1", Type: "video"}}},
+		{"source start on text", models.TimelineOperation{Type: models.OpTrimClip, ClipID: "t1", SourceStart: f(1)}},
+		{"source start on image", models.TimelineOperation{Type: models.OpTrimClip, ClipID: "i1", SourceStart: f(1)}},
+		{"split at edge", models.TimelineOperation{Type: models.OpSplitClip, ClipID: "v1", At: f(4)}},
+		{"join with transition", models.TimelineOperation{Type: models.OpJoinClips, ClipID: "v1", NewClipID: "v2"}},
+		{"protected property", models.TimelineOperation{Type: models.OpSetProperty, ClipID: "v1", Property: "startTime", Value: json.RawMessage(`3`)}},
+		{"transition across tracks", models.TimelineOperation{Type: models.OpAddTransition, Transition: &models.Transition{Type: "fade", FromClipID: "v1", ToClipID: "a1", Duration: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ApplyOperation(testTimeline(), &tt.op); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestExportDuration(t *testing.T) {
+	// A client that leaves out the duration still renders until the last clip ends
+	missing := testTimeline()
+	missing.Duration = 0
+	tests := []struct {
+		name     string
+		timeline *models.Timeline
+		want     float64
+	}{
+		{"stated", testTimeline(), 8},
+		{"missing", missing, contentEnd(missing)},
+		{"longer than clips", &models.Timeline{Duration: 30, MediaItems: testTimeline().MediaItems}, 30},
+		{"empty", &models.Timeline{}, 0},
+		{"nil", nil, 0},
+	}
+	for _, tt := range tests {
+		if got := ExportDuration(tt.timeline); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if contentEnd(missing) == 0 {
+		t.Fatal("test timeline has no clips")
+	}
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"video-editor/models"
+)
+
+// sniffLen is the number of leading bytes inspected to detect a file's type
+const sniffLen = 512
+
+// maxDisplayNameLen caps the length of a sanitized client filename
+const maxDisplayNameLen = 255
+
+// UploadError describes why an uploaded file was rejected
+type UploadError struct {
+	Code    string `json:"code"`  // Machine-readable reason, e.g. "file_too_large"
+	Message string `json:"error"` // Human-readable explanation
+	Status  int    `json:"-"`     // HTTP status for a single-file response
+}
+
+func (e *UploadError) Error() string {
+	return e.Message
+}
+
+// UploadPolicy is the set of limits every upload is validated against
+type UploadPolicy struct {
+	MaxFileSize       int64
+	MaxDuration       time.Duration
+	AllowedMIMETypes  []string
+	AllowedContainers []string
+	AllowedCodecs     []string
+}
+
+// CheckSize rejects files larger than MaxFileSize
+func (p *UploadPolicy) CheckSize(size int64) error {
+	if p.MaxFileSize > 0 && size > p.MaxFileSize {
+		return &UploadError{
+			Code:    "file_too_large",
+			Message: fmt.Sprintf("file is %d bytes, the limit is %d bytes", size, p.MaxFileSize),
+			Status:  http.StatusRequestEntityTooLarge,
+		}
+	}
+	return nil
+}
+
+// CheckContentType rejects sniffed content types outside the allow-list
+func (p *UploadPolicy) CheckContentType(contentType string) error {
+	if !containsString(p.AllowedMIMETypes, contentType) {
+		return &UploadError{
+			Code:    "unsupported_type",
+			Message: fmt.Sprintf("file content %q is not an accepted media type", contentType),
+			Status:  http.StatusUnsupportedMediaType,
+		}
+	}
+	return nil
+}
+
+// CheckMetadata verifies the probed container, codecs and duration
+func (p *UploadPolicy) CheckMetadata(meta *models.MediaMetadata) error {
+	containerAllowed := false
+	for _, name := range strings.Split(meta.FormatName, ",") {
+		if containsString(p.AllowedContainers, name) {
+			containerAllowed = true
+			break
+		}
+	}
+	if !containerAllowed {
+		return &UploadError{
+			Code:    "unsupported_container",
+			Message: fmt.Sprintf("container %q is not accepted", meta.FormatName),
+			Status:  http.StatusUnsupportedMediaType,
+		}
+	}
+
+	mediaStreams := 0
+	for _, s := range meta.Streams {
+		// Subtitle, timecode and other data streams are ignored
+		if s.CodecType != "video" && s.CodecType != "audio" {
+			continue
+		}
+		mediaStreams++
+		if !containsString(p.AllowedCodecs, s.CodecName) {
+			return &UploadError{
+				Code:    "unsupported_codec",
+				Message: fmt.Sprintf("%s codec %q in stream %d is not accepted", s.CodecType, s.CodecName, s.Index),
+				Status:  http.StatusUnsupportedMediaType,
+			}
+		}
+	}
+	if mediaStreams == 0 {
+		return &UploadError{
+			Code:    "unreadable_media",
+			Message: "file contains no audio or video streams",
+			Status:  http.StatusUnprocessableEntity,
+		}
+	}
+
+	if p.MaxDuration > 0 && meta.Duration > p.MaxDuration.Seconds() {
+		return &UploadError{
+			Code:    "duration_exceeded",
+			Message: fmt.Sprintf("media is %.0fs long, the limit is %.0fs", meta.Duration, p.MaxDuration.Seconds()),
+			Status:  http.StatusUnprocessableEntity,
+		}
+	}
+	return nil
+}
+
+// SniffFile detects a file's content type from its leading bytes
+func SniffFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, sniffLen)
+	n, err := f.Read(header)
+	if err != nil && n == 0 {
+		return "", err
+	}
+	return SniffContentType(header[:n]), nil
+}
+
+// SniffContentType extends http.DetectContentType with the media containers it
+// does not recognise (QuickTime, Matroska, Ogg, FLAC, raw AAC and MP3 frames).
+func SniffContentType(header []byte) string {
+	switch {
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		switch brand := string(header[8:12]); {
+		case brand == "qt  ":
+			return "video/quicktime"
+		case strings.HasPrefix(brand, "M4A"):
+			return "audio/mp4"
+		default:
+			return "video/mp4"
+		}
+	case bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		// EBML header; the DocType tells WebM and Matroska apart
+		if bytes.Contains(header, []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	case bytes.HasPrefix(header, []byte("OggS")):
+		// http.DetectContentType calls every Ogg stream application/ogg; the
+		// first page's codec header tells Theora video from Opus or Vorbis
+		if bytes.Contains(header, []byte("\x80theora")) {
+			return "video/ogg"
+		}
+		return "audio/ogg"
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return "audio/flac"
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xF6 == 0xF0:
+		// ADTS sync word with layer bits 00
+		return "audio/aac"
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		// MPEG audio frame sync without an ID3 tag
+		return "audio/mpeg"
+	}
+
+	contentType := http.DetectContentType(header)
+	// Drop parameters such as "; charset=utf-8"
+	contentType, _, _ = strings.Cut(contentType, ";")
+	return contentType
+}
+
+var unsafeExtChars = regexp.MustCompile(`[^a-z0-9]`)
+
+// StorageFilename returns a random name for storing an upload on disk, keeping
+// a sanitized extension from the client filename.
+func StorageFilename(originalName string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	name := hex.EncodeToString(buf)
+
+	ext := unsafeExtChars.ReplaceAllString(strings.ToLower(filepath.Ext(originalName)), "")
+	if ext != "" && len(ext) <= 8 {
+		name += "." + ext
+	}
+	return name, nil
+}
+
+// SanitizeFilename makes a client filename safe to store and display
+func SanitizeFilename(name string) string {
+	// Clients may send Windows paths; keep only the last element
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '/' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		return "upload"
+	}
+	if len(name) > maxDisplayNameLen {
+		name = strings.ToValidUTF8(name[:maxDisplayNameLen], "")
+	}
+	return name
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSniffContentType(t *testing.T) {
+	// oggPage is the start of an Ogg stream whose first packet is header
+	oggPage := func(header string) string {
+		return "OggS\x00\x02" + strings.Repeat("\x00", 20) + "\x01\x13" + header
+	}
+	tests := map[string]struct {
+		header string
+		want   string
+	}{
+		"mp4":        {"\x00\x00\x00\x20ftypisom\x00\x00\x02\x00", "video/mp4"},
+		"quicktime":  {"\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00", "video/quicktime"},
+		"m4a":        {"\x00\x00\x00\x1cftypM4A \x00\x00\x00\x00", "audio/mp4"},
+		"webm":       {"\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm", "video/webm"},
+		"matroska":   {"\x1a\x45\xdf\xa3\xa3\x42\x86\x81\x01\x42\x82\x88matroska", "video/x-matroska"},
+		"opus":       {oggPage("OpusHead\x01\x02"), "audio/ogg"},
+		"vorbis":     {oggPage("\x01vorbis\x00\x00\x00\x00"), "audio/ogg"},
+		"theora":     {oggPage("\x80theora\x03\x02"), "video/ogg"},
+		"flac":       {"fLaC\x00\x00\x00\x22", "audio/flac"},
+		"adts aac":   {"\xff\xf1\x50\x80\x02\x1f\xfc", "audio/aac"},
+		"mp3 frame":  {"\xff\xfb\x90\x64\x00", "audio/mpeg"},
+		"mp3 id3":    {"ID3\x04\x00\x00\x00\x00\x00\x00", "audio/mpeg"},
+		"wav":        {"RIFF\x24\x08\x00\x00WAVEfmt ", "audio/wave"},
+		"avi":        {"RIFF\x24\x08\x00\x00AVI LIST", "video/avi"},
+		"png":        {"\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR", "image/png"},
+		"plain text": {"hello, world", "text/plain"},
+	}
+	for name, tt := range tests {
+		if got := SniffContentType([]byte(tt.header)); got != tt.want {
+			t.Errorf("%s: got %s, want %s", name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"video-editor/db"
+	"video-editor/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Errors returned by UploadService, mapped to tus status codes by the HTTP layer
+var (
+	ErrUploadNotFound     = errors.New("upload not found or expired")
+	ErrUploadOffset       = errors.New("upload offset does not match")
+	ErrUploadTooLarge     = errors.New("upload exceeds maximum size")
+	ErrUploadComplete     = errors.New("upload is already complete")
+	ErrChecksumMismatch   = errors.New("checksum mismatch")
+	ErrChecksumAlgorithm  = errors.New("unsupported checksum algorithm")
+	ErrUploadInvalidParam = errors.New("invalid upload parameters")
+)
+
+// SupportedChecksumAlgorithms lists the Upload-Checksum algorithms accepted for chunks
+var SupportedChecksumAlgorithms = []string{"sha1", "sha256", "md5"}
+
+// UploadService implements resumable (tus) uploads. Chunks are appended to a
+// partial file; once complete, the file is ingested like a regular upload.
+type UploadService struct {
+	sessionsCollection *mongo.Collection
+	assetService       *AssetService
+	tempDir            string
+	expiry             time.Duration
+	maxSize            int64
+
+	// locks serializes writes to the same upload. An entry lives only while
+	// a request holds or waits for it, so finished, terminated and expired
+	// uploads leave nothing behind.
+	locksMu sync.Mutex
+	locks   map[string]*uploadLock
+}
+
+// uploadLock is the mutex of one upload, with the number of requests holding
+// or waiting for it
+type uploadLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// NewUploadService creates a new UploadService
+func NewUploadService(client *mongo.Client, dbName string, assetService *AssetService, tempDir string, expiry time.Duration, maxSize int64) *UploadService {
+	return &UploadService{
+		sessionsCollection: client.Database(dbName).Collection("upload_sessions"),
+		assetService:       assetService,
+		tempDir:            tempDir,
+		expiry:             expiry,
+		maxSize:            maxSize,
+		locks:              make(map[string]*uploadLock),
+	}
+}
+
+// MaxSize returns the largest upload length accepted
+func (s *UploadService) MaxSize() int64 {
+	return s.maxSize
+}
+
+// CreateSession starts a new resumable upload of the given length
+func (s *UploadService) CreateSession(userID string, length int64, metadata map[string]string) (*models.UploadSession, error) {
+	if length <= 0 {
+		return nil, ErrUploadInvalidParam
+	}
+	if length > s.maxSize {
+		return nil, ErrUploadTooLarge
+	}
+	if err := s.assetService.Policy().CheckSize(length); err != nil {
+		return nil, err
+	}
+	if err := s.assetService.CheckQuota(userID, length); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.tempDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload temp directory: %v", err)
+	}
+
+	filename := SanitizeFilename(metadata["filename"])
+
+	session := &models.UploadSession{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Filename:  filename,
+		Length:    length,
+		Metadata:  metadata,
+		ExpiresAt: time.Now().Add(s.expiry),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	session.TempPath = filepath.Join(s.tempDir, session.ID.Hex()+".part")
+
+	f, err := os.Create(session.TempPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create partial file: %v", err)
+	}
+	f.Close()
+
+	if _, err := s.sessionsCollection.InsertOne(db.Ctx, session); err != nil {
+		os.Remove(session.TempPath)
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetSession retrieves an unexpired upload owned by the user
+func (s *UploadService) GetSession(uploadID, userID string) (*models.UploadSession, error) {
+	objID, err := primitive.ObjectIDFromHex(uploadID)
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+
+	session := &models.UploadSession{}
+	filter := bson.M{"_id": objID, "user_id": userID}
+	err = s.sessionsCollection.FindOne(db.Ctx, filter).Decode(session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	if session.AssetID == "" && time.Now().After(session.ExpiresAt) {
+		return nil, ErrUploadNotFound
+	}
+	return session, nil
+}
+
+// WriteChunk appends a chunk at the given offset. checksum is the raw
+// Upload-Checksum header value and may be empty. When the final byte arrives
+// the upload is finalized and the resulting asset returned.
+func (s *UploadService) WriteChunk(uploadID, userID string, offset int64, body io.Reader, checksum string) (*models.UploadSession, *models.MediaAsset, error) {
+	unlock := s.lock(uploadID)
+	defer unlock()
+
+	session, err := s.GetSession(uploadID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if session.Offset == session.Length {
+		return session, nil, ErrUploadComplete
+	}
+	if offset != session.Offset {
+		return session, nil, ErrUploadOffset
+	}
+
+	var hasher hash.Hash
+	var expected []byte
+	if checksum != "" {
+		hasher, expected, err = parseChecksum(checksum)
+		if err != nil {
+			return session, nil, err
+		}
+	}
+
+	f, err := os.OpenFile(session.TempPath, os.O_WRONLY, 0644)
+	if err != nil {
+		return session, nil, fmt.Errorf("failed to open partial file: %v", err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return session, nil, err
+	}
+
+	// The running SHA-256 of the whole file is carried across chunks so the
+	// content digest is ready without re-reading the file at the end.
+	contentHash, err := restoreContentHash(session.HashState)
+	if err != nil {
+		f.Close()
+		return session, nil, err
+	}
+
+	w := io.MultiWriter(f, contentHash)
+	if hasher != nil {
+		w = io.MultiWriter(f, contentHash, hasher)
+	}
+	// Never accept more than the declared length
+	written, copyErr := io.Copy(w, io.LimitReader(body, session.Length-offset))
+	f.Close()
+
+	// A checksum only makes sense for the whole chunk; discard it on mismatch
+	// or interruption so the client can resend from the previous offset.
+	if hasher != nil && (copyErr != nil || !bytes.Equal(hasher.Sum(nil), expected)) {
+		os.Truncate(session.TempPath, offset)
+		if copyErr != nil {
+			return session, nil, copyErr
+		}
+		return session, nil, ErrChecksumMismatch
+	}
+
+	// Without a checksum, keep whatever arrived before a disconnect so the
+	// client can resume from there.
+	session.Offset = offset + written
+	session.HashState, err = contentHash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return session, nil, err
+	}
+	session.ExpiresAt = time.Now().Add(s.expiry)
+	session.UpdatedAt = time.Now()
+	_, err = s.sessionsCollection.UpdateByID(db.Ctx, session.ID, bson.M{"$set": bson.M{
+		"offset":     session.Offset,
+		"hash_state": session.HashState,
+		"expires_at": session.ExpiresAt,
+		"updated_at": session.UpdatedAt,
+	}})
+	if err != nil {
+		return session, nil, err
+	}
+	if copyErr != nil {
+		return session, nil, copyErr
+	}
+
+	if session.Offset < session.Length {
+		return session, nil, nil
+	}
+	asset, err := s.finalize(session, hex.EncodeToString(contentHash.Sum(nil)))
+	if err != nil {
+		return session, nil, err
+	}
+	return session, asset, nil
+}
+
+// TerminateSession cancels an upload and removes its partial file
+func (s *UploadService) TerminateSession(uploadID, userID string) error {
+	unlock := s.lock(uploadID)
+	defer unlock()
+
+	session, err := s.GetSession(uploadID, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.sessionsCollection.DeleteOne(db.Ctx, bson.M{"_id": session.ID}); err != nil {
+		return err
+	}
+	if session.AssetID == "" {
+		os.Remove(session.TempPath)
+	}
+	return nil
+}
+
+// StartCleanupWorker periodically removes expired, unfinished uploads
+func (s *UploadService) StartCleanupWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.cleanupExpired(); err != nil {
+			log.Printf("Failed to clean up expired uploads: %v", err)
+		}
+	}
+}
+
+// cleanupExpired deletes expired sessions that never completed
+func (s *UploadService) cleanupExpired() error {
+	filter := bson.M{"asset_id": bson.M{"$exists": false}, "expires_at": bson.M{"$lt": time.Now()}}
+	cursor, err := s.sessionsCollection.Find(db.Ctx, filter)
+	if err != nil {
+		return err
+	}
+	var expired []models.UploadSession
+	if err := cursor.All(db.Ctx, &expired); err != nil {
+		return err
+	}
+
+	for _, session := range expired {
+		if err := os.Remove(session.TempPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove partial upload %s: %v", session.TempPath, err)
+			continue
+		}
+		if _, err := s.sessionsCollection.DeleteOne(db.Ctx, bson.M{"_id": session.ID}); err != nil {
+			return err
+		}
+		log.Printf("Removed expired upload %s (%s, %d/%d bytes)", session.ID.Hex(), session.Filename, session.Offset, session.Length)
+	}
+	return nil
+}
+
+// finalize ingests a completed upload and links the resulting asset to the session
+func (s *UploadService) finalize(session *models.UploadSession, digest string) (*models.MediaAsset, error) {
+	asset, err := s.assetService.IngestFile(session.UserID, session.Filename, session.TempPath, session.Length, digest)
+	if err != nil {
+		// A rejected file cannot be resumed; drop the session with it
+		s.sessionsCollection.DeleteOne(db.Ctx, bson.M{"_id": session.ID})
+		return nil, err
+	}
+
+	session.AssetID = asset.ID.Hex()
+	_, err = s.sessionsCollection.UpdateByID(db.Ctx, session.ID, bson.M{"$set": bson.M{"asset_id": session.AssetID}})
+	return asset, err
+}
+
+// restoreContentHash resumes a SHA-256 from its marshaled state
+func restoreContentHash(state []byte) (hash.Hash, error) {
+	h := sha256.New()
+	if len(state) > 0 {
+		if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+			return nil, fmt.Errorf("corrupt upload hash state: %v", err)
+		}
+	}
+	return h, nil
+}
+
+// lock acquires the per-upload mutex and returns its release function. The
+// last request to release it removes it.
+func (s *UploadService) lock(uploadID string) func() {
+	s.locksMu.Lock()
+	l, ok := s.locks[uploadID]
+	if !ok {
+		l = &uploadLock{}
+		s.locks[uploadID] = l
+	}
+	l.refs++
+	s.locksMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		s.locksMu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(s.locks, uploadID)
+		}
+		s.locksMu.Unlock()
+	}
+}
+
+// parseChecksum parses an Upload-Checksum header ("<algorithm> <base64 digest>")
+func parseChecksum(header string) (hash.Hash, []byte, error) {
+	algo, encoded, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok {
+		return nil, nil, ErrUploadInvalidParam
+	}
+	expected, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, ErrUploadInvalidParam
+	}
+
+	switch algo {
+	case "sha1":
+		return sha1.New(), expected, nil
+	case "sha256":
+		return sha256.New(), expected, nil
+	case "md5":
+		return md5.New(), expected, nil
+	default:
+		return nil, nil, ErrChecksumAlgorithm
+	}
+}
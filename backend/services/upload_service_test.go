@@ -0,0 +1,52 @@
+package services
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestUploadLock(t *testing.T) {
+	s := &UploadService{locks: make(map[string]*uploadLock)}
+	const writers, writes = 8, 200
+
+	// Writers to one upload never overlap, and those to another don't wait on them
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	holding := make(map[string]int)
+	for i := 0; i < writers; i++ {
+		for _, id := range []string{"a", "b"} {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				for j := 0; j < writes; j++ {
+					unlock := s.lock(id)
+					mu.Lock()
+					holding[id]++
+					n := holding[id]
+					mu.Unlock()
+					if n != 1 {
+						t.Errorf("upload %s: %d writers at once", id, n)
+					}
+					mu.Lock()
+					holding[id]--
+					mu.Unlock()
+					unlock()
+				}
+			}(id)
+		}
+	}
+	wg.Wait()
+
+	// Nothing is kept once every request has released its upload
+	if len(s.locks) != 0 {
+		t.Errorf("%d locks left after every upload was released", len(s.locks))
+	}
+	unlock := s.lock("a")
+	if l := s.locks["a"]; l == nil || l.refs != 1 {
+		t.Errorf("held lock: %+v", l)
+	}
+	unlock()
+	if _, ok := s.locks["a"]; ok {
+		t.Error("lock kept after its only holder released it")
+	}
+}
@@ -10,12 +10,12 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
 	"video-editor/db"
 	"video-editor/models"
+	"video-editor/storage"
 	"video-editor/websocket" // Import the websocket package
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -37,13 +37,21 @@ func AddJobToQueue(job models.VideoProcessingJob) {
 type VideoProcessor struct {
 	jobsCollection     *mongo.Collection
 	projectsCollection *mongo.Collection
+	batchesCollection  *mongo.Collection
+	store              storage.Backend
+	assets             *AssetService
+	workDir            string // Scratch space for staged inputs and render output
 }
 
 // NewVideoProcessor creates a new VideoProcessor
-func NewVideoProcessor(client *mongo.Client, dbName string) *VideoProcessor {
+func NewVideoProcessor(client *mongo.Client, dbName string, store storage.Backend, assets *AssetService, workDir string) *VideoProcessor {
 	return &VideoProcessor{
 		jobsCollection:     client.Database(dbName).Collection("video_jobs"),
 		projectsCollection: client.Database(dbName).Collection("projects"),
+		batchesCollection:  client.Database(dbName).Collection("render_batches"),
+		store:              store,
+		assets:             assets,
+		workDir:            workDir,
 	}
 }
 
@@ -56,6 +64,8 @@ func (vp *VideoProcessor) StartWorker(hub *websocket.Hub) {
 		if err := vp.updateJobStatus(job.ID, "processing", ""); err != nil {
 			log.Printf("Failed to update job %s status to processing: %v", job.ID.Hex(), err)
 			hub.BroadcastToUser(job.UserID, fmt.Sprintf("Job %s: Failed to start processing.", job.ID.Hex()))
+			// The batch would otherwise wait for this row forever
+			vp.finishBatchRow(hub, job, "", fmt.Errorf("failed to start processing: %v", err))
 			continue
 		}
 		hub.BroadcastToUser(job.UserID, fmt.Sprintf("Job %s: Processing...", job.ID.Hex()))
@@ -68,8 +78,10 @@ func (vp *VideoProcessor) StartWorker(hub *websocket.Hub) {
 				log.Printf("Failed to update job %s status to failed: %v", job.ID.Hex(), err)
 			}
 			hub.BroadcastToUser(job.UserID, fmt.Sprintf("Job %s: Failed! %v", job.ID.Hex(), err))
+			vp.finishBatchRow(hub, job, "", err)
 			continue
 		}
+		vp.finishBatchRow(hub, job, outputURL, nil)
 
 		// Update job status to completed in DB
 		if err := vp.updateJobStatus(job.ID, "completed", outputURL); err != nil {
@@ -79,8 +91,10 @@ func (vp *VideoProcessor) StartWorker(hub *websocket.Hub) {
 		}
 
 		// Also update the project's status and output URL
-		if err := vp.updateProjectStatus(job.ProjectID, "completed", outputURL); err != nil {
-			log.Printf("Failed to update project %s status to completed: %v", job.ProjectID, err)
+		if job.ProjectID != "" {
+			if err := vp.updateProjectStatus(job.ProjectID, "completed", outputURL); err != nil {
+				log.Printf("Failed to update project %s status to completed: %v", job.ProjectID, err)
+			}
 		}
 
 		log.Printf("Job %s completed. Output: %s", job.ID.Hex(), outputURL)
@@ -90,21 +104,32 @@ func (vp *VideoProcessor) StartWorker(hub *websocket.Hub) {
 
 // executeFFmpeg constructs and runs FFmpeg commands
 func (vp *VideoProcessor) executeFFmpeg(job models.VideoProcessingJob) (string, error) {
-	// In a real app, you'd fetch the project to get the original video URL.
-	// For POC, let's assume `input_video.mp4` exists locally for demonstration.
-	// You'd download from cloud storage (e.g., GCS) here.
-	inputPath := "input_video.mp4" // Placeholder: replace with actual downloaded path from cloud storage
+	if job.Action == "export" {
+		// Handle full project export
+		return vp.executeProjectExport(job)
+	}
+
+	// Other actions edit the project's source video
+	project, err := vp.getProject(job.ProjectID, job.UserID)
+	if err != nil {
+		return "", err
+	}
+	inputPath, cleanup, err := vp.stageInput(job.UserID, "", project.VideoURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to stage source video: %v", err)
+	}
+	defer cleanup()
 
+	if err := os.MkdirAll(vp.workDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create work directory: %v", err)
+	}
 	outputFileName := fmt.Sprintf("output_%s.mp4", job.ID.Hex())
-	outputPath := outputFileName // For POC, output to local file. In production, upload to cloud storage.
+	outputPath := filepath.Join(vp.workDir, outputFileName)
+	defer os.Remove(outputPath)
 
 	var cmdArgs []string
 
 	switch job.Action {
-	case "export":
-		// Handle full project export
-		return vp.executeProjectExport(job)
-
 	case "trim":
 		// Expecting params: {"start_time": float64, "end_time": float64}
 		startTime, ok1 := job.Params["start_time"].(float64)
@@ -159,15 +184,65 @@ func (vp *VideoProcessor) executeFFmpeg(job models.VideoProcessingJob) (string,
 	cmd.Stdout = &out
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	err = cmd.Run()
 	if err != nil {
 		return "", fmt.Errorf("ffmpeg command failed: %v\nStdout: %s\nStderr: %s", err, out.String(), stderr.String())
 	}
 
-	// In production, you would upload `outputPath` to cloud storage here
-	// and return the cloud storage URL.
 	log.Printf("FFmpeg output: %s", out.String())
-	return "http://your-cloud-storage.com/" + outputPath, nil // Simulated URL
+	return vp.storeOutput(job.UserID, outputPath, outputFileName)
+}
+
+// getProject loads a project owned by the user
+func (vp *VideoProcessor) getProject(projectID, userID string) (*models.Project, error) {
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		return nil, errors.New("invalid project ID format")
+	}
+	project := &models.Project{}
+	filter := bson.M{"_id": objID, "user_id": userID, "deleted_at": bson.M{"$exists": false}}
+	err = vp.projectsCollection.FindOne(db.Ctx, filter).Decode(project)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("project not found or unauthorized")
+		}
+		return nil, err
+	}
+	return project, nil
+}
+
+// stageInput makes one of the user's assets available as a local file for
+// ffmpeg. Clips reference assets by ID; a bare URL is only accepted when it
+// points at an asset the user owns, so arbitrary paths can never be read.
+func (vp *VideoProcessor) stageInput(userID, assetID, rawURL string) (string, func(), error) {
+	noop := func() {}
+	var asset *models.MediaAsset
+	var err error
+	switch {
+	case assetID != "":
+		asset, err = vp.assets.GetAsset(assetID, userID)
+	case rawURL != "":
+		key, ok := vp.store.KeyFromURL(rawURL)
+		if !ok {
+			return "", noop, fmt.Errorf("media URL %q is not in storage", rawURL)
+		}
+		asset, err = vp.assets.GetAssetByKey(key, userID)
+	default:
+		return "", noop, errors.New("clip has no asset")
+	}
+	if err != nil {
+		return "", noop, err
+	}
+	return storage.Fetch(vp.store, asset.StorageKey, vp.workDir)
+}
+
+// storeOutput moves a rendered file into the user's exports and returns its URL
+func (vp *VideoProcessor) storeOutput(userID, localPath, name string) (string, error) {
+	key := userID + "/exports/" + name
+	if err := storage.PutFile(vp.store, key, localPath); err != nil {
+		return "", fmt.Errorf("failed to store output: %v", err)
+	}
+	return vp.store.SignedURL(key, signedURLExpiry)
 }
 
 // updateJobStatus updates the status of a video processing job in MongoDB
@@ -221,31 +296,12 @@ func (vp *VideoProcessor) executeProjectExport(job models.VideoProcessingJob) (s
 		return "", fmt.Errorf("failed to marshal project data: %v", err)
 	}
 
-	var projectData struct {
-		MediaItems []struct {
-			ID        string  `json:"id"`
-			Type      string  `json:"type"`
-			URL       string  `json:"url"`
-			Track     int     `json:"track"`
-			StartTime float64 `json:"startTime"`
-			EndTime   float64 `json:"endTime"`
-			Duration  float64 `json:"duration"`
-			X         float64 `json:"x"`
-			Y         float64 `json:"y"`
-			Width     float64 `json:"width"`
-			Height    float64 `json:"height"`
-			Text      string  `json:"text"`
-			Color     string  `json:"color"`
-			FontSize  float64 `json:"fontSize"`
-			IsMuted   bool    `json:"isMuted"`
-		} `json:"mediaItems"`
-		Duration    float64 `json:"duration"`
-		AspectRatio string  `json:"aspectRatio"`
-	}
+	var projectData models.Timeline
 
 	if err := json.Unmarshal(projectDataBytes, &projectData); err != nil {
 		return "", fmt.Errorf("failed to parse project data: %v", err)
 	}
+	projectData.Duration = ExportDuration(&projectData)
 
 	// Parse export settings
 	settingsBytes, err := json.Marshal(settingsInterface)
@@ -253,67 +309,31 @@ func (vp *VideoProcessor) executeProjectExport(job models.VideoProcessingJob) (s
 		return "", fmt.Errorf("failed to marshal settings: %v", err)
 	}
 
-	var settings struct {
-		Quality    string `json:"quality"`    // "high", "medium", "low"
-		Format     string `json:"format"`     // "mp4", "webm", "avi"
-		Resolution string `json:"resolution"` // "1920x1080", "1280x720", "854x480"
-	}
-
+	var settings ExportSettings
 	if err := json.Unmarshal(settingsBytes, &settings); err != nil {
 		return "", fmt.Errorf("failed to parse settings: %v", err)
 	}
-
-	// Set default values
-	if settings.Quality == "" {
-		settings.Quality = "medium"
-	}
-	if settings.Format == "" {
-		settings.Format = "mp4"
-	}
-	if settings.Resolution == "" {
-		settings.Resolution = "1920x1080"
+	if err := settings.Normalize(); err != nil {
+		return "", err
 	}
 
-	// Create output directory for user exports
-	userExportDir := filepath.Join("uploads", job.UserID, "exports")
-	if err := os.MkdirAll(userExportDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create export directory: %v", err)
+	// Render into the work directory, then hand the file to storage
+	if err := os.MkdirAll(vp.workDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create work directory: %v", err)
 	}
-
-	// Generate output filename
 	outputFileName := fmt.Sprintf("export_%s.%s", job.ID.Hex(), settings.Format)
-	outputPath := filepath.Join(userExportDir, outputFileName)
+	outputPath := filepath.Join(vp.workDir, outputFileName)
+	defer os.Remove(outputPath)
 
 	// Build FFmpeg command for complex composition
-	return vp.buildComplexFFmpegCommand(projectData, settings, outputPath)
+	if err := vp.buildComplexFFmpegCommand(job.UserID, projectData, settings, outputPath); err != nil {
+		return "", err
+	}
+	return vp.storeOutput(job.UserID, outputPath, outputFileName)
 }
 
 // buildComplexFFmpegCommand constructs FFmpeg command for complex video composition
-func (vp *VideoProcessor) buildComplexFFmpegCommand(projectData struct {
-	MediaItems []struct {
-		ID        string  `json:"id"`
-		Type      string  `json:"type"`
-		URL       string  `json:"url"`
-		Track     int     `json:"track"`
-		StartTime float64 `json:"startTime"`
-		EndTime   float64 `json:"endTime"`
-		Duration  float64 `json:"duration"`
-		X         float64 `json:"x"`
-		Y         float64 `json:"y"`
-		Width     float64 `json:"width"`
-		Height    float64 `json:"height"`
-		Text      string  `json:"text"`
-		Color     string  `json:"color"`
-		FontSize  float64 `json:"fontSize"`
-		IsMuted   bool    `json:"isMuted"`
-	} `json:"mediaItems"`
-	Duration    float64 `json:"duration"`
-	AspectRatio string  `json:"aspectRatio"`
-}, settings struct {
-	Quality    string `json:"quality"`
-	Format     string `json:"format"`
-	Resolution string `json:"resolution"`
-}, outputPath string) (string, error) {
+func (vp *VideoProcessor) buildComplexFFmpegCommand(userID string, projectData models.Timeline, settings ExportSettings, outputPath string) error {
 
 	// Start building FFmpeg command
 	var cmdArgs []string
@@ -322,12 +342,10 @@ func (vp *VideoProcessor) buildComplexFFmpegCommand(projectData struct {
 	var audioInputs []string
 
 	// Parse resolution
-	resParts := strings.Split(settings.Resolution, "x")
-	if len(resParts) != 2 {
-		return "", errors.New("invalid resolution format")
+	width, height, err := settings.dimensions()
+	if err != nil {
+		return err
 	}
-	width, _ := strconv.Atoi(resParts[0])
-	height, _ := strconv.Atoi(resParts[1])
 
 	// Create blank canvas
 	baseFilter := fmt.Sprintf("color=black:%dx%d:d=%f[base]", width, height, projectData.Duration)
@@ -343,20 +361,11 @@ func (vp *VideoProcessor) buildComplexFFmpegCommand(projectData struct {
 	// Sort media items by track and start time for proper layering
 	for _, item := range projectData.MediaItems {
 		if item.Type == "video" || item.Type == "image" {
-			// Convert URL to local file path
-			var inputPath string
-			if strings.HasPrefix(item.URL, "http://localhost:8080/") {
-				// Remove the server URL prefix to get the local path
-				inputPath = strings.TrimPrefix(item.URL, "http://localhost:8080/")
-			} else {
-				// Handle relative URLs
-				inputPath = strings.TrimPrefix(item.URL, "/")
-			}
-
-			if _, err := os.Stat(inputPath); os.IsNotExist(err) {
-				log.Printf("Warning: Input file does not exist: %s (original URL: %s)", inputPath, item.URL)
-				continue
+			inputPath, cleanup, err := vp.stageInput(userID, item.AssetID, item.URL)
+			if err != nil {
+				return fmt.Errorf("clip %s: %v", item.ID, err)
 			}
+			defer cleanup()
 
 			// Add input file
 			cmdArgs = append(cmdArgs, "-i", inputPath)
@@ -371,7 +380,7 @@ func (vp *VideoProcessor) buildComplexFFmpegCommand(projectData struct {
 
 			if item.Type == "video" {
 				// Scale and position video
-				filterPart := fmt.Sprintf("[%d:v]scale=%d:%d[scaled%d]", inputIndex, w, h, inputIndex)
+				filterPart := fmt.Sprintf("[%d:v]%sscale=%d:%d[scaled%d]", inputIndex, videoTrimFilter(item), w, h, inputIndex)
 				filterComplex = append(filterComplex, filterPart)
 				log.Printf("Added video scale filter: %s", filterPart)
 
@@ -385,8 +394,8 @@ func (vp *VideoProcessor) buildComplexFFmpegCommand(projectData struct {
 
 				// Handle audio if not muted
 				if !item.IsMuted {
-					audioFilter := fmt.Sprintf("[%d:a]adelay=%dms:all=1[audio%d]",
-						inputIndex, int(item.StartTime*1000), inputIndex)
+					audioFilter := fmt.Sprintf("[%d:a]%sadelay=%dms:all=1[audio%d]",
+						inputIndex, audioTrimFilter(item), int(item.StartTime*1000), inputIndex)
 					filterComplex = append(filterComplex, audioFilter)
 					audioInputs = append(audioInputs, fmt.Sprintf("[audio%d]", inputIndex))
 				}
@@ -406,11 +415,18 @@ func (vp *VideoProcessor) buildComplexFFmpegCommand(projectData struct {
 			inputIndex++
 		} else if item.Type == "text" {
 			// Add text overlay with validation
-			if item.Text != "" {
-				escapedText := strings.ReplaceAll(item.Text, "'", "\\'")
+			text := item.Text
+			if text == "" {
+				text = item.Content
+			}
+			if text != "" {
+				escapedText := strings.ReplaceAll(text, "'", "\\'")
 
 				// Set default values for missing properties
 				color := item.Color
+				if color == "" {
+					color = item.FontColor
+				}
 				if color == "" {
 					color = "white"
 				}
@@ -437,23 +453,15 @@ func (vp *VideoProcessor) buildComplexFFmpegCommand(projectData struct {
 			}
 		} else if item.Type == "audio" && !item.IsMuted {
 			// Handle standalone audio files
-			var inputPath string
-			if strings.HasPrefix(item.URL, "http://localhost:8080/") {
-				// Remove the server URL prefix to get the local path
-				inputPath = strings.TrimPrefix(item.URL, "http://localhost:8080/")
-			} else {
-				// Handle relative URLs
-				inputPath = strings.TrimPrefix(item.URL, "/")
-			}
-
-			if _, err := os.Stat(inputPath); os.IsNotExist(err) {
-				log.Printf("Warning: Audio file does not exist: %s (original URL: %s)", inputPath, item.URL)
-				continue
+			inputPath, cleanup, err := vp.stageInput(userID, item.AssetID, item.URL)
+			if err != nil {
+				return fmt.Errorf("clip %s: %v", item.ID, err)
 			}
+			defer cleanup()
 
 			cmdArgs = append(cmdArgs, "-i", inputPath)
-			audioFilter := fmt.Sprintf("[%d:a]adelay=%dms:all=1[audio%d]",
-				inputIndex, int(item.StartTime*1000), inputIndex)
+			audioFilter := fmt.Sprintf("[%d:a]%sadelay=%dms:all=1[audio%d]",
+				inputIndex, audioTrimFilter(item), int(item.StartTime*1000), inputIndex)
 			filterComplex = append(filterComplex, audioFilter)
 			audioInputs = append(audioInputs, fmt.Sprintf("[audio%d]", inputIndex))
 			inputIndex++
@@ -472,6 +480,8 @@ func (vp *VideoProcessor) buildComplexFFmpegCommand(projectData struct {
 	}
 
 	// Check if we have any content to export
+	var filterComplexStr string
+	var videoMap, audioMap []string
 	if !hasValidInputs {
 		// Create a simple test video if no inputs are valid
 		log.Printf("No valid inputs found, creating a simple test video")
@@ -482,49 +492,108 @@ func (vp *VideoProcessor) buildComplexFFmpegCommand(projectData struct {
 			"-i", fmt.Sprintf("sine=frequency=440:duration=%f", projectData.Duration),
 		}
 		// Add text overlay saying "No media found"
-		filterComplexStr := fmt.Sprintf("[0:v]drawtext=text='No media files found':x=(w-text_w)/2:y=(h-text_h)/2:fontsize=48:fontcolor=white[v]")
-		cmdArgs = append(cmdArgs, "-filter_complex", filterComplexStr)
-		cmdArgs = append(cmdArgs, "-map", "[v]", "-map", "1:a")
+		filterComplexStr = fmt.Sprintf("[0:v]drawtext=text='No media files found':x=(w-text_w)/2:y=(h-text_h)/2:fontsize=48:fontcolor=white[v]")
+		videoMap = []string{"-map", "[v]"}
+		audioMap = []string{"-map", "1:a"}
 	} else {
 		// Final output mapping
 		finalVideoLabel := videoOverlays[len(videoOverlays)-1]
 
 		// Join filter complex
-		filterComplexStr := strings.Join(filterComplex, ";")
+		filterComplexStr = strings.Join(filterComplex, ";")
 		log.Printf("Generated filter complex: %s", filterComplexStr)
-		cmdArgs = append(cmdArgs, "-filter_complex", filterComplexStr)
 
 		// Map outputs
-		cmdArgs = append(cmdArgs, "-map", fmt.Sprintf("[%s]", finalVideoLabel))
+		videoMap = []string{"-map", fmt.Sprintf("[%s]", finalVideoLabel)}
 		if len(audioInputs) > 0 {
-			cmdArgs = append(cmdArgs, "-map", "[final_audio]")
+			audioMap = []string{"-map", "[final_audio]"}
 		}
 	}
 
-	// Set codec and quality based on settings
-	switch settings.Quality {
-	case "high":
-		cmdArgs = append(cmdArgs, "-c:v", "libx264", "-crf", "18")
-	case "medium":
-		cmdArgs = append(cmdArgs, "-c:v", "libx264", "-crf", "23")
-	case "low":
-		cmdArgs = append(cmdArgs, "-c:v", "libx264", "-crf", "28")
+	hasAudio := len(audioInputs) > 0 || !hasValidInputs
+
+	// Set codec and rate control based on settings
+	videoArgs, err := settings.videoEncoderArgs(projectData.Duration, hasAudio)
+	if err != nil {
+		return err
 	}
+	log.Printf("Project duration: %f, Media items count: %d", projectData.Duration, len(projectData.MediaItems))
 
-	if len(audioInputs) > 0 {
-		cmdArgs = append(cmdArgs, "-c:a", "aac", "-b:a", "128k")
+	// encodeArgs completes the inputs with a filter graph and video output
+	encodeArgs := func(filter string) []string {
+		args := append([]string{}, cmdArgs...)
+		args = append(args, "-filter_complex", filter)
+		args = append(args, videoMap...)
+		args = append(args, videoArgs...)
+		return append(args, "-t", fmt.Sprintf("%f", projectData.Duration))
+	}
+
+	var passArgs []string
+	if settings.TwoPass {
+		// The first pass only analyses video; its stats feed the second pass.
+		// It maps no audio, so the mixed audio is discarded inside the graph
+		// rather than left as an unconnected output.
+		passLogFile := strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + "_passlog"
+		defer removePassLogs(passLogFile)
+
+		firstPassFilter := filterComplexStr
+		if hasValidInputs && len(audioInputs) > 0 {
+			firstPassFilter += ";[final_audio]anullsink"
+		}
+		firstPass := append(encodeArgs(firstPassFilter), "-pass", "1", "-passlogfile", passLogFile, "-an", "-f", "null", "-y", os.DevNull)
+		if err := runFFmpeg(firstPass); err != nil {
+			return fmt.Errorf("ffmpeg export first pass failed: %v", err)
+		}
+		passArgs = []string{"-pass", "2", "-passlogfile", passLogFile}
 	}
 
-	// Set duration and other parameters
-	cmdArgs = append(cmdArgs, "-t", fmt.Sprintf("%f", projectData.Duration))
-	cmdArgs = append(cmdArgs, "-r", "30") // Frame rate
-	cmdArgs = append(cmdArgs, "-y")       // Overwrite output file
+	cmdArgs = append(encodeArgs(filterComplexStr), passArgs...)
+	if hasAudio {
+		cmdArgs = append(cmdArgs, audioMap...)
+		cmdArgs = append(cmdArgs, settings.audioEncoderArgs()...)
+	}
+	cmdArgs = append(cmdArgs, "-y") // Overwrite output file
 	cmdArgs = append(cmdArgs, outputPath)
 
-	log.Printf("FFmpeg export command: ffmpeg %v", cmdArgs)
-	log.Printf("Project duration: %f, Media items count: %d", projectData.Duration, len(projectData.MediaItems))
+	if err := runFFmpeg(cmdArgs); err != nil {
+		return fmt.Errorf("ffmpeg export failed: %v", err)
+	}
+	return nil
+}
+
+// clipSpan returns the part of the source a clip plays, in seconds. Clips
+// without an offset play from the start of their source.
+func clipSpan(item models.TimelineItem) (start, duration float64) {
+	duration = item.Duration
+	if duration <= 0 {
+		duration = item.EndTime - item.StartTime
+	}
+	return item.SourceStart, duration
+}
+
+// videoTrimFilter cuts a clip's span out of its source and shifts it to the
+// clip's place on the timeline. It is empty for clips without a length.
+func videoTrimFilter(item models.TimelineItem) string {
+	start, duration := clipSpan(item)
+	if duration <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("trim=start=%f:duration=%f,setpts=PTS-STARTPTS+%f/TB,", start, duration, item.StartTime)
+}
+
+// audioTrimFilter is the audio counterpart of videoTrimFilter; adelay then
+// moves the audio into place
+func audioTrimFilter(item models.TimelineItem) string {
+	start, duration := clipSpan(item)
+	if duration <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("atrim=start=%f:duration=%f,asetpts=PTS-STARTPTS,", start, duration)
+}
 
-	// Execute FFmpeg command
+// runFFmpeg executes ffmpeg with the given arguments, including its output in any error
+func runFFmpeg(cmdArgs []string) error {
+	log.Printf("FFmpeg command: ffmpeg %v", cmdArgs)
 	cmd := exec.Command("ffmpeg", cmdArgs...)
 
 	var out bytes.Buffer
@@ -532,14 +601,17 @@ func (vp *VideoProcessor) buildComplexFFmpegCommand(projectData struct {
 	cmd.Stdout = &out
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
-	if err != nil {
-		return "", fmt.Errorf("ffmpeg export failed: %v\nStdout: %s\nStderr: %s", err, out.String(), stderr.String())
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v\nStdout: %s\nStderr: %s", err, out.String(), stderr.String())
 	}
-
 	log.Printf("FFmpeg export output: %s", out.String())
+	return nil
+}
 
-	// Return the URL for the exported file
-	exportURL := fmt.Sprintf("/uploads/%s/exports/%s", strings.Split(outputPath, "/")[1], filepath.Base(outputPath))
-	return exportURL, nil
+// removePassLogs deletes the stats files left behind by a two-pass encode
+func removePassLogs(passLogFile string) {
+	matches, _ := filepath.Glob(passLogFile + "*")
+	for _, m := range matches {
+		os.Remove(m)
+	}
 }
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"video-editor/models"
+	"video-editor/storage"
+)
+
+// WaveformZoomLevels are the resolutions peaks are generated at, in audio
+// samples per min/max pair. Each level is a multiple of the first, so coarser
+// levels are computed from the finest without decoding again.
+var WaveformZoomLevels = []int{256, 1024, 4096}
+
+const (
+	// waveformDefaultSampleRate is used when the asset's rate is unknown
+	waveformDefaultSampleRate = 44100
+
+	// datVersion and datFlag16Bit identify audiowaveform's .dat layout:
+	// version, flags, sample rate, samples per pixel, length, then length
+	// min/max pairs, all little-endian
+	datVersion   = 1
+	datFlag16Bit = 0
+)
+
+// Waveform holds min/max peaks of a mono mixdown, in audiowaveform's layout
+type Waveform struct {
+	SampleRate      int
+	SamplesPerPixel int
+	Peaks           []int16 // Interleaved min, max for each pixel
+}
+
+// Length returns the number of min/max pairs
+func (w *Waveform) Length() int {
+	return len(w.Peaks) / 2
+}
+
+// WaveformKind is the derived file kind of the peaks at a zoom level
+func WaveformKind(samplesPerPixel int) string {
+	return models.DerivedWaveform + "_" + strconv.Itoa(samplesPerPixel)
+}
+
+// ComputePeaks reads signed 16-bit little-endian mono PCM and returns the
+// min/max of every samplesPerPixel samples. A trailing partial pixel is kept.
+func ComputePeaks(r io.Reader, sampleRate, samplesPerPixel int) (*Waveform, error) {
+	if samplesPerPixel <= 0 {
+		return nil, errors.New("samples per pixel must be positive")
+	}
+	w := &Waveform{SampleRate: sampleRate, SamplesPerPixel: samplesPerPixel}
+
+	br := bufio.NewReader(r)
+	buf := make([]byte, 2)
+	var lo, hi int16 = math.MaxInt16, math.MinInt16
+	count := 0
+	for {
+		if _, err := io.ReadFull(br, buf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, err
+		}
+		sample := int16(binary.LittleEndian.Uint16(buf))
+		if sample < lo {
+			lo = sample
+		}
+		if sample > hi {
+			hi = sample
+		}
+		count++
+		if count == samplesPerPixel {
+			w.Peaks = append(w.Peaks, lo, hi)
+			lo, hi, count = math.MaxInt16, math.MinInt16, 0
+		}
+	}
+	if count > 0 {
+		w.Peaks = append(w.Peaks, lo, hi)
+	}
+	return w, nil
+}
+
+// Downsample merges every factor pixels into one, keeping the extremes
+func (w *Waveform) Downsample(factor int) *Waveform {
+	out := &Waveform{SampleRate: w.SampleRate, SamplesPerPixel: w.SamplesPerPixel * factor}
+	for i := 0; i < w.Length(); i += factor {
+		lo, hi := w.Peaks[2*i], w.Peaks[2*i+1]
+		for j := i + 1; j < i+factor && j < w.Length(); j++ {
+			if w.Peaks[2*j] < lo {
+				lo = w.Peaks[2*j]
+			}
+			if w.Peaks[2*j+1] > hi {
+				hi = w.Peaks[2*j+1]
+			}
+		}
+		out.Peaks = append(out.Peaks, lo, hi)
+	}
+	return out
+}
+
+// WriteDat writes the peaks in audiowaveform's binary .dat format (version 1, 16-bit)
+func (w *Waveform) WriteDat(wr io.Writer) error {
+	header := []int32{datVersion, datFlag16Bit, int32(w.SampleRate), int32(w.SamplesPerPixel), int32(w.Length())}
+	if err := binary.Write(wr, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	return binary.Write(wr, binary.LittleEndian, w.Peaks)
+}
+
+// ReadDat parses a version 1 .dat file as written by WriteDat or audiowaveform
+func ReadDat(r io.Reader) (*Waveform, error) {
+	var header [5]int32
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if header[0] != datVersion {
+		return nil, fmt.Errorf("unsupported waveform version %d", header[0])
+	}
+	if header[1] != datFlag16Bit {
+		return nil, errors.New("only 16-bit waveform data is supported")
+	}
+	if header[4] < 0 {
+		return nil, errors.New("invalid waveform length")
+	}
+	w := &Waveform{SampleRate: int(header[2]), SamplesPerPixel: int(header[3])}
+	w.Peaks = make([]int16, 2*int(header[4]))
+	if err := binary.Read(r, binary.LittleEndian, w.Peaks); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// MarshalJSON renders the peaks like audiowaveform's JSON output
+func (w *Waveform) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"version":           2,
+		"channels":          1,
+		"sample_rate":       w.SampleRate,
+		"samples_per_pixel": w.SamplesPerPixel,
+		"bits":              16,
+		"length":            w.Length(),
+		"data":              w.Peaks,
+	})
+}
+
+// generateWaveform decodes an asset's audio to mono PCM and stores its peaks
+// at every zoom level. It returns the URL of the finest level.
+func (vp *VideoProcessor) generateWaveform(asset *models.MediaAsset) (string, error) {
+	if !hasAudioStream(asset.Metadata) {
+		return "", errNoAudioStream
+	}
+
+	inputPath, cleanup, err := storage.Fetch(vp.store, asset.StorageKey, vp.workDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to stage asset: %v", err)
+	}
+	defer cleanup()
+
+	sampleRate := asset.Metadata.SampleRate
+	if sampleRate <= 0 {
+		sampleRate = waveformDefaultSampleRate
+	}
+	finest, err := decodePeaks(inputPath, sampleRate, WaveformZoomLevels[0])
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(vp.workDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create work directory: %v", err)
+	}
+	var finestURL string
+	for _, spp := range WaveformZoomLevels {
+		peaks := finest
+		if spp != finest.SamplesPerPixel {
+			peaks = finest.Downsample(spp / finest.SamplesPerPixel)
+		}
+
+		localPath := filepath.Join(vp.workDir, fmt.Sprintf("waveform_%s_%d.dat", asset.ID.Hex(), spp))
+		if err := writeDatFile(localPath, peaks); err != nil {
+			os.Remove(localPath)
+			return "", err
+		}
+		url, err := vp.storeDerived(asset, WaveformKind(spp), localPath, fmt.Sprintf("waveform_%d.dat", spp))
+		os.Remove(localPath)
+		if err != nil {
+			return "", err
+		}
+		if finestURL == "" {
+			finestURL = url
+		}
+	}
+	return finestURL, nil
+}
+
+// decodePeaks streams the first audio track through ffmpeg as mono 16-bit PCM
+func decodePeaks(inputPath string, sampleRate, samplesPerPixel int) (*Waveform, error) {
+	cmd := exec.Command("ffmpeg",
+		"-v", "error",
+		"-i", inputPath,
+		"-map", "0:a:0",
+		"-ac", "1", "-ar", strconv.Itoa(sampleRate),
+		"-f", "s16le", "-acodec", "pcm_s16le",
+		"pipe:1",
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	peaks, peakErr := ComputePeaks(stdout, sampleRate, samplesPerPixel)
+	if peakErr != nil {
+		// Unblock ffmpeg before waiting on it
+		io.Copy(io.Discard, stdout)
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("ffmpeg audio decode failed: %v\nStderr: %s", err, stderr.String())
+	}
+	return peaks, peakErr
+}
+
+func writeDatFile(path string, w *Waveform) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if err := w.WriteDat(bw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"slices"
+	"testing"
+)
+
+// sinePCM returns n samples of a sine as signed 16-bit little-endian PCM,
+// with the samples themselves for checking
+func sinePCM(n int, period, amplitude float64) ([]byte, []int16) {
+	samples := make([]int16, n)
+	var buf bytes.Buffer
+	for i := range samples {
+		samples[i] = int16(math.Round(amplitude * math.Sin(2*math.Pi*float64(i)/period)))
+		binary.Write(&buf, binary.LittleEndian, samples[i])
+	}
+	return buf.Bytes(), samples
+}
+
+func TestComputePeaksSine(t *testing.T) {
+	const (
+		sampleRate = 8000
+		amplitude  = 10000
+		period     = 64 // 125Hz, a whole number of periods in every pixel
+		n          = 10000
+	)
+	pcm, samples := sinePCM(n, period, amplitude)
+
+	var finest *Waveform
+	for _, spp := range WaveformZoomLevels {
+		w, err := ComputePeaks(bytes.NewReader(pcm), sampleRate, spp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.SampleRate != sampleRate || w.SamplesPerPixel != spp {
+			t.Errorf("spp %d: got rate %d, spp %d", spp, w.SampleRate, w.SamplesPerPixel)
+		}
+		// A trailing partial pixel is kept
+		if want := (n + spp - 1) / spp; w.Length() != want {
+			t.Fatalf("spp %d: %d pixels, want %d", spp, w.Length(), want)
+		}
+		for i := 0; i < w.Length(); i++ {
+			lo, hi := w.Peaks[2*i], w.Peaks[2*i+1]
+			start, end := i*spp, min((i+1)*spp, n)
+			if end-start >= period {
+				if lo != -amplitude || hi != amplitude {
+					t.Errorf("spp %d pixel %d: min/max %d/%d, want ±%d", spp, i, lo, hi, amplitude)
+				}
+				continue
+			}
+			wantLo, wantHi := samples[start], samples[start]
+			for _, s := range samples[start:end] {
+				wantLo, wantHi = min(wantLo, s), max(wantHi, s)
+			}
+			if lo != wantLo || hi != wantHi {
+				t.Errorf("spp %d partial pixel %d: min/max %d/%d, want %d/%d", spp, i, lo, hi, wantLo, wantHi)
+			}
+		}
+
+		// Coarser levels are downsampled from the finest, which must agree
+		// with computing them directly
+		if finest == nil {
+			finest = w
+			continue
+		}
+		down := finest.Downsample(spp / finest.SamplesPerPixel)
+		if down.SamplesPerPixel != spp || !slices.Equal(down.Peaks, w.Peaks) {
+			t.Errorf("spp %d: downsampled peaks differ from computed ones", spp)
+		}
+	}
+}
+
+func TestComputePeaksOddByte(t *testing.T) {
+	// A dangling byte at the end is not a sample
+	w, err := ComputePeaks(bytes.NewReader([]byte{0x00, 0x80, 0xff, 0x7f, 0x05}), 8000, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(w.Peaks, []int16{math.MinInt16, math.MaxInt16}) {
+		t.Errorf("got %v", w.Peaks)
+	}
+	if _, err := ComputePeaks(bytes.NewReader(nil), 8000, 0); err == nil {
+		t.Error("expected an error for zero samples per pixel")
+	}
+}
+
+func TestWriteDat(t *testing.T) {
+	w := &Waveform{SampleRate: 44100, SamplesPerPixel: 256, Peaks: []int16{-2, 3, math.MinInt16, math.MaxInt16}}
+	var buf bytes.Buffer
+	if err := w.WriteDat(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "01000000" + // version 1
+		"00000000" + // flags: 16-bit
+		"44ac0000" + // sample rate 44100
+		"00010000" + // samples per pixel 256
+		"02000000" + // length 2
+		"feff" + "0300" + // min -2, max 3
+		"0080" + "ff7f" // min -32768, max 32767
+	if got := hex.EncodeToString(buf.Bytes()); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	read, err := ReadDat(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.SampleRate != w.SampleRate || read.SamplesPerPixel != w.SamplesPerPixel || !slices.Equal(read.Peaks, w.Peaks) {
+		t.Errorf("read back %+v", read)
+	}
+}
+
+func TestReadDatRejects(t *testing.T) {
+	header := func(fields ...int32) []byte {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, fields)
+		return buf.Bytes()
+	}
+	tests := map[string][]byte{
+		"version 2":  header(2, 0, 44100, 256, 0),
+		"8-bit":      header(1, 1, 44100, 256, 0),
+		"negative":   header(1, 0, 44100, 256, -1),
+		"short data": append(header(1, 0, 44100, 256, 2), 0, 0, 0, 0),
+		"no header":  {1, 0, 0},
+	}
+	for name, data := range tests {
+		if _, err := ReadDat(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"video-editor/db"
+	"video-editor/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrWorkspaceNotFound is returned when a workspace doesn't exist or the user
+// isn't allowed to see or change it
+var ErrWorkspaceNotFound = errors.New("workspace not found or unauthorized")
+
+// WorkspaceService manages the groups of users that share templates
+type WorkspaceService struct {
+	workspacesCollection *mongo.Collection
+}
+
+// NewWorkspaceService creates a new WorkspaceService
+func NewWorkspaceService(client *mongo.Client, dbName string) *WorkspaceService {
+	return &WorkspaceService{
+		workspacesCollection: client.Database(dbName).Collection("workspaces"),
+	}
+}
+
+// CreateWorkspace creates a workspace with the user as its owner and only member
+func (s *WorkspaceService) CreateWorkspace(name, ownerID string) (*models.Workspace, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("workspace name is required")
+	}
+	workspace := &models.Workspace{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		OwnerID:   ownerID,
+		Members:   []string{ownerID},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if _, err := s.workspacesCollection.InsertOne(db.Ctx, workspace); err != nil {
+		return nil, err
+	}
+	return workspace, nil
+}
+
+// ListWorkspaces returns the workspaces the user is a member of
+func (s *WorkspaceService) ListWorkspaces(userID string) ([]models.Workspace, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := s.workspacesCollection.Find(db.Ctx, bson.M{"members": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	workspaces := []models.Workspace{}
+	if err := cursor.All(db.Ctx, &workspaces); err != nil {
+		return nil, err
+	}
+	return workspaces, nil
+}
+
+// WorkspaceIDs returns the IDs of the workspaces the user is a member of
+func (s *WorkspaceService) WorkspaceIDs(userID string) ([]string, error) {
+	workspaces, err := s.ListWorkspaces(userID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(workspaces))
+	for i, w := range workspaces {
+		ids[i] = w.ID.Hex()
+	}
+	return ids, nil
+}
+
+// IsMember reports whether the user belongs to a workspace
+func (s *WorkspaceService) IsMember(workspaceID, userID string) (bool, error) {
+	objID, err := primitive.ObjectIDFromHex(workspaceID)
+	if err != nil {
+		return false, nil
+	}
+	count, err := s.workspacesCollection.CountDocuments(db.Ctx, bson.M{"_id": objID, "members": userID})
+	return count > 0, err
+}
+
+// AddMember adds a user to a workspace the owner manages
+func (s *WorkspaceService) AddMember(workspaceID, ownerID, memberID string) (*models.Workspace, error) {
+	if memberID == "" {
+		return nil, errors.New("user_id is required")
+	}
+	return s.updateMembers(workspaceID, ownerID, bson.M{"$addToSet": bson.M{"members": memberID}})
+}
+
+// RemoveMember takes a user out of a workspace. The owner can't be removed.
+func (s *WorkspaceService) RemoveMember(workspaceID, ownerID, memberID string) (*models.Workspace, error) {
+	if memberID == ownerID {
+		return nil, errors.New("the owner can't leave the workspace")
+	}
+	return s.updateMembers(workspaceID, ownerID, bson.M{"$pull": bson.M{"members": memberID}})
+}
+
+func (s *WorkspaceService) updateMembers(workspaceID, ownerID string, update bson.M) (*models.Workspace, error) {
+	objID, err := primitive.ObjectIDFromHex(workspaceID)
+	if err != nil {
+		return nil, errors.New("invalid workspace ID format")
+	}
+
+	update["$set"] = bson.M{"updated_at": time.Now()}
+	workspace := &models.Workspace{}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.workspacesCollection.FindOneAndUpdate(db.Ctx, bson.M{"_id": objID, "owner_id": ownerID}, update, opts).Decode(workspace)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrWorkspaceNotFound
+		}
+		return nil, err
+	}
+	return workspace, nil
+}
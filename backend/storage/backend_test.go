@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// conformance is a backend under test, with a way to fetch its signed URLs
+// the way a browser would
+type conformance struct {
+	backend Backend
+	prefix  string // Keys the test may use, so a shared bucket is left alone
+	fetch   func(rawURL string) ([]byte, error)
+}
+
+func TestLocalConformance(t *testing.T) {
+	signer := NewURLSigner("secret", "/media")
+	local, err := NewLocal(t.TempDir(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testConformance(t, conformance{
+		backend: local,
+		prefix:  "user/",
+		// The media route checks the signature and serves the file
+		fetch: func(rawURL string) ([]byte, error) {
+			key, ok := local.KeyFromURL(rawURL)
+			if !ok {
+				return nil, fmt.Errorf("%s is not a local URL", rawURL)
+			}
+			u, _ := url.Parse(rawURL)
+			if err := signer.Verify(key, u.Query().Get("expires"), u.Query().Get("sig")); err != nil {
+				return nil, err
+			}
+			r, err := local.Get(key)
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return io.ReadAll(r)
+		},
+	})
+}
+
+// TestS3Conformance runs against an S3-compatible server such as MinIO when
+// S3_TEST_ENDPOINT is set, e.g. with
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	S3_TEST_ENDPOINT=http://localhost:9000 go test ./storage
+//
+// The bucket (S3_TEST_BUCKET, default "conformance") is created if needed.
+// Credentials default to MinIO's.
+func TestS3Conformance(t *testing.T) {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT not set")
+	}
+	s3, err := NewS3(S3Config{
+		Endpoint:  endpoint,
+		Region:    envOr("S3_TEST_REGION", "us-east-1"),
+		Bucket:    envOr("S3_TEST_BUCKET", "conformance"),
+		AccessKey: envOr("S3_TEST_ACCESS_KEY", "minioadmin"),
+		SecretKey: envOr("S3_TEST_SECRET_KEY", "minioadmin"),
+		PathStyle: os.Getenv("S3_TEST_PATH_STYLE") != "false",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := s3.newBucketRequest(http.MethodPut, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A 409 means the bucket is already there
+	if _, err := s3.do(req); err != nil && !strings.Contains(err.Error(), "409") {
+		t.Fatalf("create bucket: %v", err)
+	}
+
+	prefix := fmt.Sprintf("conformance-%d/", time.Now().UnixNano())
+	t.Cleanup(func() {
+		objects, _ := s3.List(prefix)
+		for _, o := range objects {
+			s3.Delete(o.Key)
+		}
+	})
+	testConformance(t, conformance{
+		backend: s3,
+		prefix:  prefix,
+		fetch: func(rawURL string) ([]byte, error) {
+			resp, err := http.Get(rawURL)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+			}
+			return io.ReadAll(resp.Body)
+		},
+	})
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// testConformance checks the behaviour every Backend must share
+func testConformance(t *testing.T, c conformance) {
+	b := c.backend
+	key := func(k string) string { return c.prefix + k }
+	put := func(k, content string, size int64, contentType string) {
+		t.Helper()
+		if err := b.Put(key(k), strings.NewReader(content), size, contentType); err != nil {
+			t.Fatalf("put %s: %v", k, err)
+		}
+	}
+	get := func(k string) string {
+		t.Helper()
+		r, err := b.Get(key(k))
+		if err != nil {
+			t.Fatalf("get %s: %v", k, err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("read %s: %v", k, err)
+		}
+		return string(data)
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		if _, err := b.Get(key("missing.txt")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("get: got %v, want ErrNotFound", err)
+		}
+		if _, err := b.Stat(key("missing.txt")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("stat: got %v, want ErrNotFound", err)
+		}
+		if err := b.Delete(key("missing.txt")); err != nil {
+			t.Errorf("delete: %v", err)
+		}
+	})
+
+	t.Run("put get stat", func(t *testing.T) {
+		put("notes/hello.txt", "hello", 5, "text/plain; charset=utf-8")
+		if got := get("notes/hello.txt"); got != "hello" {
+			t.Errorf("got %q", got)
+		}
+		info, err := b.Stat(key("notes/hello.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Key != key("notes/hello.txt") || info.Size != 5 || info.ContentType != "text/plain; charset=utf-8" {
+			t.Errorf("stat: %+v", info)
+		}
+		if info.ModTime.IsZero() || time.Since(info.ModTime) > time.Hour {
+			t.Errorf("stat: modified %v", info.ModTime)
+		}
+
+		// Keys are cleaned, so a roundabout path names the same object
+		if got := get("notes/./drafts/../hello.txt"); got != "hello" {
+			t.Errorf("uncleaned key: got %q", got)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		put("over.bin", "first version", 13, "")
+		put("over.bin", "second", 6, "")
+		if got := get("over.bin"); got != "second" {
+			t.Errorf("got %q", got)
+		}
+		if info, err := b.Stat(key("over.bin")); err != nil || info.Size != 6 {
+			t.Errorf("stat: %+v, %v", info, err)
+		}
+	})
+
+	t.Run("unknown size and empty", func(t *testing.T) {
+		content := strings.Repeat("0123456789", 10000)
+		put("stream.bin", content, -1, "")
+		if got := get("stream.bin"); got != content {
+			t.Errorf("got %d bytes, want %d", len(got), len(content))
+		}
+		put("empty.bin", "", 0, "")
+		if got := get("empty.bin"); got != "" {
+			t.Errorf("got %q", got)
+		}
+		if info, err := b.Stat(key("empty.bin")); err != nil || info.Size != 0 {
+			t.Errorf("stat: %+v, %v", info, err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		put("gone.txt", "bye", 3, "")
+		if err := b.Delete(key("gone.txt")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Stat(key("gone.txt")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("stat after delete: got %v, want ErrNotFound", err)
+		}
+		if err := b.Delete(key("gone.txt")); err != nil {
+			t.Errorf("second delete: %v", err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		put("list/a.txt", "a", 1, "")
+		put("list/ab.txt", "ab", 2, "")
+		put("list/b/c.txt", "abc", 3, "")
+		put("other/a.txt", "x", 1, "")
+		sizes := map[string]int64{"list/a.txt": 1, "list/ab.txt": 2, "list/b/c.txt": 3, "other/a.txt": 1}
+		keys := func(prefix string) []string {
+			t.Helper()
+			objects, err := b.List(key(prefix))
+			if err != nil {
+				t.Fatalf("list %s: %v", prefix, err)
+			}
+			var found []string
+			for _, o := range objects {
+				k := strings.TrimPrefix(o.Key, c.prefix)
+				if o.Size != sizes[k] {
+					t.Errorf("list %s: %s has size %d, want %d", prefix, k, o.Size, sizes[k])
+				}
+				found = append(found, k)
+			}
+			sort.Strings(found)
+			return found
+		}
+		tests := map[string][]string{
+			"list/":   {"list/a.txt", "list/ab.txt", "list/b/c.txt"},
+			"list/a":  {"list/a.txt", "list/ab.txt"},
+			"list/b/": {"list/b/c.txt"},
+			"nope/":   nil,
+		}
+		for prefix, want := range tests {
+			if got := keys(prefix); !slices.Equal(got, want) {
+				t.Errorf("list %s: got %v, want %v", prefix, got, want)
+			}
+		}
+		if total, err := TotalSize(b, key("list/")); err != nil || total != 6 {
+			t.Errorf("total size: %d, %v", total, err)
+		}
+	})
+
+	t.Run("signed URL", func(t *testing.T) {
+		// Spaces and non-ASCII names must survive URL encoding and signing
+		k := key("clips/my clip ü.mp4")
+		put("clips/my clip ü.mp4", "frames", 6, "video/mp4")
+		signed, err := b.SignedURL(k, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if back, ok := b.KeyFromURL(signed); !ok || back != k {
+			t.Errorf("key from %s: got %q, %v", signed, back, ok)
+		}
+		data, err := c.fetch(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, []byte("frames")) {
+			t.Errorf("fetched %q", data)
+		}
+
+		expired, err := b.SignedURL(k, -time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.fetch(expired); err == nil {
+			t.Error("fetched an expired URL")
+		}
+		if _, ok := b.KeyFromURL("https://elsewhere.example/" + k); ok {
+			t.Error("accepted a URL from another host")
+		}
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		for _, k := range []string{"", "/abs.txt", "../escape.txt", "a/../../escape.txt"} {
+			if err := b.Put(k, strings.NewReader("x"), 1, ""); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("put %q: got %v, want ErrInvalidKey", k, err)
+			}
+			if _, err := b.Get(k); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("get %q: got %v, want ErrInvalidKey", k, err)
+			}
+			if _, err := b.SignedURL(k, time.Minute); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("signed URL %q: got %v, want ErrInvalidKey", k, err)
+			}
+		}
+	})
+
+	t.Run("fetch", func(t *testing.T) {
+		put("fetch/clip.mp4", "media", 5, "")
+		p, cleanup, err := Fetch(b, key("fetch/clip.mp4"), t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cleanup()
+		if data, err := os.ReadFile(p); err != nil || string(data) != "media" {
+			t.Errorf("fetched %q, %v", data, err)
+		}
+		if _, cleanup, err := Fetch(b, key("fetch/missing.mp4"), t.TempDir()); !errors.Is(err, ErrNotFound) {
+			t.Errorf("fetch missing: got %v, want ErrNotFound", err)
+			cleanup()
+		}
+	})
+}
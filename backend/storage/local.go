@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// legacyBaseURL is where local files were served statically before URLs were
+// signed. Projects saved back then still reference it.
+const legacyBaseURL = "/uploads"
+
+// Local stores objects as files under a root directory
+type Local struct {
+	root   string
+	signer *URLSigner
+}
+
+// NewLocal creates a Local backend rooted at dir, creating it if needed. Its
+// signed URLs point at the application route served by signer's base URL.
+func NewLocal(root string, signer *URLSigner) (*Local, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &Local{root: root, signer: signer}, nil
+}
+
+// LocalPath returns the file backing key
+func (l *Local) LocalPath(key string) (string, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes r to the file for key
+func (l *Local) Put(key string, r io.Reader, size int64, contentType string) error {
+	p, err := l.LocalPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temp file first so readers never see a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".put-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// PutFile moves a local file into the store, copying if it is on another filesystem
+func (l *Local) PutFile(key, localPath string) error {
+	p, err := l.LocalPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(localPath, p); err == nil {
+		return nil
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	err = l.Put(key, f, -1, "")
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(localPath)
+}
+
+// Get opens the file for key
+func (l *Local) Get(key string) (io.ReadCloser, error) {
+	p, err := l.LocalPath(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Stat returns the file's size and modification time
+func (l *Local) Stat(key string) (*ObjectInfo, error) {
+	p, err := l.LocalPath(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	cleaned, _ := CleanKey(key)
+	return &ObjectInfo{
+		Key:         cleaned,
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		ContentType: mime.TypeByExtension(path.Ext(cleaned)),
+	}, nil
+}
+
+// Delete removes the file for key
+func (l *Local) Delete(key string) error {
+	p, err := l.LocalPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List walks the files under prefix
+func (l *Local) List(prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	// Only walk the directory the prefix points into
+	start := l.root
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		dir, err := CleanKey(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		start = filepath.Join(l.root, filepath.FromSlash(dir))
+	}
+	err := filepath.Walk(start, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".put-") {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		}
+		return nil
+	})
+	return objects, err
+}
+
+// SignedURL returns an HMAC-signed URL for the application's media route
+func (l *Local) SignedURL(key string, expiry time.Duration) (string, error) {
+	return l.signer.Sign(key, expiry)
+}
+
+// KeyFromURL accepts signed and legacy static URLs, with or without a host
+func (l *Local) KeyFromURL(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	rest, ok := strings.CutPrefix(u.Path, l.signer.BaseURL()+"/")
+	if !ok {
+		rest, ok = strings.CutPrefix(u.Path, legacyBaseURL+"/")
+	}
+	if !ok {
+		return "", false
+	}
+	key, err := CleanKey(rest)
+	if err != nil {
+		return "", false
+	}
+	return key, true
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// ErrInvalidKey is returned for keys that are empty or escape the store
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// Backend stores files by key. Keys are slash-separated paths such as
+// "<user_id>/exports/export_<job_id>.mp4".
+type Backend interface {
+	// Put stores the content of r under key, replacing any existing object.
+	// size may be -1 if unknown.
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Get opens an object for reading. The caller must close it.
+	Get(key string) (io.ReadCloser, error)
+	// Stat returns an object's metadata, or ErrNotFound.
+	Stat(key string) (*ObjectInfo, error)
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(key string) error
+	// List returns all objects whose key starts with prefix.
+	List(prefix string) ([]ObjectInfo, error)
+	// SignedURL returns a URL from which the object can be fetched until expiry.
+	SignedURL(key string, expiry time.Duration) (string, error)
+	// KeyFromURL maps a URL previously returned by SignedURL back to its key.
+	// It reports false for URLs that do not point into this store.
+	KeyFromURL(rawURL string) (string, bool)
+}
+
+// FilePutter is implemented by backends that can take ownership of a local
+// file more cheaply than copying it, e.g. by renaming it.
+type FilePutter interface {
+	PutFile(key, localPath string) error
+}
+
+// LocalPather is implemented by backends whose objects are plain local files
+// that tools such as ffmpeg can read in place.
+type LocalPather interface {
+	LocalPath(key string) (string, error)
+}
+
+// CleanKey normalizes a key and rejects ones that are absolute or escape the store
+func CleanKey(key string) (string, error) {
+	key = strings.ReplaceAll(key, "\\", "/")
+	if key == "" || strings.HasPrefix(key, "/") {
+		return "", ErrInvalidKey
+	}
+	cleaned := path.Clean(key)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}
+
+// PutFile stores a local file under key and removes the local copy
+func PutFile(b Backend, key, localPath string) error {
+	if fp, ok := b.(FilePutter); ok {
+		return fp.PutFile(key, localPath)
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = b.Put(key, f, info.Size(), "")
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(localPath)
+}
+
+// Fetch makes an object available as a local file for tools that need a
+// path. Local backends return the file itself; others download it into
+// tempDir. The returned cleanup function must always be called.
+func Fetch(b Backend, key, tempDir string) (string, func(), error) {
+	noop := func() {}
+	if lp, ok := b.(LocalPather); ok {
+		p, err := lp.LocalPath(key)
+		if err != nil {
+			return "", noop, err
+		}
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			return "", noop, ErrNotFound
+		}
+		return p, noop, nil
+	}
+
+	r, err := b.Get(key)
+	if err != nil {
+		return "", noop, err
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return "", noop, err
+	}
+	f, err := os.CreateTemp(tempDir, "fetch-*"+path.Ext(key))
+	if err != nil {
+		return "", noop, err
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		cleanup()
+		return "", noop, err
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", noop, err
+	}
+	return f.Name(), cleanup, nil
+}
+
+// TotalSize sums the sizes of all objects under prefix
+func TotalSize(b Backend, prefix string) (int64, error) {
+	objects, err := b.List(prefix)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, o := range objects {
+		total += o.Size
+	}
+	return total, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"video-editor/models"
+	"video-editor/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tus protocol constants (https://tus.io/protocols/resumable-upload)
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration,checksum"
+
+	// statusChecksumMismatch is the tus-specific status for a failed chunk checksum
+	statusChecksumMismatch = 460
+)
+
+// registerTusRoutes mounts the resumable upload endpoints under /files.
+// Completed uploads become media assets, same as POST /upload.
+func registerTusRoutes(authorized *gin.RouterGroup, uploadService *services.UploadService) {
+	files := authorized.Group("/files")
+	files.Use(tusResumableMiddleware())
+
+	// Creation: POST /files with Upload-Length and optional Upload-Metadata
+	files.POST("", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+		if err != nil || length <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length header required"})
+			return
+		}
+		metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Metadata header"})
+			return
+		}
+
+		session, err := uploadService.CreateSession(userID, length, metadata)
+		if err != nil {
+			c.JSON(tusErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Location", "/files/"+session.ID.Hex())
+		setUploadHeaders(c, session)
+		c.Status(http.StatusCreated)
+	})
+
+	// Offset lookup used to resume an interrupted upload
+	files.HEAD("/:id", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		session, err := uploadService.GetSession(c.Param("id"), userID)
+		if err != nil {
+			c.Status(tusErrorStatus(err))
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		setUploadHeaders(c, session)
+		c.Status(http.StatusOK)
+	})
+
+	files.PATCH("/:id", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		if c.ContentType() != "application/offset+octet-stream" {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+			return
+		}
+		offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset header required"})
+			return
+		}
+
+		session, asset, err := uploadService.WriteChunk(c.Param("id"), userID, offset, c.Request.Body, c.GetHeader("Upload-Checksum"))
+		if session != nil {
+			setUploadHeaders(c, session)
+		}
+		if err != nil {
+			c.JSON(tusErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if asset != nil {
+			c.Header("X-Asset-Id", asset.ID.Hex())
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	files.DELETE("/:id", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		if err := uploadService.TerminateSession(c.Param("id"), userID); err != nil {
+			c.JSON(tusErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// tusResumableMiddleware rejects requests for an unsupported protocol version
+func tusResumableMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+		if c.GetHeader("Tus-Resumable") != tusVersion {
+			c.Header("Tus-Version", tusVersion)
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "Unsupported Tus-Resumable version"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// setTusDiscoveryHeaders advertises server capabilities in response to OPTIONS
+func setTusDiscoveryHeaders(c *gin.Context, maxSize int64) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+	c.Header("Tus-Checksum-Algorithm", strings.Join(services.SupportedChecksumAlgorithms, ","))
+}
+
+// setUploadHeaders reports the current state of an upload
+func setUploadHeaders(c *gin.Context, session *models.UploadSession) {
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(session.Length, 10))
+	if session.AssetID != "" {
+		c.Header("X-Asset-Id", session.AssetID)
+	} else {
+		c.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// parseUploadMetadata decodes "key base64value,key2 base64value2"
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// tusErrorStatus maps upload errors to tus response codes
+func tusErrorStatus(err error) int {
+	var uploadErr *services.UploadError
+	switch {
+	case errors.As(err, &uploadErr):
+		return uploadErr.Status
+	case errors.Is(err, services.ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrUploadOffset), errors.Is(err, services.ErrUploadComplete):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrUploadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, services.ErrChecksumMismatch):
+		return statusChecksumMismatch
+	case errors.Is(err, services.ErrChecksumAlgorithm), errors.Is(err, services.ErrUploadInvalidParam):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
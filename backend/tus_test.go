@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"video-editor/db"
+	"video-editor/services"
+	"video-editor/storage"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// testDatabase connects to the MongoDB at MONGODB_TEST_URI and returns a
+// fresh database name, dropped when the test ends
+func testDatabase(t *testing.T) (*mongo.Client, string) {
+	t.Helper()
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+	client, err := db.ConnectDB(uri)
+	if err != nil {
+		t.Fatalf("connect to MongoDB: %v", err)
+	}
+	name := fmt.Sprintf("video_editor_test_%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		client.Database(name).Drop(db.Ctx)
+		client.Disconnect(db.Ctx)
+	})
+	return client, name
+}
+
+// testToken returns a bearer token for a user
+func testToken(t *testing.T, userID string) string {
+	t.Helper()
+	jwtSecret = []byte("test-secret")
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": userID,
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	signed, err := token.SignedString(jwtSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + signed
+}
+
+// testWAV is a second of a 440Hz tone as 16-bit mono PCM
+func testWAV() []byte {
+	const rate = 8000
+	var pcm bytes.Buffer
+	for i := 0; i < rate; i++ {
+		sample := int16(math.Sin(2*math.Pi*440*float64(i)/rate) * 12000)
+		binary.Write(&pcm, binary.LittleEndian, sample)
+	}
+	var wav bytes.Buffer
+	wav.WriteString("RIFF")
+	binary.Write(&wav, binary.LittleEndian, uint32(36+pcm.Len()))
+	wav.WriteString("WAVEfmt ")
+	for _, field := range []interface{}{uint32(16), uint16(1), uint16(1), uint32(rate), uint32(rate * 2), uint16(2), uint16(16)} {
+		binary.Write(&wav, binary.LittleEndian, field)
+	}
+	wav.WriteString("data")
+	binary.Write(&wav, binary.LittleEndian, uint32(pcm.Len()))
+	wav.Write(pcm.Bytes())
+	return wav.Bytes()
+}
+
+type tusClient struct {
+	t      *testing.T
+	server *httptest.Server
+	auth   string
+}
+
+func (c *tusClient) do(method, path string, body []byte, headers map[string]string) *http.Response {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.server.URL+path, bytes.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	if c.auth != "" {
+		req.Header.Set("Authorization", c.auth)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+// patch sends a chunk at offset with an optional Upload-Checksum
+func (c *tusClient) patch(location string, offset int64, chunk []byte, checksum string) *http.Response {
+	c.t.Helper()
+	headers := map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.FormatInt(offset, 10),
+	}
+	if checksum != "" {
+		headers["Upload-Checksum"] = checksum
+	}
+	return c.do(http.MethodPatch, location, chunk, headers)
+}
+
+// offset asks the server where to resume an upload
+func (c *tusClient) offset(location string) int64 {
+	c.t.Helper()
+	resp := c.do(http.MethodHead, location, nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		c.t.Fatalf("HEAD %s: status %d", location, resp.StatusCode)
+	}
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		c.t.Fatalf("HEAD %s: bad Upload-Offset %q", location, resp.Header.Get("Upload-Offset"))
+	}
+	return offset
+}
+
+func checksum(algorithm string, h interface{ Sum([]byte) []byte }) string {
+	return algorithm + " " + base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func TestTusUpload(t *testing.T) {
+	client, dbName := testDatabase(t)
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		t.Skip("ffprobe is not installed")
+	}
+
+	store, err := storage.NewLocal(t.TempDir(), storage.NewURLSigner("test-secret", "/media"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	quotas := services.NewQuotaService(client, dbName, store, 0)
+	assets := services.NewAssetService(client, dbName, store, &services.UploadPolicy{
+		MaxFileSize:       1 << 20,
+		AllowedMIMETypes:  []string{"audio/wave"},
+		AllowedContainers: []string{"wav"},
+		AllowedCodecs:     []string{"pcm_s16le"},
+	}, quotas)
+	uploads := services.NewUploadService(client, dbName, assets, t.TempDir(), time.Hour, 1<<20)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	authorized := router.Group("/")
+	authorized.Use(authMiddleware())
+	registerTusRoutes(authorized, uploads)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	userID := "tus-user"
+	c := &tusClient{t: t, server: server, auth: testToken(t, userID)}
+	payload := testWAV()
+	length := strconv.Itoa(len(payload))
+
+	// Creation
+	anonymous := &tusClient{t: t, server: server}
+	if resp := anonymous.do(http.MethodPost, "/files", nil, map[string]string{"Upload-Length": length}); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("create without a token: status %d, want 401", resp.StatusCode)
+	}
+	if resp := c.do(http.MethodPost, "/files", nil, map[string]string{"Upload-Length": "0"}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("create without a length: status %d, want 400", resp.StatusCode)
+	}
+	if resp := c.do(http.MethodPost, "/files", nil, map[string]string{"Upload-Length": strconv.Itoa(2 << 20)}); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("create over the maximum size: status %d, want 413", resp.StatusCode)
+	}
+	resp := c.do(http.MethodPost, "/files", nil, map[string]string{
+		"Upload-Length":   length,
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("tone.wav")),
+	})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create: status %d, want 201", resp.StatusCode)
+	}
+	location := resp.Header.Get("Location")
+	if location == "" || resp.Header.Get("Upload-Offset") != "0" {
+		t.Fatalf("create: Location %q, Upload-Offset %q", location, resp.Header.Get("Upload-Offset"))
+	}
+	if other := (&tusClient{t: t, server: server, auth: testToken(t, "someone-else")}); other.do(http.MethodHead, location, nil, nil).StatusCode != http.StatusNotFound {
+		t.Error("another user can see the upload")
+	}
+
+	// First chunk, with a checksum
+	first := payload[:6000]
+	sum := sha256.Sum256(first)
+	resp = c.patch(location, 0, first, "sha256 "+base64.StdEncoding.EncodeToString(sum[:]))
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != "6000" {
+		t.Fatalf("first chunk: status %d, Upload-Offset %q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+
+	// A chunk that doesn't match its checksum is discarded
+	wrong := sha1.New()
+	wrong.Write([]byte("something else"))
+	if resp := c.patch(location, 6000, payload[6000:10000], checksum("sha1", wrong)); resp.StatusCode != statusChecksumMismatch {
+		t.Errorf("corrupt chunk: status %d, want %d", resp.StatusCode, statusChecksumMismatch)
+	}
+	if offset := c.offset(location); offset != 6000 {
+		t.Errorf("offset after a corrupt chunk is %d, want 6000", offset)
+	}
+
+	// A chunk without a checksum is kept as it arrives
+	if resp := c.patch(location, 6000, payload[6000:9000], ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("partial chunk: status %d", resp.StatusCode)
+	}
+	// Resuming at a stale offset is refused
+	if resp := c.patch(location, 6000, payload[6000:], ""); resp.StatusCode != http.StatusConflict {
+		t.Errorf("stale offset: status %d, want 409", resp.StatusCode)
+	}
+
+	// Resume from where the server says the upload is
+	offset := c.offset(location)
+	if offset != 9000 {
+		t.Fatalf("resume offset is %d, want 9000", offset)
+	}
+	rest := md5.New()
+	rest.Write(payload[offset:])
+	resp = c.patch(location, offset, payload[offset:], checksum("md5", rest))
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("last chunk: status %d", resp.StatusCode)
+	}
+	assetID := resp.Header.Get("X-Asset-Id")
+	if assetID == "" || resp.Header.Get("Upload-Offset") != length {
+		t.Fatalf("last chunk: X-Asset-Id %q, Upload-Offset %q", assetID, resp.Header.Get("Upload-Offset"))
+	}
+
+	// Completion
+	if offset := c.offset(location); strconv.FormatInt(offset, 10) != length {
+		t.Errorf("offset after completion is %d, want %s", offset, length)
+	}
+	if resp := c.patch(location, int64(len(payload)), []byte{0}, ""); resp.StatusCode != http.StatusConflict {
+		t.Errorf("chunk after completion: status %d, want 409", resp.StatusCode)
+	}
+	asset, err := assets.GetAsset(assetID, userID)
+	if err != nil {
+		t.Fatalf("uploaded asset: %v", err)
+	}
+	digest := sha256.Sum256(payload)
+	if asset.Filename != "tone.wav" || asset.Size != int64(len(payload)) || asset.SHA256 != hex.EncodeToString(digest[:]) {
+		t.Errorf("asset is %s, %d bytes, sha256 %s", asset.Filename, asset.Size, asset.SHA256)
+	}
+
+	// Termination
+	if resp := c.do(http.MethodDelete, location, nil, nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("terminate: status %d, want 204", resp.StatusCode)
+	}
+	if resp := c.do(http.MethodHead, location, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("HEAD after termination: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestTusResumableVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(tusResumableMiddleware())
+	router.POST("/files", func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+	req := httptest.NewRequest(http.MethodPost, "/files", nil)
+	req.Header.Set("Tus-Resumable", "0.2.2")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusPreconditionFailed || w.Header().Get("Tus-Version") != tusVersion {
+		t.Errorf("status %d, Tus-Version %q", w.Code, w.Header().Get("Tus-Version"))
+	}
+}
+
+func TestParseUploadMetadata(t *testing.T) {
+	metadata, err := parseUploadMetadata("filename dG9uZS53YXY=, is_confidential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata["filename"] != "tone.wav" || metadata["is_confidential"] != "" || len(metadata) != 2 {
+		t.Errorf("got %v", metadata)
+	}
+	if _, err := parseUploadMetadata("filename not-base64!"); err == nil {
+		t.Error("expected an error for a bad value")
+	}
+}
@@ -1,11 +1,15 @@
 package websocket
 
 import (
+	"bytes"
+	"encoding/json"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 const (
@@ -18,13 +22,8 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512
-)
-
-var (
-	newline = []byte{'\n'}
-	space   = []byte{' '}
+	// Maximum message size allowed from peer. Room operations can carry a clip.
+	maxMessageSize = 64 * 1024
 )
 
 var upgrader = websocket.Upgrader{
@@ -48,6 +47,87 @@ type Client struct {
 
 	// User ID associated with this client
 	userID string
+
+	// Project room the client is editing in, if any. Only used by readPump.
+	room *Room
+
+	// Guards send against being written to after it is closed
+	mu     sync.Mutex
+	closed bool
+}
+
+// deliver queues a message for the client without blocking, dropping it if
+// the client is gone or too far behind. Clients that miss a room message see
+// a gap in revisions and ask for a sync.
+func (c *Client) deliver(message []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	select {
+	case c.send <- message:
+		return true
+	default:
+		log.Printf("Dropped message for client %s (User: %s): send buffer full", c.conn.RemoteAddr(), c.userID)
+		return false
+	}
+}
+
+// sendJSON encodes and delivers a room message to the client
+func (c *Client) sendJSON(msg roomMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to encode %s message: %v", msg.Type, err)
+		return
+	}
+	c.deliver(data)
+}
+
+// close closes the send channel once, which makes writePump hang up
+func (c *Client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+// handleMessage dispatches a JSON message from the client to its room
+func (c *Client) handleMessage(message []byte) {
+	var msg roomMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		c.sendJSON(roomMessage{Type: "error", Error: "invalid message: " + err.Error()})
+		return
+	}
+	if msg.Type == "join" {
+		if msg.SessionID == "" {
+			msg.SessionID = primitive.NewObjectID().Hex()
+		}
+		if err := c.hub.joinRoom(c, msg.ProjectID, msg.SessionID); err != nil {
+			c.sendJSON(roomMessage{Type: "error", ProjectID: msg.ProjectID, Error: err.Error()})
+		}
+		return
+	}
+	if c.room == nil || msg.ProjectID != c.room.projectID {
+		c.sendJSON(roomMessage{Type: "error", ProjectID: msg.ProjectID, Error: "join the project first"})
+		return
+	}
+	switch msg.Type {
+	case "leave":
+		c.hub.leaveRoom(c)
+	case "operation":
+		c.room.applyOperation(c, msg)
+	case "undo", "redo":
+		c.room.undo(c, msg, msg.Type == "redo")
+	case "presence":
+		c.room.updatePresence(c, msg)
+	case "sync":
+		c.room.sync(c)
+	default:
+		c.sendJSON(roomMessage{Type: "error", ProjectID: msg.ProjectID, Error: "unknown message type " + msg.Type})
+	}
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -57,6 +137,7 @@ type Client struct {
 // all reads from this goroutine.
 func (c *Client) readPump() {
 	defer func() {
+		c.hub.leaveRoom(c)
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
@@ -71,10 +152,8 @@ func (c *Client) readPump() {
 			}
 			break
 		}
-		// For POC, we're not expecting messages *from* the client,
-		// but if you wanted to handle client messages, this is where you'd do it.
-		// c.hub.broadcast <- bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
-		log.Printf("Received message from client %s (User: %s): %s", c.conn.RemoteAddr(), c.userID, message)
+		// Clients only send messages to take part in project rooms
+		c.handleMessage(bytes.TrimSpace(message))
 	}
 }
 
@@ -99,20 +178,8 @@ func (c *Client) writePump() {
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// Add queued chat messages to the current websocket message.
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write(newline)
-				w.Write(<-c.send)
-			}
-
-			if err := w.Close(); err != nil {
+			// One JSON message per frame, so clients can parse every frame
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -3,6 +3,7 @@ package websocket
 import (
 	"log"
 	"sync"
+	"time"
 )
 
 // Hub maintains the set of active clients and broadcasts messages to the clients.
@@ -22,16 +23,23 @@ type Hub struct {
 	// Map to store clients by user ID for targeted broadcasts
 	userClients map[string]map[*Client]bool
 	mu          sync.RWMutex // Mutex for userClients map
+
+	// Collaborative editing rooms by project ID
+	rooms   map[string]*Room
+	roomsMu sync.Mutex
+	store   ProjectStore
 }
 
-// NewHub creates a new Hub
-func NewHub() *Hub {
+// NewHub creates a new Hub. Rooms read and edit projects through store.
+func NewHub(store ProjectStore) *Hub {
 	return &Hub{
 		broadcast:   make(chan []byte),
 		register:    make(chan *Client),
 		unregister:  make(chan *Client),
 		clients:     make(map[*Client]bool),
 		userClients: make(map[string]map[*Client]bool),
+		rooms:       make(map[string]*Room),
+		store:       store,
 	}
 }
 
@@ -58,7 +66,7 @@ func (h *Hub) Run() {
 					delete(h.userClients, client.userID)
 				}
 				h.mu.Unlock()
-				close(client.send)
+				client.close()
 				log.Printf("Client unregistered: %s (User: %s)", client.conn.RemoteAddr(), client.userID)
 			}
 
@@ -67,7 +75,7 @@ func (h *Hub) Run() {
 				select {
 				case client.send <- message:
 				default:
-					close(client.send)
+					client.close()
 					delete(h.clients, client)
 				}
 			}
@@ -87,7 +95,7 @@ func (h *Hub) BroadcastToUser(userID string, message string) {
 			case client.send <- []byte(message):
 			default:
 				// If sending fails, unregister the client
-				close(client.send)
+				client.close()
 				delete(h.clients, client)             // Remove from general clients map
 				delete(h.userClients[userID], client) // Remove from user-specific map
 				if len(h.userClients[userID]) == 0 {
@@ -100,3 +108,44 @@ func (h *Hub) BroadcastToUser(userID string, message string) {
 		log.Printf("No active WebSocket connections for user %s.", userID)
 	}
 }
+
+// joinRoom moves a client into the room of a project, leaving any room it
+// was in. The room is created by the first client to join.
+func (h *Hub) joinRoom(c *Client, projectID, sessionID string) error {
+	h.leaveRoom(c)
+
+	h.roomsMu.Lock()
+	room, ok := h.rooms[projectID]
+	if !ok {
+		room = newRoom(projectID, h.store)
+		h.rooms[projectID] = room
+	}
+	// Counted until the client is in, so the room isn't dropped as empty first
+	room.mu.Lock()
+	room.joining++
+	room.mu.Unlock()
+	h.roomsMu.Unlock()
+
+	c.room = room
+	participant := &Participant{UserID: c.userID, SessionID: sessionID, JoinedAt: time.Now()}
+	if err := room.join(c, participant); err != nil {
+		h.leaveRoom(c)
+		return err
+	}
+	return nil
+}
+
+// leaveRoom takes a client out of its room, dropping the room once empty
+func (h *Hub) leaveRoom(c *Client) {
+	room := c.room
+	if room == nil {
+		return
+	}
+	c.room = nil
+
+	h.roomsMu.Lock()
+	defer h.roomsMu.Unlock()
+	if room.leave(c) && h.rooms[room.projectID] == room {
+		delete(h.rooms, room.projectID)
+	}
+}
@@ -0,0 +1,352 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"video-editor/models"
+)
+
+// roomHistorySize is how many applied operations a room remembers for
+// rebasing operations sent against an older revision
+const roomHistorySize = 200
+
+// ProjectStore reads and edits the projects that rooms are opened on.
+// services.ProjectService implements it.
+type ProjectStore interface {
+	GetProject(projectID, userID string) (*models.Project, error)
+	ApplyOperation(projectID, userID, sessionID string, op models.TimelineOperation) (*models.Project, *models.OperationLogEntry, error)
+	Undo(projectID, userID, sessionID string) (*models.Project, *models.OperationLogEntry, error)
+	Redo(projectID, userID, sessionID string) (*models.Project, *models.OperationLogEntry, error)
+}
+
+// Participant is the presence of one client in a room
+type Participant struct {
+	UserID         string    `json:"user_id"`
+	SessionID      string    `json:"session_id"`
+	SelectedClipID string    `json:"selected_clip_id,omitempty"`
+	Playhead       float64   `json:"playhead"` // In seconds
+	JoinedAt       time.Time `json:"joined_at"`
+}
+
+// roomMessage is the JSON sent over a connection in a room, in both
+// directions. Which fields are set depends on Type.
+type roomMessage struct {
+	Type       string                    `json:"type"`
+	ProjectID  string                    `json:"project_id,omitempty"`
+	SessionID  string                    `json:"session_id,omitempty"`
+	UserID     string                    `json:"user_id,omitempty"`
+	Revision   int64                     `json:"revision,omitempty"` // The base revision from clients, the resulting one from the server
+	ClientOpID string                    `json:"client_op_id,omitempty"`
+	Operation  *models.TimelineOperation `json:"operation,omitempty"`
+	Timeline   *models.Timeline          `json:"timeline,omitempty"`
+
+	// Presence
+	SelectedClipID string        `json:"selected_clip_id,omitempty"`
+	Playhead       *float64      `json:"playhead,omitempty"`
+	Participant    *Participant  `json:"participant,omitempty"`
+	Participants   []Participant `json:"participants,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// appliedOp is an operation a room has applied, kept to check later
+// operations against
+type appliedOp struct {
+	revision int64
+	op       models.TimelineOperation
+}
+
+// Room is the set of clients editing one project together. Operations are
+// handled one at a time, which puts them in the order every client sees.
+type Room struct {
+	projectID string
+	store     ProjectStore
+
+	mu           sync.Mutex
+	participants map[*Client]*Participant
+	joining      int         // Clients on their way in
+	revision     int64       // Latest revision the room knows of
+	history      []appliedOp // Oldest first, at most roomHistorySize
+}
+
+func newRoom(projectID string, store ProjectStore) *Room {
+	return &Room{
+		projectID:    projectID,
+		store:        store,
+		participants: make(map[*Client]*Participant),
+	}
+}
+
+// join sends the client the project's timeline and everyone present, and
+// tells the others it has arrived
+func (r *Room) join(c *Client, participant *Participant) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.joining--
+	project, err := r.store.GetProject(r.projectID, c.userID)
+	if err != nil {
+		return err
+	}
+	r.participants[c] = participant
+	r.catchUp(project.Revision)
+
+	present := make([]Participant, 0, len(r.participants))
+	for _, p := range r.participants {
+		present = append(present, *p)
+	}
+	c.sendJSON(roomMessage{
+		Type:         "joined",
+		ProjectID:    r.projectID,
+		SessionID:    participant.SessionID,
+		Revision:     project.Revision,
+		Timeline:     project.Timeline,
+		Participants: present,
+	})
+	r.broadcast(c, roomMessage{Type: "presence", ProjectID: r.projectID, Participant: participant})
+	return nil
+}
+
+// leave removes the client and reports whether the room is now empty
+func (r *Room) leave(c *Client) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	participant, ok := r.participants[c]
+	if ok {
+		delete(r.participants, c)
+		r.broadcast(nil, roomMessage{Type: "left", ProjectID: r.projectID, Participant: participant})
+	}
+	return len(r.participants) == 0 && r.joining == 0
+}
+
+// updatePresence records where a client's selection and playhead are and
+// passes it on to the others
+func (r *Room) updatePresence(c *Client, msg roomMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	participant, ok := r.participants[c]
+	if !ok {
+		return
+	}
+	participant.SelectedClipID = msg.SelectedClipID
+	if msg.Playhead != nil {
+		participant.Playhead = *msg.Playhead
+	}
+	r.broadcast(c, roomMessage{Type: "presence", ProjectID: r.projectID, Participant: participant})
+}
+
+// sync sends the client the current timeline, e.g. after it missed a message
+func (r *Room) sync(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	project, err := r.store.GetProject(r.projectID, c.userID)
+	if err != nil {
+		c.sendJSON(roomMessage{Type: "error", ProjectID: r.projectID, Error: err.Error()})
+		return
+	}
+	r.catchUp(project.Revision)
+	c.sendJSON(roomMessage{Type: "sync", ProjectID: r.projectID, Revision: project.Revision, Timeline: project.Timeline})
+}
+
+// applyOperation orders an operation after every one the room has applied.
+// An operation sent against an older revision is rebased onto the current
+// timeline if nothing applied since touched what it touches; otherwise it
+// is rejected and the sender gets the current timeline to retry from.
+func (r *Room) applyOperation(c *Client, msg roomMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	participant, ok := r.participants[c]
+	if !ok {
+		return
+	}
+	if msg.Operation == nil {
+		r.reject(c, msg.ClientOpID, "operation is required")
+		return
+	}
+	if reason := r.rebase(msg.Revision, *msg.Operation); reason != "" {
+		r.reject(c, msg.ClientOpID, reason)
+		return
+	}
+	project, entry, err := r.store.ApplyOperation(r.projectID, c.userID, participant.SessionID, *msg.Operation)
+	if err != nil {
+		r.reject(c, msg.ClientOpID, err.Error())
+		return
+	}
+	r.applied(participant, msg.ClientOpID, project, entry.Operation)
+}
+
+// undo reverts the client's latest operation, or redo applies its latest
+// undone one again, as an operation every participant receives
+func (r *Room) undo(c *Client, msg roomMessage, redo bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	participant, ok := r.participants[c]
+	if !ok {
+		return
+	}
+	var project *models.Project
+	var entry *models.OperationLogEntry
+	var err error
+	if redo {
+		project, entry, err = r.store.Redo(r.projectID, c.userID, participant.SessionID)
+	} else {
+		project, entry, err = r.store.Undo(r.projectID, c.userID, participant.SessionID)
+	}
+	if err != nil {
+		r.reject(c, msg.ClientOpID, err.Error())
+		return
+	}
+	op := entry.Operation
+	if !redo {
+		op = entry.Inverse
+	}
+	r.applied(participant, msg.ClientOpID, project, op)
+}
+
+// rebase checks an operation sent against base against the operations
+// applied since, returning why it can't be applied or "" if it can
+func (r *Room) rebase(base int64, op models.TimelineOperation) string {
+	if base == r.revision {
+		return ""
+	}
+	if base > r.revision {
+		return fmt.Sprintf("revision %d is ahead of the room", base)
+	}
+	seen := int64(0)
+	for _, applied := range r.history {
+		if applied.revision <= base {
+			continue
+		}
+		seen++
+		if operationsConflict(applied.op, op) {
+			return fmt.Sprintf("conflicts with the change made in revision %d", applied.revision)
+		}
+	}
+	// Revisions written outside the room, or forgotten, can't be checked
+	if seen != r.revision-base {
+		return fmt.Sprintf("timeline changed since revision %d", base)
+	}
+	return ""
+}
+
+// applied records an operation and sends it to everyone in the room. The
+// sender recognizes it by its client_op_id. If the timeline was also written
+// outside the room, everyone gets the whole timeline instead.
+func (r *Room) applied(participant *Participant, clientOpID string, project *models.Project, op models.TimelineOperation) {
+	msg := roomMessage{
+		Type:       "operation",
+		ProjectID:  r.projectID,
+		UserID:     participant.UserID,
+		SessionID:  participant.SessionID,
+		Revision:   project.Revision,
+		ClientOpID: clientOpID,
+		Operation:  &op,
+	}
+	if project.Revision != r.revision+1 {
+		msg.Type, msg.Timeline = "sync", project.Timeline
+	}
+	r.revision = project.Revision
+	r.history = append(r.history, appliedOp{revision: project.Revision, op: op})
+	if len(r.history) > roomHistorySize {
+		r.history = r.history[len(r.history)-roomHistorySize:]
+	}
+	r.broadcast(nil, msg)
+}
+
+// reject tells the sender its operation was not applied, with the current
+// timeline to continue from
+func (r *Room) reject(c *Client, clientOpID, reason string) {
+	msg := roomMessage{Type: "rejected", ProjectID: r.projectID, ClientOpID: clientOpID, Error: reason}
+	if project, err := r.store.GetProject(r.projectID, c.userID); err == nil {
+		r.catchUp(project.Revision)
+		msg.Revision, msg.Timeline = project.Revision, project.Timeline
+	}
+	c.sendJSON(msg)
+}
+
+// catchUp moves the room to a revision read from the store. Revisions it
+// skips were written outside the room, so older bases can't be rebased.
+func (r *Room) catchUp(revision int64) {
+	if revision > r.revision {
+		r.revision = revision
+	}
+}
+
+// broadcast sends a message to every participant except skip
+func (r *Room) broadcast(skip *Client, msg roomMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to encode %s message for project %s: %v", msg.Type, r.projectID, err)
+		return
+	}
+	for c := range r.participants {
+		if c != skip {
+			c.deliver(data)
+		}
+	}
+}
+
+// operationTargets returns the clips and transitions an operation reads or
+// changes
+func operationTargets(op models.TimelineOperation) []string {
+	var targets []string
+	add := func(id string) {
+		if id != "" {
+			targets = append(targets, id)
+		}
+	}
+	add(op.ClipID)
+	add(op.NewClipID)
+	add(op.TransitionID)
+	if op.Clip != nil {
+		add(op.Clip.ID)
+	}
+	if op.Transition != nil {
+		add(op.Transition.ID)
+		add(op.Transition.FromClipID)
+		add(op.Transition.ToClipID)
+	}
+	for _, t := range op.Transitions {
+		add(t.ID)
+	}
+	return targets
+}
+
+// operationsConflict reports whether two operations from different clients
+// can't both be applied in either order with the same result. Operations on
+// different clips never conflict, and neither do changes to different
+// properties of the same clip or a property change and a move or trim.
+func operationsConflict(a, b models.TimelineOperation) bool {
+	shared := false
+	targets := make(map[string]bool)
+	for _, id := range operationTargets(a) {
+		targets[id] = true
+	}
+	for _, id := range operationTargets(b) {
+		if targets[id] {
+			shared = true
+			break
+		}
+	}
+	if !shared {
+		return false
+	}
+
+	placement := func(t string) bool { return t == models.OpMoveClip || t == models.OpTrimClip }
+	switch {
+	case a.Type == models.OpSetProperty && b.Type == models.OpSetProperty:
+		return a.Property == b.Property
+	case a.Type == models.OpSetProperty && placement(b.Type), placement(a.Type) && b.Type == models.OpSetProperty:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,321 @@
+package websocket_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"video-editor/models"
+	"video-editor/services"
+	"video-editor/websocket"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+const projectID = "project-1"
+
+// memoryStore keeps one project in memory and applies operations the way
+// the project service does, one revision each
+type memoryStore struct {
+	mu      sync.Mutex
+	project models.Project
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{project: models.Project{
+		Name:     "Shared",
+		Revision: 1,
+		Timeline: &models.Timeline{
+			AspectRatio: "16:9",
+			Duration:    8,
+			MediaItems: []models.TimelineItem{
+				{ID: "c1", Type: "video", AssetID: "a", StartTime: 0, EndTime: 4, Duration: 4},
+				{ID: "c2", Type: "video", AssetID: "b", StartTime: 4, EndTime: 8, Duration: 4},
+				{ID: "t1", Type: "text", Text: "Title", Track: 1, StartTime: 0, EndTime: 2, Duration: 2},
+			},
+		},
+	}}
+}
+
+func (s *memoryStore) GetProject(id, userID string) (*models.Project, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id != projectID {
+		return nil, services.ErrProjectNotFound
+	}
+	project := s.project
+	return &project, nil
+}
+
+func (s *memoryStore) ApplyOperation(id, userID, sessionID string, op models.TimelineOperation) (*models.Project, *models.OperationLogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	timeline, inverse, err := services.ApplyOperation(s.project.Timeline, &op)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.project.Timeline = timeline
+	s.project.Revision++
+	project := s.project
+	return &project, &models.OperationLogEntry{Revision: project.Revision, Operation: op, Inverse: *inverse}, nil
+}
+
+func (s *memoryStore) Undo(id, userID, sessionID string) (*models.Project, *models.OperationLogEntry, error) {
+	return nil, nil, services.ErrNothingToUndo
+}
+
+func (s *memoryStore) Redo(id, userID, sessionID string) (*models.Project, *models.OperationLogEntry, error) {
+	return nil, nil, services.ErrNothingToRedo
+}
+
+// message is a room message as a client reads it
+type message struct {
+	Type        string                    `json:"type"`
+	ProjectID   string                    `json:"project_id"`
+	SessionID   string                    `json:"session_id"`
+	Revision    int64                     `json:"revision"`
+	ClientOpID  string                    `json:"client_op_id"`
+	Operation   *models.TimelineOperation `json:"operation"`
+	Timeline    *models.Timeline          `json:"timeline"`
+	Participant *websocket.Participant    `json:"participant"`
+	Error       string                    `json:"error"`
+}
+
+type roomClient struct {
+	t        *testing.T
+	conn     *gorilla.Conn
+	session  string
+	received []message // Every message read so far
+}
+
+func dial(t *testing.T, server *httptest.Server, user, session string) *roomClient {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?user=" + user
+	conn, _, err := gorilla.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &roomClient{t: t, conn: conn, session: session}
+}
+
+func (c *roomClient) send(msg map[string]interface{}) {
+	c.t.Helper()
+	msg["project_id"] = projectID
+	if err := c.conn.WriteJSON(msg); err != nil {
+		c.t.Fatalf("%s: send: %v", c.session, err)
+	}
+}
+
+// next reads until a message of the given type, or any message for "",
+// failing on a frame that isn't exactly one JSON message
+func (c *roomClient) next(msgType string) message {
+	c.t.Helper()
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, frame, err := c.conn.ReadMessage()
+		if err != nil {
+			c.t.Fatalf("%s: waiting for %s: %v", c.session, msgType, err)
+		}
+		var msg message
+		if err := json.Unmarshal(frame, &msg); err != nil {
+			c.t.Fatalf("%s: frame is not one JSON message: %v\n%s", c.session, err, frame)
+		}
+		c.received = append(c.received, msg)
+		if msgType == "" || msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+// hasReceived reports whether a message of a type for an operation came in
+func (c *roomClient) hasReceived(msgType, clientOpID string) bool {
+	for _, msg := range c.received {
+		if msg.Type == msgType && msg.ClientOpID == clientOpID {
+			return true
+		}
+	}
+	return false
+}
+
+// operations returns the operations received so far, in order
+func (c *roomClient) operations() []message {
+	var ops []message
+	for _, msg := range c.received {
+		if msg.Type == "operation" || msg.Type == "sync" {
+			ops = append(ops, msg)
+		}
+	}
+	return ops
+}
+
+func TestRoomOrdersConcurrentOperations(t *testing.T) {
+	store := newMemoryStore()
+	hub := websocket.NewHub(store)
+	go hub.Run()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		websocket.ServeWs(hub, w, r, r.URL.Query().Get("user"))
+	}))
+	defer server.Close()
+
+	clients := []*roomClient{
+		dial(t, server, "alice", "tab-a"),
+		dial(t, server, "bob", "tab-b"),
+		dial(t, server, "carol", "tab-c"),
+	}
+	a, b, c := clients[0], clients[1], clients[2]
+	for i, client := range clients {
+		client.send(map[string]interface{}{"type": "join", "session_id": client.session})
+		joined := client.next("joined")
+		if joined.Revision != 1 || joined.Timeline == nil || len(joined.Timeline.MediaItems) != 3 {
+			t.Fatalf("%s joined at revision %d with %v", client.session, joined.Revision, joined.Timeline)
+		}
+		// Everyone already in sees the newcomer
+		for _, other := range clients[:i] {
+			if p := other.next("presence").Participant; p == nil || p.SessionID != client.session {
+				t.Fatalf("%s: expected presence of %s, got %+v", other.session, client.session, p)
+			}
+		}
+	}
+
+	// Alice moves c1; every client gets it as revision 2
+	a.send(map[string]interface{}{"type": "operation", "revision": 1, "client_op_id": "a1",
+		"operation": map[string]interface{}{"type": "move_clip", "clip_id": "c1", "start_time": 10}})
+	for _, client := range clients {
+		if msg := client.next("operation"); msg.Revision != 2 || msg.ClientOpID != "a1" || msg.SessionID != "tab-a" {
+			t.Fatalf("%s: got %+v, want a1 at revision 2", client.session, msg)
+		}
+	}
+
+	// Bob trims c1 from revision 1, not having seen the move: rejected
+	b.send(map[string]interface{}{"type": "operation", "revision": 1, "client_op_id": "b1",
+		"operation": map[string]interface{}{"type": "trim_clip", "clip_id": "c1", "duration": 2}})
+	rejected := b.next("rejected")
+	if rejected.ClientOpID != "b1" || rejected.Revision != 2 || rejected.Timeline == nil {
+		t.Fatalf("bob: got %+v, want b1 rejected with revision 2", rejected)
+	}
+	if clip := rejected.Timeline.MediaItems[0]; clip.StartTime != 10 || clip.Duration != 4 {
+		t.Errorf("bob: rejected with clip %+v, want the moved clip", clip)
+	}
+
+	// Carol renames c1 from revision 1: a different field, so it is rebased
+	c.send(map[string]interface{}{"type": "operation", "revision": 1, "client_op_id": "c1",
+		"operation": map[string]interface{}{"type": "set_property", "clip_id": "c1", "property": "name", "value": "Intro"}})
+	for _, client := range clients {
+		if msg := client.next("operation"); msg.Revision != 3 || msg.ClientOpID != "c1" {
+			t.Fatalf("%s: got %+v, want c1 at revision 3", client.session, msg)
+		}
+	}
+
+	// Everyone edits at once from revision 3. Alice's move touches another
+	// clip, but bob and carol both set the text of t1: whichever the room
+	// orders second is rejected.
+	var wg sync.WaitGroup
+	concurrent := map[*roomClient]map[string]interface{}{
+		a: {"type": "move_clip", "clip_id": "c2", "start_time": 20},
+		b: {"type": "set_property", "clip_id": "t1", "property": "text", "value": "Hello"},
+		c: {"type": "set_property", "clip_id": "t1", "property": "text", "value": "Bonjour"},
+	}
+	for client, op := range concurrent {
+		wg.Add(1)
+		go func(client *roomClient, op map[string]interface{}) {
+			defer wg.Done()
+			client.conn.WriteJSON(map[string]interface{}{"type": "operation", "project_id": projectID,
+				"revision": 3, "client_op_id": client.session + "-2", "operation": op})
+		}(client, op)
+	}
+	wg.Wait()
+	for _, client := range clients {
+		for len(client.operations()) < 4 {
+			client.next("")
+		}
+	}
+	applied := map[string]bool{}
+	for _, msg := range a.operations()[2:] {
+		applied[msg.ClientOpID] = true
+	}
+	if !applied["tab-a-2"] || applied["tab-b-2"] == applied["tab-c-2"] {
+		t.Fatalf("applied %v, want alice's and one of bob's and carol's", applied)
+	}
+	loser := b
+	if applied["tab-b-2"] {
+		loser = c
+	}
+	for !loser.hasReceived("rejected", loser.session+"-2") {
+		loser.next("")
+	}
+
+	// A burst of presence updates arrives as one message per frame
+	for i := 0; i < 50; i++ {
+		a.send(map[string]interface{}{"type": "presence", "playhead": float64(i)})
+	}
+	for _, client := range []*roomClient{b, c} {
+		for i := 0; i < 50; i++ {
+			client.next("presence")
+		}
+	}
+
+	// Every client saw the same operations in the same order, one revision
+	// apart, and none saw bob's rejected one
+	want := a.operations()
+	if len(want) != 4 {
+		t.Fatalf("alice received %d operations, want 4", len(want))
+	}
+	for _, client := range clients {
+		got := client.operations()
+		if len(got) != len(want) {
+			t.Fatalf("%s received %d operations, want %d", client.session, len(got), len(want))
+		}
+		for i := range got {
+			if got[i].Type != "operation" || got[i].Revision != int64(i+2) || got[i].ClientOpID != want[i].ClientOpID {
+				t.Errorf("%s operation %d: %s %q at revision %d, alice got %q", client.session, i, got[i].Type, got[i].ClientOpID, got[i].Revision, want[i].ClientOpID)
+			}
+			if got[i].ClientOpID == "b1" || got[i].ClientOpID == loser.session+"-2" {
+				t.Errorf("%s received a rejected operation", client.session)
+			}
+		}
+	}
+
+	// Replaying the broadcast operations gives the stored timeline
+	initial := newMemoryStore().project.Timeline
+	for _, msg := range want {
+		next, _, err := services.ApplyOperation(initial, msg.Operation)
+		if err != nil {
+			t.Fatalf("replay %s: %v", msg.ClientOpID, err)
+		}
+		initial = next
+	}
+	stored, _ := store.GetProject(projectID, "alice")
+	replayed, _ := json.Marshal(initial)
+	current, _ := json.Marshal(stored.Timeline)
+	if string(replayed) != string(current) || stored.Revision != 5 {
+		t.Errorf("stored revision %d timeline\n%s\nreplayed\n%s", stored.Revision, current, replayed)
+	}
+}
+
+func TestRoomRejectsUnknownProject(t *testing.T) {
+	hub := websocket.NewHub(newMemoryStore())
+	go hub.Run()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		websocket.ServeWs(hub, w, r, "alice")
+	}))
+	defer server.Close()
+
+	client := dial(t, server, "alice", "tab-a")
+	if err := client.conn.WriteJSON(map[string]interface{}{"type": "join", "project_id": "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := client.next("error"); msg.Error != services.ErrProjectNotFound.Error() {
+		t.Errorf("got error %q", msg.Error)
+	}
+	// Operations need a room first
+	client.send(map[string]interface{}{"type": "operation", "revision": 1,
+		"operation": map[string]interface{}{"type": "delete_clip", "clip_id": "c1"}})
+	if msg := client.next("error"); msg.Error != "join the project first" {
+		t.Errorf("got error %q", msg.Error)
+	}
+}
@@ -4,12 +4,30 @@ import React, { useEffect } from 'react';
 import { useParams, useRouter } from 'next/navigation';
 import { useAppDispatch, useAppSelector } from '../../../redux/hooks';
 import { selectProjectById } from '../../../redux/projectsSlice';
-import { initializeProject } from '../../../redux/videoEditorSlice';
+import { initializeProject, MediaItem } from '../../../redux/videoEditorSlice';
+import { apiService } from '../../services/api';
 import { Header } from '../../../components/Header';
 import { VideoPreview } from '../../../components/VideoPreview';
 import { Timeline } from '../../../components/Timeline';
 import { Sidebar } from '../../../components/Sidebar';
 
+// Signed media URLs expire, so saved clips get fresh ones from their assets
+const refreshMediaUrls = async (items: MediaItem[]): Promise<MediaItem[]> => {
+  const assetIds = Array.from(new Set(items.map(item => item.assetId).filter(Boolean))) as string[];
+  const assets = await Promise.all(assetIds.map(id => apiService.getAsset(id).catch(() => null)));
+  const byId = new Map(assets.filter(Boolean).map(asset => [asset!.id, asset!]));
+  return items.map(item => {
+    const asset = item.assetId ? byId.get(item.assetId) : undefined;
+    if (!asset) return item;
+    return {
+      ...item,
+      url: apiService.resolveMediaUrl(asset.url),
+      proxyUrl: asset.proxy_url ? apiService.resolveMediaUrl(asset.proxy_url) : undefined,
+      posterUrl: asset.poster_url ? apiService.resolveMediaUrl(asset.poster_url) : undefined,
+    };
+  });
+};
+
 export default function EditorPage() {
   const params = useParams();
   const router = useRouter();
@@ -28,19 +46,41 @@ export default function EditorPage() {
         duration: 60,
         mediaItems: []
       }));
-    } else if (project) {
-      // Initialize existing project
-      dispatch(initializeProject({
-        projectId: project.id,
-        projectName: project.name,
-        duration: project.duration,
-        mediaItems: project.mediaItems || []
-      }));
-    } else if (projectId !== 'new') {
-      // Project not found, redirect to home
-      router.push('/');
       return;
     }
+
+    // Prefer the state saved on the backend, falling back to local projects
+    let cancelled = false;
+    apiService.getProject(projectId)
+      .then(async saved => {
+        const mediaItems = await refreshMediaUrls(saved.timeline?.mediaItems || []);
+        if (cancelled) return;
+        dispatch(initializeProject({
+          projectId: saved.id,
+          projectName: saved.name,
+          duration: saved.timeline?.duration || 0,
+          mediaItems,
+          revision: saved.revision
+        }));
+      })
+      .catch(() => {
+        if (cancelled) return;
+        if (project) {
+          // Initialize existing project
+          dispatch(initializeProject({
+            projectId: project.id,
+            projectName: project.name,
+            duration: project.duration,
+            mediaItems: project.mediaItems || []
+          }));
+        } else {
+          // Project not found, redirect to home
+          router.push('/');
+        }
+      });
+    return () => {
+      cancelled = true;
+    };
   }, [projectId, project, dispatch, router]);
 
   const handleBackToHome = () => {
@@ -59,4 +99,4 @@ export default function EditorPage() {
       </div>
     </div>
   );
-}
\ No newline at end of file
+}
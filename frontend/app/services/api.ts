@@ -3,8 +3,17 @@ const API_BASE_URL = 'http://localhost:8080'; // Adjust this to match your backe
 
 export interface ExportSettings {
   quality: 'high' | 'medium' | 'low';
-  format: 'mp4' | 'webm' | 'avi';
+  format: 'mp4' | 'mov' | 'webm' | 'avi';
   resolution: '1920x1080' | '1280x720' | '854x480';
+  rateControl?: 'crf' | 'cbr' | 'vbr' | 'target_size';
+  videoBitrate?: number; // kbps, for cbr/vbr
+  maxRate?: number; // kbps, peak rate for vbr
+  bufSize?: number; // kbps
+  audioBitrate?: number; // kbps
+  twoPass?: boolean;
+  targetSizeMB?: number; // for target_size, e.g. 8 or 25
+  preset?: string; // libx264 preset, e.g. 'veryfast'
+  frameRate?: number;
 }
 
 export interface ProjectData {
@@ -13,11 +22,64 @@ export interface ProjectData {
   aspectRatio: string;
 }
 
+export interface MediaMetadata {
+  format_name: string;
+  duration: number; // in seconds
+  bit_rate: number;
+  width?: number;
+  height?: number;
+  frame_rate?: number;
+  rotation?: number;
+  sample_rate?: number;
+  channels?: number;
+  channel_layout?: string;
+}
+
 export interface UploadedFile {
+  id: string; // media asset ID
   filename: string;
   url: string;
   size: number;
   type: string;
+  metadata?: MediaMetadata; // missing if the file could not be probed
+}
+
+export interface SavedTimeline {
+  mediaItems: any[];
+  duration: number;
+  aspectRatio: string;
+}
+
+export interface SavedProject {
+  id: string;
+  name: string;
+  timeline?: SavedTimeline;
+  revision: number; // incremented by every timeline save
+  updated_at: string;
+}
+
+export interface TemplateSlot {
+  name: string; // key of the value that fills it, e.g. "logo"
+  label?: string;
+  kind: 'asset' | 'text';
+  clip_ids: string[];
+  required: boolean;
+}
+
+export interface ProjectTemplate {
+  id: string;
+  name: string;
+  description?: string;
+  visibility: 'private' | 'workspace';
+  workspace_id?: string;
+  slots: TemplateSlot[];
+}
+
+// Thrown when the timeline was saved elsewhere (e.g. another tab) since it was loaded
+export class TimelineConflictError extends Error {
+  constructor(public revision: number, public timeline: SavedTimeline | null) {
+    super('This project was changed in another window');
+  }
 }
 
 class ApiService {
@@ -36,8 +98,7 @@ class ApiService {
   private getUploadHeaders(): HeadersInit {
     const token = this.getAuthToken();
     return {
-      // No auth headers needed for now
-      // ...(token && { 'Authorization': `Bearer ${token}` })
+      ...(token && { 'Authorization': `Bearer ${token}` })
     };
   }
 
@@ -65,7 +126,7 @@ class ApiService {
   async exportVideo(projectData: ProjectData, settings: ExportSettings): Promise<{ jobId: string; message: string }> {
     const response = await fetch(`${API_BASE_URL}/export`, {
       method: 'POST',
-      headers: { 'Content-Type': 'application/json' }, // No auth needed for now
+      headers: this.getAuthHeaders(),
       body: JSON.stringify({
         projectData,
         settings,
@@ -83,6 +144,79 @@ class ApiService {
     };
   }
 
+  async getAsset(assetId: string): Promise<UploadedFile & { proxy_url?: string; poster_url?: string }> {
+    const response = await fetch(`${API_BASE_URL}/assets/${assetId}`, {
+      headers: this.getAuthHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error(`Failed to load asset: ${response.statusText}`);
+    }
+
+    return response.json();
+  }
+
+  async getProject(projectId: string): Promise<SavedProject> {
+    const response = await fetch(`${API_BASE_URL}/projects/${projectId}`, {
+      headers: this.getAuthHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error(`Failed to load project: ${response.statusText}`);
+    }
+
+    return response.json();
+  }
+
+  // Saves the timeline on top of the revision it was loaded at and returns the new revision
+  async saveTimeline(projectId: string, revision: number, timeline: SavedTimeline): Promise<number> {
+    const response = await fetch(`${API_BASE_URL}/projects/${projectId}/timeline`, {
+      method: 'PUT',
+      headers: this.getAuthHeaders(),
+      body: JSON.stringify({ revision, timeline }),
+    });
+
+    if (response.status === 409) {
+      const current = await response.json();
+      throw new TimelineConflictError(current.revision, current.timeline);
+    }
+    if (!response.ok) {
+      throw new Error(`Save failed: ${response.statusText}`);
+    }
+
+    const result = await response.json();
+    return result.revision;
+  }
+
+  async listTemplates(): Promise<ProjectTemplate[]> {
+    const response = await fetch(`${API_BASE_URL}/templates`, {
+      headers: this.getAuthHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error(`Failed to load templates: ${response.statusText}`);
+    }
+
+    const result = await response.json();
+    return result.templates;
+  }
+
+  // Fills the template's slots by name with asset IDs or text
+  async createProjectFromTemplate(templateId: string, name: string, values: Record<string, string>): Promise<SavedProject> {
+    const response = await fetch(`${API_BASE_URL}/projects/from-template`, {
+      method: 'POST',
+      headers: this.getAuthHeaders(),
+      body: JSON.stringify({ template_id: templateId, name, values }),
+    });
+
+    if (!response.ok) {
+      const result = await response.json().catch(() => null);
+      throw new Error(result?.error || `Failed to create project: ${response.statusText}`);
+    }
+
+    return response.json();
+  }
+
   async login(email: string, password: string): Promise<string> {
     const response = await fetch(`${API_BASE_URL}/login`, {
       method: 'POST',
@@ -112,10 +246,19 @@ class ApiService {
     }
   }
 
+  // Media URLs are signed server paths, or absolute presigned URLs when files
+  // are stored in S3
+  resolveMediaUrl(url: string): string {
+    return /^https?:\/\//.test(url) ? url : `${API_BASE_URL}${url}`;
+  }
+
   // WebSocket connection for real-time updates
   connectWebSocket(onMessage: (message: string) => void): WebSocket | null {
-    // No auth required for now
-    const ws = new WebSocket(`ws://localhost:8080/ws`);
+    const token = this.getAuthToken();
+    if (!token) {
+      return null;
+    }
+    const ws = new WebSocket(`ws://localhost:8080/ws?token=${encodeURIComponent(token)}`);
     
     ws.onopen = () => {
       console.log('WebSocket connected');
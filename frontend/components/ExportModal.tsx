@@ -28,6 +28,8 @@ export const ExportModal: React.FC<ExportModalProps> = ({ projectData, onClose }
     if (jobId && isExporting) {
       const ws = apiService.connectWebSocket((message) => {
         console.log('Export progress:', message);
+        // Ignore updates about other jobs, e.g. proxy generation
+        if (!message.includes(jobId)) return;
         setExportProgress(message);
         
         // Check if export is complete
@@ -38,7 +40,7 @@ export const ExportModal: React.FC<ExportModalProps> = ({ projectData, onClose }
           // Extract download URL from message
           const urlMatch = message.match(/Output: (.+)$/);
           if (urlMatch) {
-            setDownloadUrl(`http://localhost:8080${urlMatch[1]}`);
+            setDownloadUrl(apiService.resolveMediaUrl(urlMatch[1]));
           }
         } else if (message.includes('Failed!')) {
           setIsExporting(false);
@@ -1,11 +1,15 @@
 'use client'
 
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
 import { ArrowLeftIcon, ArrowRightIcon, UndoIcon, RedoIcon, SaveIcon, UserIcon, DownloadIcon } from 'lucide-react';
 import { useAppDispatch, useAppSelector } from '../redux/hooks';
-import { selectProjectName, setProjectName, selectMediaItems, selectDuration } from '../redux/videoEditorSlice';
+import { selectProjectName, setProjectName, selectMediaItems, selectDuration, selectProjectId, selectRevision, setRevision, initializeProject } from '../redux/videoEditorSlice';
+import { apiService, TimelineConflictError } from '../app/services/api';
 import { ExportModal } from './ExportModal';
 
+// Only projects stored on the backend have an ObjectID and can be saved
+const isSavedProjectId = (id: string | null): id is string => !!id && /^[0-9a-f]{24}$/.test(id);
+
 export const Header = ({
   onBackToHome
 }: {
@@ -15,8 +19,11 @@ export const Header = ({
   const projectName = useAppSelector(selectProjectName);
   const mediaItems = useAppSelector(selectMediaItems);
   const duration = useAppSelector(selectDuration);
+  const projectId = useAppSelector(selectProjectId);
+  const revision = useAppSelector(selectRevision);
   const [isEditing, setIsEditing] = useState(false);
   const [showExportModal, setShowExportModal] = useState(false);
+  const [saveStatus, setSaveStatus] = useState<'idle' | 'saving' | 'saved' | 'error'>('idle');
 
   const handleNameClick = () => {
     setIsEditing(true);
@@ -36,6 +43,40 @@ export const Header = ({
     }
   };
 
+  // Any edit makes the last save outdated
+  useEffect(() => {
+    setSaveStatus('idle');
+  }, [mediaItems]);
+
+  const handleSave = async (baseRevision = revision) => {
+    if (!isSavedProjectId(projectId)) return;
+    setSaveStatus('saving');
+    try {
+      const saved = await apiService.saveTimeline(projectId, baseRevision, { mediaItems, duration, aspectRatio: '16:9' });
+      dispatch(setRevision(saved));
+      setSaveStatus('saved');
+    } catch (error) {
+      if (!(error instanceof TimelineConflictError)) {
+        console.error('Save failed:', error);
+        setSaveStatus('error');
+        return;
+      }
+      // Someone saved since we loaded: take their version, or overwrite it
+      if (window.confirm(`${error.message}. Load the latest version? Cancel keeps your changes and overwrites it.`)) {
+        dispatch(initializeProject({
+          projectId,
+          projectName,
+          duration: error.timeline?.duration || 0,
+          mediaItems: error.timeline?.mediaItems || [],
+          revision: error.revision
+        }));
+        setSaveStatus('idle');
+      } else {
+        await handleSave(error.revision);
+      }
+    }
+  };
+
   const handleExportClick = () => {
     setShowExportModal(true);
   };
@@ -89,8 +130,13 @@ export const Header = ({
             <button className="p-1 rounded hover:bg-gray-100">
               <RedoIcon size={18} className="text-gray-600" />
             </button>
-            <button className="p-1 rounded hover:bg-gray-100">
-              <SaveIcon size={18} className="text-gray-600" />
+            <button
+              onClick={() => handleSave()}
+              disabled={!isSavedProjectId(projectId) || saveStatus === 'saving'}
+              title={saveStatus === 'saved' ? 'Saved' : saveStatus === 'error' ? 'Save failed' : 'Save'}
+              className="p-1 rounded hover:bg-gray-100 disabled:opacity-50"
+            >
+              <SaveIcon size={18} className={saveStatus === 'error' ? 'text-red-500' : saveStatus === 'saved' ? 'text-green-600' : 'text-gray-600'} />
             </button>
           </div>
           <div className="flex items-center space-x-2">
@@ -67,6 +67,23 @@ export const Sidebar = () => {
     }
   }, [selectedItem]);
 
+  // Attach preview proxies and poster frames to items once the backend has
+  // generated them
+  const mediaItemsRef = useRef(mediaItems);
+  mediaItemsRef.current = mediaItems;
+  useEffect(() => {
+    const ws = apiService.connectWebSocket((message) => {
+      const match = message.match(/^Asset (\w+): (generate_proxy|generate_thumbnails) ready: (.+)$/);
+      if (!match) return;
+      const url = apiService.resolveMediaUrl(match[3]);
+      const updates = match[2] === 'generate_proxy' ? { proxyUrl: url } : { posterUrl: url };
+      mediaItemsRef.current
+        .filter(item => item.assetId === match[1])
+        .forEach(item => dispatch(updateMediaItem({ id: item.id, updates })));
+    });
+    return () => ws?.close();
+  }, [dispatch]);
+
   const handleUpdate = (updates: Partial<any>) => {
     if (selectedItemId) {
       dispatch(updateMediaItem({ id: selectedItemId, updates }));
@@ -120,7 +137,7 @@ export const Sidebar = () => {
       
       for (const uploadedFile of uploadedFiles) {
         const fileType = uploadedFile.type;
-        const serverUrl = `http://localhost:8080${uploadedFile.url}`;
+        const serverUrl = apiService.resolveMediaUrl(uploadedFile.url);
 
         if (fileType === 'video') {
           // Create a temporary video element to get the actual duration
@@ -139,6 +156,7 @@ export const Sidebar = () => {
               track: newTrack,
               color: '#9d84e8',
               url: serverUrl, // Use server URL
+              assetId: uploadedFile.id,
               isMuted: false
             };
             dispatch(addMediaItem(newItem));
@@ -161,6 +179,7 @@ export const Sidebar = () => {
               track: newTrack,
               color: '#4caf50',
               url: serverUrl, // Use server URL
+              assetId: uploadedFile.id,
               isMuted: false
             };
             dispatch(addMediaItem(newItem));
@@ -177,6 +196,7 @@ export const Sidebar = () => {
             endTime: defaultImageDuration,
             track: newTrack,
             url: serverUrl, // Use server URL
+            assetId: uploadedFile.id,
             position: {
               x: 50,
               y: 50
@@ -567,6 +567,9 @@ export const Timeline = () => {
                             left: `${timeToPosition(item.startTime)}px`,
                             width: `${timeToPosition(item.endTime - item.startTime)}px`,
                             backgroundColor: item.type === 'audio' ? 'transparent' : (item.type === 'video' ? '#9d84e8' : item.type === 'text' ? '#ff9800' : '#2196f3'),
+                            backgroundImage: item.posterUrl ? `url(${item.posterUrl})` : undefined,
+                            backgroundSize: 'auto 100%',
+                            backgroundRepeat: 'repeat-x',
                             opacity: item.isMuted ? 0.5 : 0.8
                           }}
                           onMouseDown={e => handleMouseDown(e, 'move', item.id)}
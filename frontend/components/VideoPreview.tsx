@@ -311,7 +311,7 @@ export const VideoPreview = () => {
           {item.type === 'video' && (
               <video
                   ref={ref => { if (ref) videoRefs.current[item.id] = ref; }}
-                  src={item.url}
+                  src={item.proxyUrl || item.url}
                   className="w-full h-full object-cover"
                   muted={item.isMuted}
                   loop
@@ -10,7 +10,10 @@ export type MediaItem = {
   track: number; // track number
   color?: string; // for visual distinction
   content?: string; // for text
-  url?: string; // for video/audio/image
+  url?: string; // for video/audio/image, used for preview
+  assetId?: string; // uploaded asset the renderer reads video/audio/image from
+  proxyUrl?: string; // low-resolution copy of a video for smooth preview
+  posterUrl?: string; // representative frame shown on timeline clips
   position?: {
     x: number;
     y: number;
@@ -32,6 +35,7 @@ interface VideoEditorState {
   selectedItemId: string | null;
   projectName: string;
   projectId: string | null;
+  revision: number; // timeline revision last loaded from or saved to the backend
   isDraggingText: boolean;
   activeTextItem: string | null;
   showTrimControls: boolean;
@@ -104,6 +108,7 @@ const initialState: VideoEditorState = {
   selectedItemId: null,
   projectName: 'Untitled project',
   projectId: null,
+  revision: 0,
   isDraggingText: false,
   activeTextItem: null,
   showTrimControls: false,
@@ -175,10 +180,14 @@ const videoEditorSlice = createSlice({
     setTrimItemId: (state, action: PayloadAction<string | null>) => {
       state.trimItemId = action.payload;
     },
-    initializeProject: (state, action: PayloadAction<{ projectId: string; projectName: string; duration: number; mediaItems?: MediaItem[] }>) => {
-      const { projectId, projectName, mediaItems } = action.payload; // Removed duration from destructuring
+    setRevision: (state, action: PayloadAction<number>) => {
+      state.revision = action.payload;
+    },
+    initializeProject: (state, action: PayloadAction<{ projectId: string; projectName: string; duration: number; mediaItems?: MediaItem[]; revision?: number }>) => {
+      const { projectId, projectName, mediaItems, revision } = action.payload; // Removed duration from destructuring
       state.projectId = projectId;
       state.projectName = projectName;
+      state.revision = revision ?? 0;
       if (mediaItems) {
         state.mediaItems = mediaItems;
       }
@@ -209,6 +218,7 @@ export const {
   setActiveTextItem,
   setShowTrimControls,
   setTrimItemId,
+  setRevision,
   initializeProject
 } = videoEditorSlice.actions;
 
@@ -222,6 +232,7 @@ export const selectMediaItems = (state: { videoEditor: VideoEditorState }) => st
 export const selectSelectedItemId = (state: { videoEditor: VideoEditorState }) => state.videoEditor.selectedItemId;
 export const selectProjectName = (state: { videoEditor: VideoEditorState }) => state.videoEditor.projectName;
 export const selectProjectId = (state: { videoEditor: VideoEditorState }) => state.videoEditor.projectId;
+export const selectRevision = (state: { videoEditor: VideoEditorState }) => state.videoEditor.revision;
 export const selectIsDraggingText = (state: { videoEditor: VideoEditorState }) => state.videoEditor.isDraggingText;
 export const selectActiveTextItem = (state: { videoEditor: VideoEditorState }) => state.videoEditor.activeTextItem;
 export const selectShowTrimControls = (state: { videoEditor: VideoEditorState }) => state.videoEditor.showTrimControls;